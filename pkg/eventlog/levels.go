@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventlog
+
+import (
+	"bytes"
+	"github.com/rs/zerolog"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Log levels can be changed at runtime, globally and per component. Like the zerolog global level, the levels are process
+// wide.
+//
+// Levels are enforced by the level-filtering writer that is applied by NewZeroLogger. The writer uses the log event's
+// component field, which is set by ForComponent, to look up the component level. The zerolog global level is kept at
+// the lowest level in use, in order for component levels that are lower than the global level to take effect. Thus,
+// levels should be changed via SetLevel, and not via zerolog.SetGlobalLevel.
+var levels = struct {
+	sync.Mutex              // used to serialize level changes
+	global     int32        // zerolog.Level
+	components atomic.Value // map[string]zerolog.Level - copy on write
+}{
+	global: int32(zerolog.DebugLevel),
+}
+
+func init() {
+	levels.components.Store(map[string]zerolog.Level{})
+}
+
+// SetLevel sets the global log level, which applies to all loggers that do not have a component level set.
+func SetLevel(level zerolog.Level) {
+	levels.Lock()
+	defer levels.Unlock()
+	atomic.StoreInt32(&levels.global, int32(level))
+	updateZerologGlobalLevel()
+}
+
+// Level returns the global log level
+func Level() zerolog.Level {
+	return zerolog.Level(atomic.LoadInt32(&levels.global))
+}
+
+// SetComponentLevel sets the log level for the component loggers that were created via ForComponent.
+func SetComponentLevel(component string, level zerolog.Level) {
+	levels.Lock()
+	defer levels.Unlock()
+	components := ComponentLevels()
+	components[component] = level
+	levels.components.Store(components)
+	updateZerologGlobalLevel()
+}
+
+// ResetComponentLevel removes the component log level, i.e., the component reverts to the global log level.
+func ResetComponentLevel(component string) {
+	levels.Lock()
+	defer levels.Unlock()
+	components := ComponentLevels()
+	delete(components, component)
+	levels.components.Store(components)
+	updateZerologGlobalLevel()
+}
+
+// ComponentLevels returns a copy of the component log levels
+func ComponentLevels() map[string]zerolog.Level {
+	components := levels.components.Load().(map[string]zerolog.Level)
+	levelsCopy := make(map[string]zerolog.Level, len(components))
+	for component, level := range components {
+		levelsCopy[component] = level
+	}
+	return levelsCopy
+}
+
+// must be called while holding the levels lock
+func updateZerologGlobalLevel() {
+	min := Level()
+	for _, level := range levels.components.Load().(map[string]zerolog.Level) {
+		if level < min {
+			min = level
+		}
+	}
+	zerolog.SetGlobalLevel(min)
+}
+
+// levelWriter filters log events using the component log level, if set, and the global log level otherwise.
+type levelWriter struct {
+	io.Writer
+}
+
+// componentField is used to find the component field value in the JSON encoded log event. The component field is set
+// via the logger context, which precedes the log event fields.
+var componentField = []byte(`"` + Component + `":"`)
+
+// WriteLevel implements the zerolog.LevelWriter interface
+func (w levelWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	if !enabled(level, p) {
+		return len(p), nil
+	}
+	if lw, ok := w.Writer.(zerolog.LevelWriter); ok {
+		return lw.WriteLevel(level, p)
+	}
+	return w.Writer.Write(p)
+}
+
+func enabled(level zerolog.Level, p []byte) bool {
+	if components := levels.components.Load().(map[string]zerolog.Level); len(components) > 0 {
+		if i := bytes.Index(p, componentField); i >= 0 {
+			component := p[i+len(componentField):]
+			if j := bytes.IndexByte(component, '"'); j >= 0 {
+				if componentLevel, ok := components[string(component[:j])]; ok {
+					return level >= componentLevel
+				}
+			}
+		}
+	}
+	return level >= Level()
+}
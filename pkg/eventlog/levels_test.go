@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventlog_test
+
+import (
+	"bytes"
+	"github.com/oysterpack/andiamo/pkg/eventlog"
+	"github.com/rs/zerolog"
+	"strings"
+	"testing"
+)
+
+func TestComponentLevels(t *testing.T) {
+	defer eventlog.SetLevel(eventlog.Level())
+	buf := new(bytes.Buffer)
+	logger := eventlog.NewZeroLogger(buf)
+	foo := eventlog.ForComponent(&logger, "foo")
+	bar := eventlog.ForComponent(&logger, "bar")
+
+	eventlog.SetLevel(zerolog.InfoLevel)
+	eventlog.SetComponentLevel("foo", zerolog.DebugLevel)
+	if zerolog.GlobalLevel() != zerolog.DebugLevel {
+		t.Errorf("*** zerolog global level should be set to the lowest level: %v", zerolog.GlobalLevel())
+	}
+	if levels := eventlog.ComponentLevels(); len(levels) != 1 || levels["foo"] != zerolog.DebugLevel {
+		t.Errorf("*** component levels did not match: %v", levels)
+	}
+
+	logger.Debug().Msg("root-debug")
+	foo.Debug().Msg("foo-debug")
+	bar.Debug().Msg("bar-debug")
+	bar.Info().Msg("bar-info")
+	switch log := buf.String(); {
+	case strings.Contains(log, "root-debug") || strings.Contains(log, "bar-debug"):
+		t.Errorf("*** debug events should have been filtered using the global level: %v", log)
+	case !strings.Contains(log, "foo-debug"):
+		t.Errorf("*** foo debug event should have been logged using the component level: %v", log)
+	case !strings.Contains(log, "bar-info"):
+		t.Errorf("*** bar info event should have been logged: %v", log)
+	}
+
+	eventlog.ResetComponentLevel("foo")
+	buf.Reset()
+	foo.Debug().Msg("foo-debug")
+	if buf.Len() != 0 {
+		t.Errorf("*** foo should have reverted to the global level: %v", buf)
+	}
+	if zerolog.GlobalLevel() != zerolog.InfoLevel || len(eventlog.ComponentLevels()) != 0 {
+		t.Errorf("*** zerolog global level should have been reset: %v", zerolog.GlobalLevel())
+	}
+
+	// component levels that are higher than the global level are enforced, even if the logger is sampled
+	eventlog.SetComponentLevel("bar", zerolog.ErrorLevel)
+	defer eventlog.ResetComponentLevel("bar")
+	sampled := bar.Sample(&zerolog.BasicSampler{N: 1})
+	buf.Reset()
+	bar.Info().Msg("bar-info")
+	sampled.Warn().Msg("bar-warn")
+	bar.Error().Msg("bar-error")
+	logger.Info().Msg("root-info")
+	switch log := buf.String(); {
+	case strings.Contains(log, "bar-info") || strings.Contains(log, "bar-warn"):
+		t.Errorf("*** bar events should have been filtered using the component level: %v", log)
+	case !strings.Contains(log, "bar-error") || !strings.Contains(log, "root-info"):
+		t.Errorf("*** events should have been logged: %v", log)
+	}
+}
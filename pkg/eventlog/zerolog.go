@@ -59,6 +59,9 @@ func ForEvent(logger *zerolog.Logger, name string) *zerolog.Logger {
 
 // ForComponent returns a new logger with the component field 'c' set to the specified value.
 // To ensure uniqueness, use ULIDs.
+//
+// The component logger's level can be changed at runtime via SetComponentLevel, if the logger was created via
+// NewZeroLogger.
 func ForComponent(logger *zerolog.Logger, name string) *zerolog.Logger {
 	l := logger.With().Str(Component, name).Logger()
 	return &l
@@ -78,6 +81,8 @@ func WithEventXID(logger zerolog.Logger) zerolog.Logger {
 //  - timestamp in UNIX time format
 //  - event XID
 //
+// The logger's level can be changed at runtime via SetLevel.
+//
 // Example log message:
 //
 // {"z":"01DFBGCFD9WD29SGRJPK8KZKQS","t":1562680638,"m":"Hello World"}
@@ -85,7 +90,7 @@ func WithEventXID(logger zerolog.Logger) zerolog.Logger {
 // where z -> event XID
 //       t -> event timestamp
 func NewZeroLogger(w io.Writer) zerolog.Logger {
-	return WithEventXID(zerolog.New(w)).
+	return WithEventXID(zerolog.New(levelWriter{w})).
 		With().
 		Timestamp().
 		Logger()
@@ -41,6 +41,14 @@ import (
 //	5. Stopping
 //	6. Done
 //
+// When the app is signalled to stop, the app first drains: the readiness probe starts failing, and the app waits for
+// the drain delay (see `Builder.SetDrainDelay()`) before the app is stopped. This gives Kubernetes time to stop routing
+// traffic to the app. The drain phase is logged via the `DrainingEvent` and `DrainedEvent`.
+//
+// An app can only be run once. To recover from transient failures in-process, run the app via a `Supervisor`, which
+// rebuilds the app from its `Builder` with a new instance ID when the app fails to start or stop. Restarts are
+// backed off exponentially and are limited by a restart budget (see `SupervisorOpts`).
+//
 // When building an application, functions are registered which specify how to:
 //  - initialize the application
 //  - register services that are bound to the application life cycle, via `fx.Lifecycle` (https://godoc.org/go.uber.org/fx#Lifecycle)
@@ -56,6 +64,35 @@ import (
 //	- release ID - an application has many versions, but not all versions are released.
 //    - can be used to look up additional release artifacts, e.g., release notes, test reports, etc
 //
+// Application Config
+//
+// Application config structs are registered via `Builder.Config()`. Configs are loaded from env vars using the `EnvconfigPrefix`
+// (see https://godoc.org/github.com/kelseyhightower/envconfig), validated if the config implements `ConfigValidator`,
+// and provided as values for dependency injection. Config load failures are reported via the `InitFailedEvent`, which
+// includes the offending env var names.
+//
+// Config values are layered from the following sources, where each layer overrides the previous:
+//	1. defaults - the registered config struct value
+//	2. config file - specified via the `ConfigFileEnvVar` env var (JSON, YAML, or TOML), and decoded into the config
+//	3. env vars - loaded via envconfig, which also applies the `default` struct tags
+//
+// The source that supplied each config field value is logged with the `InitializedEvent`. Config fields tagged with
+// `secret:"true"`, as well as `Secret` fields, are redacted when logged.
+//
+// Configs are reloaded when the config file changes or when the process receives a SIGHUP signal. Config changes are
+// published to subscribers via `SubscribeForConfigChanges`, and each reload is logged via the `ConfigReloadedEvent`
+// or `ConfigReloadFailedEvent`.
+//
+// Secrets are looked up via the `SecretProvider` that is registered via `Builder.SecretProvider()`. Secret values are
+// held by the `Secret` type, which redacts the value when it is formatted, marshalled, or logged.
+//
+// Application Modules
+//
+// Reusable application components, e.g., DB, cache, and messaging setups, can be bundled as a `Module`, which is
+// installed via `Builder.Modules()`. A module bundles constructors, functions, health checks, Prometheus collectors, and
+// HTTP endpoints under a ULID module ID. Each module is assigned its own component logger. Installed modules are logged
+// with the `InitializedEvent`.
+//
 // Application Logging
 //
 // Zerolog (https://godoc.org/github.com/rs/zerolog) is used as the structured JSON logging framework. A `*zerolog.Logger`
@@ -92,6 +129,9 @@ import (
 // If a `PrometheusHTTPHandlerOpts` is provided, then it will be used instead. However, if the provided endpoint is blank,
 // then it will be set to '/metrics' and if timeout is zero, then it will be set to 5 secs.
 //
+// Short-lived apps, e.g., batch jobs, can install the `NewMetricsPushModule()` module to push metrics to a prometheus
+// pushgateway on an interval and when the app is stopped.
+//
 // TODO: Metrics are logged on a scheduled basis. By default, every minute - but is configurable.
 //
 // Health Checks
@@ -105,7 +145,11 @@ import (
 //		- "h" - health check ID
 //		- "d" - health check descriptor ID
 // 	- health checks are registered with the app readiness probe. The app is not ready until all health checks are pass green.
-//    If any health checks fail, i.e., not green, then the app will fail to start up.
+//    If any health checks fail, i.e., not green, then the app will fail to start up. The TLS cert expiry health check
+//    is exempt because restarting the app cannot renew the cert.
+//  - registered health checks and their latest results are exposed as JSON via HTTP (see `HealthChecksEndpoint`)
+//  - a prometheus operator PrometheusRule, which alerts on Yellow and Red health checks, is generated from the
+//    registered health checks and exposed as YAML via HTTP (see `PrometheusRulesEndpoint`)
 //  - TODO: health check GRPC API
 //
 // Readiness Probe
@@ -121,15 +165,34 @@ import (
 //    - if the app is not ready, then HTTP 503 is returned with response returns header `x-readiness-wait-group-count` set
 //      to the number of components that the app is waiting on
 //
+// Startup Probe
+//
+// A startup probe indicates whether the application has started, i.e., `App.Started()` is closed. The startup probe
+// succeeds once the app has started, even if the app is not yet ready.
+//
+// A startup probe HTTP endpoint is exposed:
+// 	- /01DE4X10QCV1M8TKRNXDK6AK7C - corresponds to `StartedEvent`
+//  - HTTP 503 is returned while the app is starting
+//
 // Liveliness Probe
 //
-// The application liveness probe fails if any health checks fail with a RED status.
+// The application liveness probe is decided by the LivenessPolicy. By default, the liveness probe fails if any health
+// checks fail with a RED status. Until the app has started, the liveness probe always succeeds - this prevents slow
+// starting apps from being killed while health checks are Red during startup.
+//
+// A LivenessPolicy can be provided to make the liveness probe more tolerant of flaky health checks. The following
+// policies are provided out of the box:
+//	- AnyRedLivenessPolicy - the default policy
+//	- CriticalChecksLivenessPolicy - only health checks tagged with `LivenessCriticalTag` are considered
+//	- SustainedRedLivenessPolicy - health checks must be Red for at least the specified duration
+//	- ConsecutiveRedLivenessPolicy - health checks must be Red for the specified number of consecutive results
 //
 // A liveness probe HTTP endpoint is exposed:
 // 	- /01DF91XTSXWVDJQ4XJ432KQFXY - corresponds to `LivenessProbeEvent`
 //  - HTTP 503 is returned if the probe fails
+//  - the LivenessDecision is returned as JSON
 //  - LivenessProbeEvent is logged each time the endpoint handler is invoked
-//    - the probe duration is logged with the event
+//    - the liveness decision, its reasons, and the probe duration are logged with the event
 //
 // HTTP server support
 //
@@ -141,6 +204,25 @@ import (
 //	- ReadHeaderTimeout: time.Second,
 //	- MaxHeaderBytes:    1024,
 //
+// HTTP endpoints are routed by path pattern and method. Path patterns may declare path parameters, e.g., "/users/{id}"
+// (see `PathParam()`), and endpoints may restrict the methods they handle (see `HTTPHandler.AllowMethods()`). Requests
+// using methods that are not allowed are rejected with HTTP 405 and the `Allow` header.
+//
+// Endpoints are tagged as either app or admin endpoints - the app's built-in endpoints, e.g., probes and metrics, are
+// admin endpoints (see `NewAdminHTTPHandler`). If an admin *http.Server is provided (see `AdminHTTPServer`), then admin
+// endpoints are served by the admin HTTP server on its own address, separately from the app endpoints.
+//
+// TLS and mutual TLS can be enabled for the app HTTP server via `Builder.EnableTLS()`. The TLS cert is reloaded when the
+// cert files are rotated, and the TLS cert expiry is monitored via a gauge and a health check.
+//
+// HTTP endpoint handlers are wrapped with the HTTPMiddleware(s) that are discovered - see `HTTPEndpointMiddleware`.
+// The following middleware is built-in and applied to all endpoints:
+//	- request ID: each request is assigned an XID, which is added to the request context and the response header
+//	  (see `RequestID()` and `RequestIDHeader`)
+//	- request events: each request is logged (see `HTTPRequestEvent`)
+//	- request metrics: request counts, errors, and latency histograms per endpoint (see `HTTPRequestsMetricID`)
+//	- panic recovery: handler panics are logged (see `HTTPHandlerPanicEvent`), and HTTP 500 is returned
+//
 // When building the app, the app HTTP server can be disabled - when using the App in unit testing, it is best to disable
 // the HTTP server if HTTP functionality is not being tested.
 //
@@ -160,7 +242,7 @@ import (
 //    - health.Scheduler
 //  - Probes
 //	  - ReadinessWaitGroup - the readiness probe uses the ReadinessWaitGroup to know when the application is ready to serve requests
-//    - LivenessProbe - returns an error if any health check is RED
+//    - LivenessProbe - returns an error if the LivenessPolicy decides the app is not live, after the app has started
 //	- Application Infrastructure Related
 //	  - *zerolog.Logger
 //    - *http.Server
@@ -169,7 +251,13 @@ import (
 //	- HTTP endpoints
 //    - /01DF9JKZ73Y3V1AJN89B58D9HY - exposes prometheus metrics
 //    - /01DEJ5RA8XRZVECJDJFAA2PWJF - readiness probe
+//    - /01DE4X10QCV1M8TKRNXDK6AK7C - startup probe
 //    - /01DF91XTSXWVDJQ4XJ432KQFXY - liveness probe
+//    - /01M51QZJG146ZNHAJSGEGJ63TS - dependency graph, in DOT or JSON format (see `DependencyGraphEndpoint`)
+//    - /01M51R2A6GMWEPPY54QFZAG3A8 - health checks and their latest results (see `HealthChecksEndpoint`)
+//    - /01M51SRZPYDH62QNYBH7JPBKDY - PrometheusRule generated from the registered health checks (see `PrometheusRulesEndpoint`)
+//    - /01M51R5FK40K6A5Y550FRM0NJ0 - view and change log levels at runtime (see `LogLevelsEndpoint`)
+//    - /debug/pprof/ - profiling endpoints, which must be enabled (see `Builder.EnableProfiling()`)
 type App interface {
 	ID() ID
 	ReleaseID() ReleaseID
@@ -214,11 +302,19 @@ type Options interface {
 	// StopTimeout returns the app shutdown timeout. If the app takes longer than the specified timeout, then the app shutdown
 	// will be aborted.
 	StopTimeout() time.Duration
+	// DrainDelay returns how long the app waits after the readiness probe starts failing before the app is stopped
+	DrainDelay() time.Duration
+	// TLSEnabled returns true if TLS is enabled for the app HTTP server - see `Builder.EnableTLS()`
+	TLSEnabled() bool
 
 	// ConstructorTypes returns the registered constructor types
 	ConstructorTypes() []reflect.Type
 	// FuncTypes returns the registered function types
 	FuncTypes() []reflect.Type
+	// ConfigTypes returns the registered config types
+	ConfigTypes() []reflect.Type
+	// Modules returns the installed modules
+	Modules() []Module
 }
 
 type app struct {
@@ -228,6 +324,10 @@ type app struct {
 
 	constructors []interface{}
 	funcs        []interface{}
+	modules      []Module
+	configs      []interface{}
+	// loaded configs
+	resolvedConfigs resolvedConfigs
 
 	startErrorHandlers, stopErrorHandlers []func(error)
 
@@ -235,6 +335,10 @@ type app struct {
 	fx.Shutdowner
 	starting, started chan struct{}
 	readiness         ReadinessWaitGroup
+	drainer           *drainer
+	signals           *signalHandling
+	drainDelay        time.Duration
+	tlsEnabled        bool
 	stopping, stopped chan os.Signal
 
 	logger *zerolog.Logger
@@ -305,6 +409,14 @@ func types(values []interface{}) []reflect.Type {
 	return valueTypes
 }
 
+func (a *app) DrainDelay() time.Duration {
+	return a.drainDelay
+}
+
+func (a *app) TLSEnabled() bool {
+	return a.tlsEnabled
+}
+
 func (a *app) ConstructorTypes() []reflect.Type {
 	return types(a.constructors)
 }
@@ -313,6 +425,14 @@ func (a *app) FuncTypes() []reflect.Type {
 	return types(a.funcs)
 }
 
+func (a *app) ConfigTypes() []reflect.Type {
+	return types(a.configs)
+}
+
+func (a *app) Modules() []Module {
+	return a.modules
+}
+
 func (a *app) Run() error {
 	select {
 	case <-a.starting:
@@ -321,6 +441,8 @@ func (a *app) Run() error {
 		// app has not been started yet
 	}
 	a.logAppStarting()
+	a.signals.listen()
+	defer a.signals.close()
 
 	startCtx, cancel := context.WithTimeout(context.Background(), a.StartTimeout())
 	defer cancel()
@@ -336,6 +458,7 @@ func (a *app) Run() error {
 	a.logAppStarted(time.Since(startingTime))
 	close(a.started)
 	a.readiness.Done() // the app has started
+	a.signals.start(a)
 
 	// wait for the app to be ready to service requests
 	select {
@@ -348,6 +471,7 @@ func (a *app) Run() error {
 }
 
 func (a *app) shutdown(signal os.Signal) error {
+	a.signals.stop()
 	a.stopping <- signal
 	close(a.stopping)
 	defer func() {
@@ -355,6 +479,8 @@ func (a *app) shutdown(signal os.Signal) error {
 	}()
 
 	a.logAppStopping()
+	// drain before the stop timeout is applied
+	a.drainer.drain(fmt.Sprintf("app is stopping: %v", signal), a.drainDelay, a.logger)
 
 	stopCtx, cancel := context.WithTimeout(context.Background(), a.StopTimeout())
 	defer cancel()
@@ -412,7 +538,7 @@ func (a *app) Shutdown() error {
 
 func (a *app) logAppInitialized(dependencyGraph fx.DotGraph) {
 	logEvent := eventlog.NewLogger(InitializedEvent, a.logger, zerolog.NoLevel)
-	logEvent(appInfo{a, dependencyGraph}, "app initialized")
+	logEvent(appInfo{a, dependencyGraph, a.resolvedConfigs}, "app initialized")
 }
 
 func (a *app) logAppStarting() {
@@ -33,6 +33,7 @@ import (
 	"log"
 	"os"
 	"reflect"
+	"syscall"
 	"time"
 )
 
@@ -45,15 +46,70 @@ type Builder interface {
 	// Invoke is used to register application functions, which will be invoked to to initialize the app.
 	// The functions are invoked in the order that they are registered.
 	Invoke(funcs ...interface{}) Builder
+	// Modules is used to install modules. Modules are installed in the order that they are registered, and module
+	// functions are invoked before the app functions are invoked.
+	Modules(modules ...Module) Builder
+	// Config is used to register application config structs, which are loaded from the config file and env vars using
+	// the `EnvconfigPrefix` - see `ConfigFileEnvVar`.
+	// Configs must be specified as struct pointers, e.g., `Config(&Config{})`. The specified config struct value is used
+	// as the config defaults template.
+	//
+	// The loaded config struct value is provided, i.e., the config struct value type, not the pointer type. If the config
+	// implements ConfigValidator, then the config is validated after it is loaded. If any configs fail to load or validate,
+	// then the app will fail to build and the failures are reported via the `InitFailedEvent`, which includes the offending
+	// env var names.
+	//
+	// Configs are reloaded when the config file changes or when the process receives a SIGHUP signal. Config changes
+	// are published via `SubscribeForConfigChanges`, which is provided for dependency injection.
+	Config(configs ...interface{}) Builder
+	// SetConfigFileWatchInterval sets the interval used to check the config file for changes. If the interval is zero,
+	// then the config file is not watched.
+	//
+	// The default interval is `DefaultConfigFileWatchInterval`.
+	SetConfigFileWatchInterval(interval time.Duration) Builder
+	// SecretProvider registers the SecretProvider, which is provided for dependency injection. Secrets that are looked up
+	// via the provided SecretProvider are watched for rotations, which are published via `SubscribeForSecretRotations`.
+	SecretProvider(provider SecretProvider) Builder
+	// SetSecretWatchInterval sets the interval used to check secrets for rotations. If the interval is zero, then secrets
+	// are not watched.
+	//
+	// The default interval is `DefaultSecretWatchInterval`.
+	SetSecretWatchInterval(interval time.Duration) Builder
 
 	SetStartTimeout(timeout time.Duration) Builder
 	SetStopTimeout(timeout time.Duration) Builder
+	// SetDrainDelay sets how long the app waits after the readiness probe starts failing before the app is stopped. This
+	// gives Kubernetes time to stop routing traffic to the app before the HTTP server is shutdown.
+	//
+	// By default, there is no drain delay.
+	SetDrainDelay(delay time.Duration) Builder
 
 	// LogWriter is used as the zerolog writer.
 	//
 	// By default, stderr is used.
 	LogWriter(w io.Writer) Builder
 	LogLevel(level LogLevel) Builder
+	// LogLevelsToken sets the token that gates changing log levels via the `LogLevelsEndpoint`. Requests that change log
+	// levels must specify the token via the HTTP Authorization header using the bearer scheme, i.e.,
+	// "Authorization: Bearer <token>".
+	//
+	// By default, the token is not set, which means log levels can only be viewed via the `LogLevelsEndpoint`.
+	LogLevelsToken(token Secret) Builder
+
+	// HandleSignal registers a handler for the specified OS signal. Multiple handlers can be registered for the same signal,
+	// and are run in the order that they are registered. Signal handlers only run while the app is running, i.e., after
+	// the app has started and before the app is stopping. Signals are handled sequentially, thus handlers should not block.
+	// The signals are registered for as long as the app is run - signals that are received while the app is starting or
+	// stopping are logged and dropped (see `SignalEvent`).
+	//
+	// The following built-in signal handlers are registered:
+	//	- SIGHUP: reloads configs, if any configs are registered
+	//	- SIGUSR1: logs the `DiagnosticsEvent`
+	//
+	// SIGINT and SIGTERM trigger the app to shutdown. Other signals can be mapped to shutdown the app, e.g.,
+	//
+	//	builder.HandleSignal(syscall.SIGQUIT, func(app fxapp.App) { app.Shutdown() })
+	HandleSignal(sig os.Signal, handler func(App)) Builder
 
 	// Error handlers
 	HandleInvokeError(errorHandlers ...func(error)) Builder
@@ -76,6 +132,21 @@ type Builder interface {
 	//    to be run in parallel
 	//  - for CLI based apps
 	DisableHTTPServer() Builder
+	// EnableProfiling registers the pprof profiling endpoints, goroutine dumps, and the execution trace endpoint
+	// with the app's HTTP server. The endpoints are registered under the admin path prefix, and sensitive profiles are
+	// gated behind a token - see `ProfilingOpts`.
+	//
+	// By default, profiling is disabled.
+	EnableProfiling(opts ProfilingOpts) Builder
+	// EnableTLS enables TLS for the app HTTP server. The TLS config is loaded from the cert and key files, and is
+	// reloaded when the files change. Mutual TLS is enabled if a client CA bundle is specified - see `TLSOpts`.
+	//
+	// The TLS cert expiry is exposed as a gauge (see `TLSCertExpiryMetricID`) and as a health check (see
+	// `TLSCertExpiryHealthCheckID`). If the TLS config fails to load, then the app fails to build.
+	//
+	// NOTE: if an admin HTTP server is provided, then TLS only applies to the app HTTP server. Mutual TLS requires an
+	// admin HTTP server, otherwise the app fails to build.
+	EnableTLS(opts TLSOpts) Builder
 
 	Build() (App, error)
 }
@@ -90,6 +161,9 @@ func NewBuilder(id ID, releaseID ReleaseID) Builder {
 		startTimeout: fx.DefaultTimeout,
 		stopTimeout:  fx.DefaultTimeout,
 
+		configFileWatchInterval: DefaultConfigFileWatchInterval,
+		secretWatchInterval:     DefaultSecretWatchInterval,
+
 		globalLogLevel: zerolog.InfoLevel,
 		logWriter:      os.Stderr,
 	}
@@ -102,17 +176,30 @@ type builder struct {
 
 	startTimeout time.Duration
 	stopTimeout  time.Duration
+	drainDelay   time.Duration
 
 	constructors    []interface{}
 	funcs           []interface{}
+	modules         []Module
 	populateTargets []interface{}
+	configs         []interface{}
+
+	configFileWatchInterval time.Duration
+
+	secretProvider      SecretProvider
+	secretWatchInterval time.Duration
 
 	logWriter      io.Writer
 	globalLogLevel zerolog.Level
+	logLevelsToken Secret
 
 	invokeErrorHandlers, startErrorHandlers, stopErrorHandlers []func(error)
 
+	signalHandlers signalHandlers
+
 	disableHTTPServer bool
+	profiling         *ProfilingOpts
+	tls               *TLSOpts
 }
 
 func (b *builder) String() string {
@@ -132,14 +219,21 @@ func (b *builder) String() string {
 		return s.String()
 	}
 
-	return fmt.Sprintf("Builder{ID: %v, ReleaseID: %v, StartTimeout: %s, StopTimeout: %s, Provide: %s, Invoke: %s, Populate: %s, InvokeErrHandlerCount: %d, StartErrHandlerCount: %d}",
+	moduleNames := make([]string, len(b.modules))
+	for i, module := range b.modules {
+		moduleNames[i] = module.Name
+	}
+
+	return fmt.Sprintf("Builder{ID: %v, ReleaseID: %v, StartTimeout: %s, StopTimeout: %s, Provide: %s, Invoke: %s, Modules: %v, Populate: %s, Config: %s, InvokeErrHandlerCount: %d, StartErrHandlerCount: %d}",
 		ulid.ULID(b.id),
 		ulid.ULID(b.releaseID),
 		b.startTimeout,
 		b.startTimeout,
 		types(b.constructors),
 		types(b.funcs),
+		moduleNames,
 		types(b.populateTargets),
+		types(b.configs),
 		len(b.invokeErrorHandlers),
 		len(b.startErrorHandlers),
 	)
@@ -156,26 +250,39 @@ func (b *builder) Build() (App, error) {
 	var logger *zerolog.Logger
 	var readinessWaitGroup ReadinessWaitGroup
 	var dotGraph fx.DotGraph
-	b.populateTargets = append(b.populateTargets, &shutdowner, &logger, &readinessWaitGroup, &dotGraph)
+	var drain *drainer
+	var configSvc *configService
+	var checkResults health.CheckResults
+	var depGraph *dependencyGraph
+	var startup *startupState
+	b.populateTargets = append(b.populateTargets, &shutdowner, &logger, &readinessWaitGroup, &dotGraph, &drain, &configSvc, &checkResults, &depGraph, &startup)
+	// configs are loaded up front - if any configs fail to load, then the app fails fast before any app functions are invoked
+	configs, configErr := loadConfigs(b.configs)
 	app := &app{
 		instanceID:   b.instanceID,
 		id:           b.id,
 		releaseID:    b.releaseID,
 		constructors: b.constructors,
 		funcs:        b.funcs,
+		modules:      b.modules,
+		configs:      b.configs,
+
+		resolvedConfigs: configs,
 
 		startErrorHandlers: b.startErrorHandlers,
 		stopErrorHandlers:  b.stopErrorHandlers,
 
+		drainDelay: b.drainDelay,
+		tlsEnabled: b.tls != nil,
+
 		starting: make(chan struct{}),
-		started:  make(chan struct{}),
 		stopping: make(chan os.Signal, 1),
 		stopped:  make(chan os.Signal, 1),
 
 		App: fx.New(
 			fx.StartTimeout(b.startTimeout),
 			fx.StopTimeout(b.stopTimeout),
-			fx.Options(b.options()...),
+			fx.Options(b.options(configs, configErr)...),
 		),
 
 		Shutdowner: shutdowner,
@@ -194,19 +301,68 @@ func (b *builder) Build() (App, error) {
 	}
 	app.logger = logger
 	app.readiness = readinessWaitGroup
+	app.drainer = drain
+	app.started = startup.started
+	app.signals = newSignalHandling(b.builtinSignalHandlers(configSvc, checkResults, readinessWaitGroup, logger), logger)
+	depGraph.init(dotGraph, app.ConstructorTypes(), app.FuncTypes())
 	app.logAppInitialized(dotGraph)
 	return app, nil
 }
 
+// builtinSignalHandlers returns the built-in signal handlers along with the registered signal handlers. The built-in
+// handlers run first.
+func (b *builder) builtinSignalHandlers(configs *configService, checkResults health.CheckResults, readiness ReadinessWaitGroup, logger *zerolog.Logger) signalHandlers {
+	handlers := make(signalHandlers)
+	if len(b.configs) > 0 {
+		handlers.add(syscall.SIGHUP, reloadConfigsOnSignal(configs))
+	}
+	handlers.add(syscall.SIGUSR1, logDiagnosticsOnSignal(checkResults, readiness, logger))
+	for sig, sigHandlers := range b.signalHandlers {
+		handlers.add(sig, sigHandlers...)
+	}
+	return handlers
+}
+
 func (b *builder) validate() error {
 	if len(b.funcs) == 0 {
 		return errors.New("at least 1 functional option is required")
 	}
+	configTypes := make(map[reflect.Type]bool, len(b.configs))
+	for _, config := range b.configs {
+		if err := validateConfigType(config); err != nil {
+			return err
+		}
+		configType := reflect.TypeOf(config)
+		if configTypes[configType] {
+			return fmt.Errorf("config type is registered more than once: %v", configType)
+		}
+		configTypes[configType] = true
+	}
+	moduleIDs := make(map[string]bool, len(b.modules))
+	for _, module := range b.modules {
+		if err := module.validate(); err != nil {
+			return err
+		}
+		if moduleIDs[module.ID] {
+			return fmt.Errorf("module is installed more than once: %s : %s", module.ID, module.Name)
+		}
+		moduleIDs[module.ID] = true
+	}
+	if b.profiling != nil {
+		if err := b.profiling.validate(); err != nil {
+			return err
+		}
+	}
+	if b.tls != nil {
+		if err := b.tls.validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // This is the key method used to compose the application options
-func (b *builder) options() []fx.Option {
+func (b *builder) options(configs resolvedConfigs, configErr error) []fx.Option {
 	logger := b.initZerolog()
 
 	compOptions := make([]fx.Option, 0, len(b.invokeErrorHandlers)+9)
@@ -217,17 +373,65 @@ func (b *builder) options() []fx.Option {
 		newPrometheusHTTPHandler,
 
 		func() ReadinessWaitGroup { return NewReadinessWaitgroup(1) },
+		func() *drainer { return new(drainer) },
 		readinessProbeHTTPHandler,
 
+		newStartupState,
+		startupProbeHTTPHandler,
+
+		newLivenessMonitor,
 		livenessProbe,
 		livenessProbeHTTPHandler,
+
+		healthChecksHTTPHandler,
+		prometheusRulesHTTPHandler,
+
+		newLogLevelController,
+		provideLogLevelController,
+		func(c *logLevelController) HTTPHandler { return logLevelsHTTPHandler(c, b.logLevelsToken) },
+
+		func() *dependencyGraph { return new(dependencyGraph) },
+		dependencyGraphHTTPHandler,
+
+		requestIDMiddleware,
+		requestEventMiddleware,
+		requestMetricsMiddleware,
+		panicRecoveryMiddleware,
+
+		provideHTTPClients,
 	))
+	if b.profiling != nil {
+		for _, handler := range profilingHTTPHandlers(*b.profiling) {
+			handler := handler
+			compOptions = append(compOptions, fx.Provide(func() HTTPHandler { return handler }))
+		}
+	}
 	compOptions = append(compOptions, health.Module(health.DefaultOpts()))
 	compOptions = append(compOptions, fx.Provide(b.constructors...))
+	for _, config := range configs {
+		compOptions = append(compOptions, fx.Provide(configValueProvider(config.value)))
+	}
+	configSvc := newConfigService(b.configs, configs, b.configFileWatchInterval, logger)
+	compOptions = append(compOptions, fx.Provide(func() *configService { return configSvc }, provideConfigService))
+	if b.secretProvider != nil {
+		secretSvc := newSecretService(b.secretProvider, b.secretWatchInterval, logger)
+		compOptions = append(compOptions, fx.Provide(func() *secretService { return secretSvc }, provideSecretService))
+		compOptions = append(compOptions, fx.Invoke(startSecretService))
+	}
+	if b.tls != nil {
+		compOptions = append(compOptions, fx.Provide(func() (*tlsService, error) { return newTLSService(*b.tls, logger) }))
+		compOptions = append(compOptions, fx.Invoke(startTLSService))
+	}
+	compOptions = append(compOptions, fx.Invoke(func() error { return configErr }))
 	compOptions = append(compOptions, fx.Invoke(
+		startConfigService,
 		handleHealthCheckRegistrations,
 		logHealthCheckResults,
+		startLivenessMonitor,
 	))
+	for _, module := range b.modules {
+		compOptions = append(compOptions, module.options()...)
+	}
 	compOptions = append(compOptions, fx.Invoke(b.funcs...))
 	compOptions = append(compOptions, fx.Invoke(healthCheckReadiness))
 
@@ -244,7 +448,7 @@ func (b *builder) options() []fx.Option {
 		}
 		compOptions = append(compOptions, fx.ErrorHook(errorHandler(func(err error) {
 			logEvent := eventlog.NewLogger(InitFailedEvent, logger, zerolog.ErrorLevel)
-			logEvent(eventlog.NewError(err), "app init failed")
+			logEvent(initFailed{err}, "app init failed")
 		})))
 	}
 
@@ -273,9 +477,16 @@ func providePrometheusMetricsSupport(id ID, releaseID ReleaseID, instanceID Inst
 	return registry, regsisterer
 }
 
+// health checks that are not run on app start up because restarting the app cannot fix them, e.g., a TLS cert that is
+// pending renewal - failing start up would only cause the app to crash loop
+var startupExemptHealthChecks = map[string]bool{
+	TLSCertExpiryHealthCheckID: true,
+}
+
 // - registers a lifecycle hook that waits until all health checks are run on app start up
 //   - the app is not ready to service requests until all health checks have been run and passed with a Green status
 //   - if any health checks fail to run on start up then the app will fail to start up
+//   - health checks that are exempt from start up, e.g., the TLS cert expiry health check, are skipped
 func healthCheckReadiness(registeredChecks health.RegisteredChecks, checkResults health.CheckResults, wg ReadinessWaitGroup, lc fx.Lifecycle) {
 	wg.Add(1)
 	lc.Append(fx.Hook{
@@ -284,6 +495,9 @@ func healthCheckReadiness(registeredChecks health.RegisteredChecks, checkResults
 
 			var err error
 			for _, check := range <-registeredChecks() {
+				if startupExemptHealthChecks[check.ID] {
+					continue
+				}
 				if result := check.Checker(); result.Status != health.Green {
 					err = multierr.Combine(err, fmt.Errorf("health check failed: %s", check.ID), result.Err)
 				}
@@ -380,20 +594,40 @@ func (l fxlogger) Printf(msg string, params ...interface{}) {
 }
 
 func (b *builder) initZerolog() *zerolog.Logger {
-	zerolog.SetGlobalLevel(b.globalLogLevel)
+	eventlog.SetLevel(b.globalLogLevel)
+
+	logger := b.newZerolog()
+
+	// use the logger as the go standard log output
+	log.SetFlags(0)
+	log.SetOutput(eventlog.ForComponent(logger, "log"))
+
+	return logger
+}
 
+// newZerolog returns a new logger that is labeled with the app IDs. Unlike initZerolog, it has no global side effects.
+func (b *builder) newZerolog() *zerolog.Logger {
 	logger := eventlog.NewZeroLogger(b.logWriter).
 		With().
 		Str(AppIDLabel, ulid.ULID(b.id).String()).
 		Str(AppReleaseIDLabel, ulid.ULID(b.releaseID).String()).
 		Str(AppInstanceIDLabel, ulid.ULID(b.instanceID).String()).
 		Logger()
+	return &logger
+}
 
-	// use the logger as the go standard log output
-	log.SetFlags(0)
-	log.SetOutput(eventlog.ForComponent(&logger, "log"))
+// newInstance returns a copy of the builder that is assigned a new InstanceID, which is used to build a new app instance.
+// The populate targets are copied because Build appends the app's internal populate targets.
+func (b *builder) newInstance() *builder {
+	instance := *b
+	instance.instanceID = InstanceID(ulids.MustNew())
+	instance.populateTargets = append([]interface{}(nil), b.populateTargets...)
+	return &instance
+}
 
-	return &logger
+func (b *builder) SetDrainDelay(delay time.Duration) Builder {
+	b.drainDelay = delay
+	return b
 }
 
 func (b *builder) SetStartTimeout(timeout time.Duration) Builder {
@@ -416,11 +650,44 @@ func (b *builder) Invoke(funcs ...interface{}) Builder {
 	return b
 }
 
+func (b *builder) Modules(modules ...Module) Builder {
+	b.modules = append(b.modules, modules...)
+	return b
+}
+
+func (b *builder) Config(configs ...interface{}) Builder {
+	b.configs = append(b.configs, configs...)
+	return b
+}
+
+func (b *builder) SetConfigFileWatchInterval(interval time.Duration) Builder {
+	b.configFileWatchInterval = interval
+	return b
+}
+
+func (b *builder) SecretProvider(provider SecretProvider) Builder {
+	b.secretProvider = provider
+	return b
+}
+
+func (b *builder) SetSecretWatchInterval(interval time.Duration) Builder {
+	b.secretWatchInterval = interval
+	return b
+}
+
 func (b *builder) Populate(targets ...interface{}) Builder {
 	b.populateTargets = append(b.populateTargets, targets...)
 	return b
 }
 
+func (b *builder) HandleSignal(sig os.Signal, handler func(App)) Builder {
+	if b.signalHandlers == nil {
+		b.signalHandlers = make(signalHandlers)
+	}
+	b.signalHandlers.add(sig, handler)
+	return b
+}
+
 func (b *builder) HandleInvokeError(errorHandlers ...func(error)) Builder {
 	b.invokeErrorHandlers = append(b.invokeErrorHandlers, errorHandlers...)
 	return b
@@ -453,7 +720,24 @@ func (b *builder) LogLevel(level LogLevel) Builder {
 	return b
 }
 
+func (b *builder) LogLevelsToken(token Secret) Builder {
+	b.logLevelsToken = token
+	return b
+}
+
 func (b *builder) DisableHTTPServer() Builder {
 	b.disableHTTPServer = true
 	return b
 }
+
+func (b *builder) EnableProfiling(opts ProfilingOpts) Builder {
+	opts = opts.withDefaults()
+	b.profiling = &opts
+	return b
+}
+
+func (b *builder) EnableTLS(opts TLSOpts) Builder {
+	opts = opts.withDefaults()
+	b.tls = &opts
+	return b
+}
@@ -31,11 +31,29 @@ const (
 	//		StopTimeout  	uint `json:"stop_timeout"`
 	//		Provides     	[]string
 	//		Invokes      	[]string
+	//		Modules      	[]struct {
+	//			ID   string
+	//			Name string
+	//		}
+	//		Configs      	[]struct {
+	//			Type   string
+	//			Fields []struct {
+	//				Key   string // env var name
+	//				Field string // config struct field path
+	//				Value string // secret values are redacted
+	//				Src   string // config source that supplied the value: default, file, env
+	//			}
+	//		}
 	//		DependencyGraph string `json:"dot_graph"` // DOT language visualization of the app dependency graph
 	//	}
 	InitializedEvent = "01DE4STZ0S24RG7R08PAY1RQX3"
 	// 	type Data struct {
-	//		Err string `json:"e"`
+	//		Err    string `json:"e"`
+	//		Config []struct { // config load failures
+	//			Type string
+	//			Vars []string // offending env var names
+	//			Err  string `json:"e"`
+	//		}
 	//	}
 	InitFailedEvent = "01DE4SWMZXD1ZB40QRT7RGQVPN"
 
@@ -67,6 +85,7 @@ const (
 type appInfo struct {
 	App
 	fx.DotGraph
+	configs resolvedConfigs
 }
 
 // MarshalZerologObject implements zerolog.LogObjectMarshaler interface
@@ -84,6 +103,8 @@ func (event appInfo) MarshalZerologObject(e *zerolog.Event) {
 
 	e.Strs("provides", typeNames(event.App.ConstructorTypes()))
 	e.Strs("invokes", typeNames(event.App.FuncTypes()))
+	e.Array("modules", modules(event.App.Modules()))
+	e.Array("configs", event.configs)
 	e.Str("dot_graph", string(event.DotGraph))
 }
 
@@ -155,7 +176,23 @@ func (h *healthCheckResult) MarshalZerologObject(e *zerolog.Event) {
 // probe related events
 const (
 	// 	type Data struct {
+	//		Live bool
+	//		Reasons []string
 	//		Duration uint
 	//	}
 	LivenessProbeEvent = "01DF91XTSXWVDJQ4XJ432KQFXY"
 )
+
+type livenessProbeResult struct {
+	LivenessDecision
+	time.Duration
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (r *livenessProbeResult) MarshalZerologObject(e *zerolog.Event) {
+	e.Bool("live", r.Live)
+	if len(r.Reasons) > 0 {
+		e.Strs("reasons", r.Reasons)
+	}
+	e.Dur("duration", r.Duration)
+}
@@ -17,7 +17,6 @@
 package fxapp_test
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -889,31 +888,18 @@ func TestGoStandardLogUsesZeroLog(t *testing.T) {
 	case err != nil:
 		t.Errorf("*** app build failure")
 	default:
-		type LogEvent struct {
-			Component string `json:"c"`
-			Msg       string `json:"m"`
+		entries, err := fxapptest.ParseLogEntries(buf)
+		if err != nil {
+			t.Errorf("*** %v", err)
 		}
-
-		var logEvent LogEvent
-		reader := bufio.NewReader(buf)
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				break
-			}
-			t.Log(line)
-			err = json.Unmarshal([]byte(line), &logEvent)
-			t.Log(logEvent)
-			if err != nil {
-				t.Errorf("*** failed to parse log event: %v", err)
-				continue
-			}
-			if logEvent.Component == "log" && logEvent.Msg == msg {
+		found := false
+		for _, entry := range entries {
+			if entry.Component == "log" && entry.Message == msg {
+				found = true
 				break
 			}
 		}
-
-		if logEvent.Component != "log" && logEvent.Msg != msg {
+		if !found {
 			t.Error("*** log event was not found")
 		}
 	}
@@ -24,9 +24,9 @@ import (
 
 // BuildInfo  represents the build information read from the running binary.
 type BuildInfo struct {
-	Path string    // The main package Path
-	Main Module    // The main module information
-	Deps []*Module // Module dependencies
+	Path string         // The main package Path
+	Main BuildModule    // The main module information
+	Deps []*BuildModule // Module dependencies
 }
 
 // MarshalZerologObject implements zerolog.LogObjectMarshaler interface
@@ -44,7 +44,7 @@ func (b *BuildInfo) MarshalZerologObject(e *zerolog.Event) {
 func (b *BuildInfo) depArr() *zerolog.Array {
 	arr := zerolog.Arr()
 	for _, d := range b.Deps {
-		arr.Object(&Module{d.Path, d.Version, d.Checksum})
+		arr.Object(&BuildModule{d.Path, d.Version, d.Checksum})
 	}
 	return arr
 }
@@ -56,35 +56,47 @@ func ReadBuildInfo() (*BuildInfo, error) {
 	if !ok {
 		return nil, errors.New("build information is available only in binaries built with module support")
 	}
-	var deps []*Module
+	var deps []*BuildModule
 	for _, dep := range buildInfo.Deps {
-		deps = append(deps, NewModule(dep))
+		deps = append(deps, NewBuildModule(dep))
 	}
 	return &BuildInfo{
 		buildInfo.Path,
-		Module{buildInfo.Main.Path, buildInfo.Main.Version, buildInfo.Main.Sum},
+		BuildModule{buildInfo.Main.Path, buildInfo.Main.Version, buildInfo.Main.Sum},
 		deps,
 	}, nil
 }
 
-// Module represents an app module dependency
-type Module struct {
+// BuildModule represents an app module dependency
+//
+// NOTE: BuildModule was formerly named Module. The type was renamed because `Module` is used for reusable app modules,
+// which is also why a type alias for the former name cannot be provided. Code that references the former type, e.g., via
+// `BuildInfo.Main` or `BuildInfo.Deps`, must use BuildModule.
+type BuildModule struct {
 	Path     string
 	Version  string
 	Checksum string
 }
 
-// NewModule constructs a new Module
-func NewModule(m *debug.Module) *Module {
+// NewBuildModule constructs a new BuildModule
+func NewBuildModule(m *debug.Module) *BuildModule {
 	d := m
 	if m.Replace != nil {
 		d = m.Replace
 	}
-	return &Module{d.Path, d.Version, d.Sum}
+	return &BuildModule{d.Path, d.Version, d.Sum}
+}
+
+// NewModule constructs a new BuildModule
+//
+// Deprecated: use NewBuildModule. The build info Module type was renamed to BuildModule because `Module` is used for
+// reusable app modules.
+func NewModule(m *debug.Module) *BuildModule {
+	return NewBuildModule(m)
 }
 
 // MarshalZerologObject implements zerolog.LogObjectMarshaler interface
-func (m *Module) MarshalZerologObject(e *zerolog.Event) {
+func (m *BuildModule) MarshalZerologObject(e *zerolog.Event) {
 	e.Str("path", m.Path)
 	e.Str("version", m.Version)
 	e.Str("checksum", m.Checksum)
@@ -0,0 +1,405 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/rs/zerolog"
+	"go.uber.org/multierr"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ConfigValidator is implemented by config types that need to validate themselves after they are loaded.
+//
+// To report which config fields are invalid, return ConfigFieldError(s). Multiple errors can be combined via
+// `multierr.Combine()`.
+type ConfigValidator interface {
+	Validate() error
+}
+
+// ConfigFieldError is used to report an invalid config field.
+//
+// When the config error is reported, the field is mapped to its env var name.
+type ConfigFieldError struct {
+	// Field is the config struct field name
+	Field string
+	Err   error
+}
+
+func (err ConfigFieldError) Error() string {
+	return fmt.Sprintf("invalid config field: %s : %v", err.Field, err.Err)
+}
+
+// ConfigError is used to report config load and validation failures.
+type ConfigError struct {
+	// Type is the config type - nil if the error is not specific to a config type, e.g., the config file failed to load
+	Type reflect.Type
+	// Vars are the env var names that failed to load or validate
+	Vars []string
+	Err  error
+}
+
+func (err *ConfigError) Error() string {
+	if err.Type == nil {
+		return fmt.Sprintf("config failed to load: %v : %v", err.Vars, err.Err)
+	}
+	if len(err.Vars) == 0 {
+		return fmt.Sprintf("config failed to load: %v : %v", err.Type, err.Err)
+	}
+	return fmt.Sprintf("config failed to load: %v : %v : %v", err.Type, err.Vars, err.Err)
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (err *ConfigError) MarshalZerologObject(e *zerolog.Event) {
+	if err.Type != nil {
+		e.Str("type", err.Type.String())
+	}
+	if len(err.Vars) > 0 {
+		e.Strs("vars", err.Vars)
+	}
+	e.Err(err.Err)
+}
+
+// ConfigErrors extracts all ConfigError(s) from the specified error.
+func ConfigErrors(err error) []*ConfigError {
+	var configErrs []*ConfigError
+	for _, e := range multierr.Errors(err) {
+		if configErr, ok := e.(*ConfigError); ok {
+			configErrs = append(configErrs, configErr)
+		}
+	}
+	return configErrs
+}
+
+// ErrConfigNotStructPointer indicates a config was registered that is not a pointer to a struct
+var ErrConfigNotStructPointer = errors.New("config must be a pointer to a struct")
+
+// ConfigSource identifies the config source that supplied a config field value
+type ConfigSource uint8
+
+// ConfigSource enum
+//
+// Config sources are layered in the following order, where each layer overrides the previous:
+//	1. DefaultConfigSource
+//	2. FileConfigSource
+//	3. EnvConfigSource
+//
+// The env layer is loaded via `envconfig.Process()` (https://godoc.org/github.com/kelseyhightower/envconfig), which
+// means envconfig's `default` and `required` struct tags belong to the env layer, i.e., a `default` struct tag value
+// overrides the config file value, and a `required` field must be set via its env var. Use the config template to
+// specify default values that can be overridden by the config file.
+const (
+	// DefaultConfigSource means the value was supplied by the config struct template or a `default` struct tag
+	DefaultConfigSource ConfigSource = iota
+	// FileConfigSource means the value was supplied by the config file
+	FileConfigSource
+	// EnvConfigSource means the value was supplied by an env var
+	EnvConfigSource
+)
+
+func (s ConfigSource) String() string {
+	switch s {
+	case FileConfigSource:
+		return "file"
+	case EnvConfigSource:
+		return "env"
+	default:
+		return "default"
+	}
+}
+
+// configField describes a config struct field that is mapped to an env var.
+//
+// In addition to the envconfig struct tags, the `secret` struct tag is supported. Secret field values, as well as
+// Secret typed field values, are redacted when the config is logged.
+type configField struct {
+	key, alt string
+	// struct field name
+	name     string
+	def      string
+	required bool
+	secret   bool
+	// the field value rendered as a string - secret values are fingerprinted, i.e., they can only be compared
+	value string
+
+	source ConfigSource
+}
+
+func (f *configField) isSet() bool {
+	if _, ok := os.LookupEnv(f.key); ok {
+		return true
+	}
+	if f.alt != "" {
+		_, ok := os.LookupEnv(f.alt)
+		return ok
+	}
+	return false
+}
+
+// used to gather the config field descriptors via `envconfig.Usagef()` - each column is quoted
+var configFieldsFormat = "{{range .}}" +
+	`{{printf "%q" .Key}}	{{printf "%q" .Alt}}	{{printf "%q" .Name}}	` +
+	`{{printf "%q" (.Tags.Get "default")}}	{{printf "%q" (.Tags.Get "required")}}	{{printf "%q" (.Tags.Get "secret")}}	` +
+	`{{printf "%q" (print .Field.Type)}}	` +
+	`{{if eq (print .Field.Type) "` + reflect.TypeOf(Secret{}).String() + `"}}{{printf "%q" .Field.Interface.Value}}{{else}}{{printf "%q" (print .Field)}}{{end}}` +
+	"\n{{end}}"
+
+// configFields returns the config field descriptors along with the current field values
+func configFields(config interface{}) ([]*configField, error) {
+	buf := new(bytes.Buffer)
+	if err := envconfig.Usagef(EnvconfigPrefix, config, buf, configFieldsFormat); err != nil {
+		return nil, err
+	}
+
+	var fields []*configField
+	scanner := bufio.NewScanner(buf)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		columns := strings.Split(scanner.Text(), "\t")
+		if len(columns) != 8 {
+			continue
+		}
+		for i, column := range columns {
+			value, err := strconv.Unquote(column)
+			if err != nil {
+				return nil, err
+			}
+			columns[i] = value
+		}
+		field := &configField{
+			key:      columns[0],
+			alt:      columns[1],
+			name:     columns[2],
+			def:      columns[3],
+			required: isTrue(columns[4]),
+			secret:   isTrue(columns[5]) || columns[6] == reflect.TypeOf(Secret{}).String(),
+			value:    columns[7],
+		}
+		if field.secret {
+			field.value = fmt.Sprintf("%x", sha256.Sum256([]byte(field.value)))
+		}
+		fields = append(fields, field)
+	}
+	return fields, scanner.Err()
+}
+
+func isTrue(s string) bool {
+	b, _ := strconv.ParseBool(s)
+	return b
+}
+
+func validateConfigType(config interface{}) error {
+	t := reflect.TypeOf(config)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%v : %v", ErrConfigNotStructPointer, t)
+	}
+	return nil
+}
+
+// resolvedConfig is a loaded config along with the config field provenance
+type resolvedConfig struct {
+	value  reflect.Value
+	fields []*configField
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+//
+// Secret field values are redacted.
+func (c *resolvedConfig) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("type", c.value.Type().String())
+	fields := zerolog.Arr()
+	for _, f := range c.fields {
+		fields.Object(resolvedConfigField{f})
+	}
+	e.Array("fields", fields)
+}
+
+type resolvedConfigField struct {
+	*configField
+}
+
+// the redacted value that is logged in place of secret config values
+const redacted = "****"
+
+func (f resolvedConfigField) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("key", f.key)
+	e.Str("field", f.name)
+	if f.secret {
+		e.Str("value", redacted)
+	} else {
+		e.Str("value", f.value)
+	}
+	e.Str("src", f.source.String())
+}
+
+type resolvedConfigs []*resolvedConfig
+
+func (configs resolvedConfigs) MarshalZerologArray(a *zerolog.Array) {
+	for _, c := range configs {
+		a.Object(c)
+	}
+}
+
+// loadConfig loads a new config instance by layering the config sources in the following order:
+//	1. defaults - the specified config is used as the defaults template
+//	2. config file - the config file is decoded into the config
+//	3. env vars - loaded via `envconfig.Process()`, which also applies `default` struct tags
+//
+// The specified config is copied and not modified.
+func loadConfig(config interface{}, file *configFile) (*resolvedConfig, error) {
+	configType := reflect.TypeOf(config).Elem()
+	cfg := reflect.New(configType)
+	cfg.Elem().Set(reflect.ValueOf(config).Elem())
+
+	defaults, err := configFields(cfg.Interface())
+	if err != nil {
+		return nil, &ConfigError{Type: configType, Err: err}
+	}
+
+	// the config fields whose values were changed by the config file
+	fileFields := make(map[string]bool)
+	if file != nil {
+		if err := file.decode(cfg.Interface()); err != nil {
+			return nil, &ConfigError{Type: configType, Vars: []string{ConfigFileEnvVar}, Err: err}
+		}
+		fields, err := configFields(cfg.Interface())
+		if err != nil {
+			return nil, &ConfigError{Type: configType, Err: err}
+		}
+		for i, field := range fields {
+			if field.value != defaults[i].value {
+				fileFields[field.key] = true
+			}
+		}
+	}
+
+	// check for all missing required vars up front - envconfig fails fast, i.e., only reports the first missing var
+	var missing []string
+	for _, f := range defaults {
+		if f.required && f.def == "" && !f.isSet() {
+			missing = append(missing, f.key)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &ConfigError{Type: configType, Vars: missing, Err: errors.New("required env vars are not set")}
+	}
+
+	if err := envconfig.Process(EnvconfigPrefix, cfg.Interface()); err != nil {
+		if parseErr, ok := err.(*envconfig.ParseError); ok {
+			return nil, &ConfigError{Type: configType, Vars: []string{parseErr.KeyName}, Err: parseErr}
+		}
+		return nil, &ConfigError{Type: configType, Err: err}
+	}
+
+	fields, err := configFields(cfg.Interface())
+	if err != nil {
+		return nil, &ConfigError{Type: configType, Err: err}
+	}
+	for _, f := range fields {
+		switch {
+		case f.isSet():
+			f.source = EnvConfigSource
+		case f.def == "" && fileFields[f.key]:
+			f.source = FileConfigSource
+		}
+	}
+
+	if validator, ok := cfg.Interface().(ConfigValidator); ok {
+		if err := validator.Validate(); err != nil {
+			return nil, &ConfigError{Type: configType, Vars: configFieldErrorVars(err, fields), Err: err}
+		}
+	}
+
+	return &resolvedConfig{cfg.Elem(), fields}, nil
+}
+
+// maps ConfigFieldError(s) to env var names
+func configFieldErrorVars(err error, fields []*configField) []string {
+	var names []string
+	for _, e := range multierr.Errors(err) {
+		fieldErr, ok := e.(ConfigFieldError)
+		if !ok {
+			continue
+		}
+		for _, f := range fields {
+			if f.name == fieldErr.Field {
+				names = append(names, f.key)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// configValueProvider returns a constructor function for the config value type that is used to provide the config
+// value via dependency injection.
+func configValueProvider(value reflect.Value) interface{} {
+	constructorType := reflect.FuncOf(nil, []reflect.Type{value.Type()}, false)
+	return reflect.MakeFunc(constructorType, func([]reflect.Value) []reflect.Value {
+		return []reflect.Value{value}
+	}).Interface()
+}
+
+// loadConfigs loads the config file and all of the configs.
+//
+// If any configs fail to load, then all of the errors are combined and returned.
+func loadConfigs(configs []interface{}) (resolvedConfigs, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+
+	file, err := loadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := make(resolvedConfigs, 0, len(configs))
+	for _, config := range configs {
+		c, e := loadConfig(config, file)
+		if e != nil {
+			err = multierr.Append(err, e)
+			continue
+		}
+		resolved = append(resolved, c)
+	}
+	return resolved, err
+}
+
+type initFailed struct {
+	error
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (err initFailed) MarshalZerologObject(e *zerolog.Event) {
+	// the error message is logged as a string because ConfigError implements zerolog.LogObjectMarshaler
+	e.Str(zerolog.ErrorFieldName, err.Error())
+	if configErrs := ConfigErrors(err.error); len(configErrs) > 0 {
+		arr := zerolog.Arr()
+		for _, configErr := range configErrs {
+			arr.Object(configErr)
+		}
+		e.Array("config", arr)
+	}
+}
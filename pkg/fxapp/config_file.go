@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigFileEnvVar is the env var that is used to specify the app config file path.
+//
+// The config file format is determined by the file extension:
+//	- JSON: .json
+//	- YAML: .yaml, .yml
+//	- TOML: .toml
+//
+// The config file is decoded into each of the app configs using the file format's decoding rules, i.e., config file
+// keys are mapped to config struct fields using the `json`, `yaml`, or `toml` struct tags, and nested objects are mapped
+// to nested structs. Config file keys that do not map to a config field are ignored. Because the config file is shared
+// by all of the app configs, struct tags should be used to keep the config file keys unique across the configs, e.g.,
+//
+//	type DBConfig struct {
+//		URL string `envconfig:"DB_URL" json:"db_url" yaml:"db_url" toml:"db_url"`
+//	}
+const ConfigFileEnvVar = EnvconfigPrefix + "_CONFIG_FILE"
+
+// configFile holds the config file content.
+//
+// A nil configFile means no config file was specified.
+type configFile struct {
+	path string
+	data []byte
+}
+
+// loadConfigFile loads the config file that is specified by the ConfigFileEnvVar env var.
+// If the env var is not set, then nil is returned.
+func loadConfigFile() (*configFile, error) {
+	path, ok := os.LookupEnv(ConfigFileEnvVar)
+	if !ok || strings.TrimSpace(path) == "" {
+		return nil, nil
+	}
+	file, err := readConfigFile(path)
+	if err != nil {
+		return nil, &ConfigError{Vars: []string{ConfigFileEnvVar}, Err: err}
+	}
+	return file, nil
+}
+
+func readConfigFile(path string) (*configFile, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json", ".yaml", ".yml", ".toml":
+	default:
+		return nil, fmt.Errorf("unsupported config file type: %q : %s", ext, path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &configFile{path, data}, nil
+}
+
+// decode decodes the config file into the specified config struct pointer
+func (f *configFile) decode(config interface{}) error {
+	var err error
+	switch strings.ToLower(filepath.Ext(f.path)) {
+	case ".json":
+		err = json.Unmarshal(f.data, config)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(f.data, config)
+	case ".toml":
+		_, err = toml.Decode(string(f.data), config)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file: %s : %v", f.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,397 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/oysterpack/andiamo/pkg/eventlog"
+	"github.com/rs/zerolog"
+	"go.uber.org/fx"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultConfigFileWatchInterval is the default interval used to check the config file for changes
+const DefaultConfigFileWatchInterval = 10 * time.Second
+
+// ConfigChange is published to subscribers when a config is reloaded and its value has changed.
+type ConfigChange struct {
+	// Config is the reloaded config value, i.e., the config struct value type
+	Config interface{}
+	// Keys are the env var names of the config fields that changed
+	Keys []string
+}
+
+// ConfigChangeSubscription wraps the channel used to notify subscribers
+type ConfigChangeSubscription struct {
+	ch chan ConfigChange
+}
+
+// Chan returns the chan in read-only mode
+func (s ConfigChangeSubscription) Chan() <-chan ConfigChange {
+	return s.ch
+}
+
+// SubscribeForConfigChanges is used to subscribe for config changes that match the specified filter.
+// If the filter is nil, then all config changes are published.
+//
+// Config changes are delivered in the order that they were published. Changes are queued for each subscriber, i.e.,
+// slow subscribers do not block config reloads. The subscription channel is closed when the app is stopped.
+type SubscribeForConfigChanges func(filter func(change ConfigChange) bool) ConfigChangeSubscription
+
+// ReloadConfigs is used to trigger the configs to be reloaded.
+//
+// If the configs fail to load, then the current configs are retained and the error is returned.
+type ReloadConfigs func() error
+
+// ConfigTypeFilter returns a ConfigChange filter that matches on the specified config's type, e.g.,
+//
+//	subscribe(fxapp.ConfigTypeFilter(DBConfig{}))
+func ConfigTypeFilter(config interface{}) func(change ConfigChange) bool {
+	configType := reflect.TypeOf(config)
+	return func(change ConfigChange) bool {
+		return reflect.TypeOf(change.Config) == configType
+	}
+}
+
+// config reload related events
+const (
+	// 	type Data struct {
+	//		Trigger string // file, signal, api
+	//		Changes []struct {
+	//			Type  string
+	//			Key   string
+	//			Field string
+	//			Old   string // secret values are redacted
+	//			New   string // secret values are redacted
+	//			Src   string
+	//		}
+	//	}
+	ConfigReloadedEvent = "01M51PPRJQ989GP091M077KEWG"
+	// 	type Data struct {
+	//		Trigger string // file, signal, api
+	//		Err     string `json:"e"`
+	//		Config  []struct {
+	//			Type string
+	//			Vars []string // offending env var names
+	//			Err  string `json:"e"`
+	//		}
+	//	}
+	ConfigReloadFailedEvent = "01M51PPRJQ2TNGVYCA2AX6QM9Z"
+)
+
+// config reload triggers
+const (
+	configReloadTriggerFile   = "file"
+	configReloadTriggerSignal = "signal"
+	configReloadTriggerAPI    = "api"
+)
+
+// ErrConfigServiceNotRunning indicates the config service is not running, i.e., the app has been stopped.
+var ErrConfigServiceNotRunning = errors.New("config service is not running")
+
+// configService holds the current configs and reloads them on demand, or when the config file changes.
+//
+// The app's built-in SIGHUP signal handler also triggers configs to be reloaded.
+type configService struct {
+	sync.Mutex
+	templates     []interface{}
+	configs       resolvedConfigs
+	subscriptions []*configChangeSubscriber
+
+	watchInterval time.Duration
+	stop          chan struct{}
+	stopOnce      sync.Once
+
+	logReloaded, logReloadFailed eventlog.Logger
+}
+
+func newConfigService(templates []interface{}, configs resolvedConfigs, watchInterval time.Duration, logger *zerolog.Logger) *configService {
+	return &configService{
+		templates:       templates,
+		configs:         configs,
+		watchInterval:   watchInterval,
+		stop:            make(chan struct{}),
+		logReloaded:     eventlog.NewLogger(ConfigReloadedEvent, logger, zerolog.InfoLevel),
+		logReloadFailed: eventlog.NewLogger(ConfigReloadFailedEvent, logger, zerolog.ErrorLevel),
+	}
+}
+
+func (s *configService) subscribe(filter func(change ConfigChange) bool) ConfigChangeSubscription {
+	s.Lock()
+	defer s.Unlock()
+	ch := make(chan ConfigChange)
+	select {
+	case <-s.stop:
+		close(ch)
+		return ConfigChangeSubscription{ch}
+	default:
+	}
+	if filter == nil {
+		filter = func(ConfigChange) bool { return true }
+	}
+	s.subscriptions = append(s.subscriptions, &configChangeSubscriber{
+		ch:     ch,
+		filter: filter,
+		notify: make(chan struct{}, 1),
+	})
+	return ConfigChangeSubscription{ch}
+}
+
+func (s *configService) reload(trigger string) error {
+	s.Lock()
+	defer s.Unlock()
+	select {
+	case <-s.stop:
+		return ErrConfigServiceNotRunning
+	default:
+	}
+
+	configs, err := loadConfigs(s.templates)
+	if err != nil {
+		s.logReloadFailed(configReloadFailed{trigger, initFailed{err}}, "config reload failed")
+		return err
+	}
+
+	var changes configFieldChanges
+	for i, config := range configs {
+		configChanges := diffConfigFields(s.configs[i], config)
+		if len(configChanges) == 0 {
+			continue
+		}
+		changes = append(changes, configChanges...)
+		s.publish(ConfigChange{Config: config.value.Interface(), Keys: configChanges.keys()})
+	}
+	s.configs = configs
+	s.logReloaded(configReloaded{trigger, changes}, "config reloaded")
+	return nil
+}
+
+// must be called while holding the lock
+func (s *configService) publish(change ConfigChange) {
+	for _, subscriber := range s.subscriptions {
+		if !subscriber.filter(change) {
+			continue
+		}
+		if !subscriber.running {
+			subscriber.running = true
+			go subscriber.deliver(s.stop)
+		}
+		subscriber.enqueue(change)
+	}
+}
+
+// configChangeSubscriber queues config changes, which are delivered in order by the subscriber's goroutine. The goroutine
+// is started when the first change is published to the subscriber.
+type configChangeSubscriber struct {
+	ch      chan ConfigChange
+	filter  func(change ConfigChange) bool
+	running bool // guarded by the configService lock
+
+	sync.Mutex
+	queue  []ConfigChange
+	notify chan struct{} // signals that changes have been queued
+}
+
+func (s *configChangeSubscriber) enqueue(change ConfigChange) {
+	s.Lock()
+	s.queue = append(s.queue, change)
+	s.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *configChangeSubscriber) dequeue() (ConfigChange, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.queue) == 0 {
+		return ConfigChange{}, false
+	}
+	change := s.queue[0]
+	s.queue = s.queue[1:]
+	return change, true
+}
+
+// deliver sends the queued changes in order until the config service is stopped, and then closes the subscriber channel
+func (s *configChangeSubscriber) deliver(stop <-chan struct{}) {
+	defer close(s.ch)
+	for {
+		change, ok := s.dequeue()
+		if !ok {
+			select {
+			case <-stop:
+				return
+			case <-s.notify:
+				continue
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case s.ch <- change:
+		}
+	}
+}
+
+// start watches the config file for changes
+func (s *configService) start(context.Context) error {
+	if len(s.templates) == 0 {
+		return nil
+	}
+
+	path, ok := os.LookupEnv(ConfigFileEnvVar)
+	if !ok || strings.TrimSpace(path) == "" || s.watchInterval <= 0 {
+		return nil
+	}
+	go s.watchConfigFile(path)
+	return nil
+}
+
+// watchConfigFile polls the config file for content changes. The file content is checked, rather than the file
+// mod time, because Kubernetes mounts ConfigMaps and Secrets using symlinks that are atomically swapped on updates.
+func (s *configService) watchConfigFile(path string) {
+	checksum := configFileChecksum(path)
+	ticker := time.NewTicker(s.watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if latest := configFileChecksum(path); latest != checksum {
+				checksum = latest
+				s.reload(configReloadTriggerFile)
+			}
+		}
+	}
+}
+
+// shutdown stops the config service, and closes the subscriber channels. The channels for subscribers that have been
+// published changes are closed by their goroutines.
+func (s *configService) shutdown(context.Context) error {
+	s.stopOnce.Do(func() {
+		s.Lock()
+		defer s.Unlock()
+		close(s.stop)
+		for _, subscriber := range s.subscriptions {
+			if !subscriber.running {
+				close(subscriber.ch)
+			}
+		}
+		s.subscriptions = nil
+	})
+	return nil
+}
+
+// returns an empty checksum if the file cannot be read, which will trigger a reload once the file can be read again
+func configFileChecksum(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+func provideConfigService(s *configService) (SubscribeForConfigChanges, ReloadConfigs) {
+	return s.subscribe, func() error { return s.reload(configReloadTriggerAPI) }
+}
+
+func startConfigService(s *configService, lc fx.Lifecycle) {
+	lc.Append(fx.Hook{
+		OnStart: s.start,
+		OnStop:  s.shutdown,
+	})
+}
+
+type configFieldChange struct {
+	configType reflect.Type
+	old, new   *configField
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (c configFieldChange) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("type", c.configType.String())
+	e.Str("key", c.new.key)
+	e.Str("field", c.new.name)
+	if c.new.secret {
+		e.Str("old", redacted)
+		e.Str("new", redacted)
+	} else {
+		e.Str("old", c.old.value)
+		e.Str("new", c.new.value)
+	}
+	e.Str("src", c.new.source.String())
+}
+
+type configFieldChanges []configFieldChange
+
+func (changes configFieldChanges) keys() []string {
+	keys := make([]string, len(changes))
+	for i, change := range changes {
+		keys[i] = change.new.key
+	}
+	return keys
+}
+
+// MarshalZerologArray implements zerolog.LogArrayMarshaler interface
+func (changes configFieldChanges) MarshalZerologArray(a *zerolog.Array) {
+	for _, change := range changes {
+		a.Object(change)
+	}
+}
+
+// diffConfigFields compares config field values. Both configs are expected to be of the same type.
+func diffConfigFields(old, new *resolvedConfig) configFieldChanges {
+	var changes configFieldChanges
+	for i, field := range new.fields {
+		if old.fields[i].value != field.value {
+			changes = append(changes, configFieldChange{new.value.Type(), old.fields[i], field})
+		}
+	}
+	return changes
+}
+
+type configReloaded struct {
+	trigger string
+	changes configFieldChanges
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (event configReloaded) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("trigger", event.trigger)
+	e.Array("changes", event.changes)
+}
+
+type configReloadFailed struct {
+	trigger string
+	initFailed
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (event configReloadFailed) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("trigger", event.trigger)
+	event.initFailed.MarshalZerologObject(e)
+}
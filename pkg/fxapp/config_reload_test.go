@@ -0,0 +1,329 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"encoding/json"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func awaitConfigChange(t *testing.T, subscription fxapp.ConfigChangeSubscription) fxapp.ConfigChange {
+	select {
+	case change := <-subscription.Chan():
+		return change
+	case <-time.After(5 * time.Second):
+		t.Fatal("*** timed out waiting for config change")
+		return fxapp.ConfigChange{}
+	}
+}
+
+func logEventNames(t *testing.T, buf *fxapptest.SyncLog) map[string][]string {
+	entries, err := fxapptest.ParseLogEntries(buf)
+	if err != nil {
+		t.Error(err)
+	}
+	names := make(map[string][]string)
+	for _, entry := range entries {
+		names[entry.Name] = append(names[entry.Name], entry.Line)
+	}
+	return names
+}
+
+func TestConfigReload_FileChange(t *testing.T) {
+	keys := []string{"SERVER_HOST", "SERVER_PORT", "SERVER_TAGS", "SERVER_PASSWORD", "CONFIG_FILE"}
+	unsetenv(keys...)
+	defer unsetenv(keys...)
+
+	dir, err := ioutil.TempDir("", "fxapp-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// simulate how Kubernetes mounts ConfigMap volumes:
+	//	config.json -> ..data/config.json
+	//	..data -> ..v1
+	for _, version := range []string{"..v1", "..v2"} {
+		if err := os.Mkdir(filepath.Join(dir, version), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfigFile(t, filepath.Join(dir, "..v1"), "config.json", `{"port": 8080}`)
+	writeConfigFile(t, filepath.Join(dir, "..v2"), "config.json", `{"port": 9090}`)
+	if err := os.Symlink("..v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..data", "config.json"), filepath.Join(dir, "config.json")); err != nil {
+		t.Fatal(err)
+	}
+	setenv("CONFIG_FILE", filepath.Join(dir, "config.json"))
+
+	buf := fxapptest.NewSyncLog()
+	var config ServerConfig
+	var subscribe fxapp.SubscribeForConfigChanges
+	app, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		LogWriter(buf).
+		Config(&ServerConfig{}).
+		SetConfigFileWatchInterval(10*time.Millisecond).
+		Invoke(func() {}).
+		Populate(&config, &subscribe).
+		DisableHTTPServer().
+		Build()
+	if err != nil {
+		t.Fatalf("*** app failed to build: %v", err)
+	}
+	if config.Port != 8080 {
+		t.Errorf("*** config did not match: %v", config)
+	}
+
+	subscription := subscribe(fxapp.ConfigTypeFilter(ServerConfig{}))
+	go app.Run()
+	defer func() {
+		app.Shutdown()
+		<-app.Done()
+	}()
+	<-app.Started()
+
+	// atomically swap the ..data symlink
+	if err := os.Symlink("..v2", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+
+	change := awaitConfigChange(t, subscription)
+	if reloaded, ok := change.Config.(ServerConfig); !ok || reloaded.Port != 9090 {
+		t.Errorf("*** reloaded config did not match: %#v", change.Config)
+	}
+	if len(change.Keys) != 1 || change.Keys[0] != "APP12X_SERVER_PORT" {
+		t.Errorf("*** changed keys did not match: %v", change.Keys)
+	}
+
+	type Change struct {
+		Type  string
+		Key   string
+		Field string
+		Old   string
+		New   string
+		Src   string
+	}
+	type LogEvent struct {
+		Data struct {
+			Trigger string
+			Changes []Change
+		} `json:"d"`
+	}
+	events := logEventNames(t, buf)[fxapp.ConfigReloadedEvent]
+	if len(events) == 0 {
+		t.Fatalf("*** ConfigReloadedEvent was not logged:\n%v", buf)
+	}
+	var logEvent LogEvent
+	if err := json.Unmarshal([]byte(events[0]), &logEvent); err != nil {
+		t.Fatal(err)
+	}
+	expected := Change{Type: "fxapp_test.ServerConfig", Key: "APP12X_SERVER_PORT", Field: "Port", Old: "8080", New: "9090", Src: "file"}
+	switch {
+	case logEvent.Data.Trigger != "file":
+		t.Errorf("*** trigger did not match: %v", logEvent.Data.Trigger)
+	case len(logEvent.Data.Changes) != 1 || logEvent.Data.Changes[0] != expected:
+		t.Errorf("*** changes did not match: %v", logEvent.Data.Changes)
+	}
+}
+
+func TestConfigReload_SIGHUP(t *testing.T) {
+	keys := []string{"SERVER_HOST", "SERVER_PORT", "SERVER_TAGS", "SERVER_PASSWORD", "CONFIG_FILE"}
+	unsetenv(keys...)
+	defer unsetenv(keys...)
+
+	setenv("SERVER_PORT", "8080")
+	setenv("SERVER_PASSWORD", "s3cr3t")
+
+	buf := fxapptest.NewSyncLog()
+	var subscribe fxapp.SubscribeForConfigChanges
+	app, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		LogWriter(buf).
+		Config(&ServerConfig{}).
+		Invoke(func() {}).
+		Populate(&subscribe).
+		DisableHTTPServer().
+		Build()
+	if err != nil {
+		t.Fatalf("*** app failed to build: %v", err)
+	}
+
+	subscription := subscribe(nil)
+	go app.Run()
+	defer func() {
+		app.Shutdown()
+		<-app.Done()
+	}()
+	<-app.Started()
+
+	setenv("SERVER_PASSWORD", "n3w-s3cr3t")
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := process.Signal(syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	change := awaitConfigChange(t, subscription)
+	if reloaded, ok := change.Config.(ServerConfig); !ok || reloaded.Password != "n3w-s3cr3t" {
+		t.Errorf("*** reloaded config did not match: %#v", change.Config)
+	}
+	if len(logEventNames(t, buf)[fxapp.ConfigReloadedEvent]) == 0 {
+		t.Errorf("*** ConfigReloadedEvent was not logged:\n%v", buf)
+	}
+	if strings.Contains(buf.String(), "s3cr3t") {
+		t.Error("*** secret config value was logged")
+	}
+}
+
+func TestConfigReload_Failure(t *testing.T) {
+	keys := []string{"SERVER_HOST", "SERVER_PORT", "SERVER_TAGS", "SERVER_PASSWORD", "CONFIG_FILE"}
+	unsetenv(keys...)
+	defer unsetenv(keys...)
+
+	setenv("SERVER_PORT", "8080")
+
+	buf := fxapptest.NewSyncLog()
+	var reload fxapp.ReloadConfigs
+	var subscribe fxapp.SubscribeForConfigChanges
+	app, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		LogWriter(buf).
+		Config(&ServerConfig{}).
+		Invoke(func() {}).
+		Populate(&reload, &subscribe).
+		DisableHTTPServer().
+		Build()
+	if err != nil {
+		t.Fatalf("*** app failed to build: %v", err)
+	}
+
+	subscription := subscribe(nil)
+	go app.Run()
+	<-app.Started()
+
+	setenv("SERVER_PORT", "NOT_AN_INT")
+	err = reload()
+	if err == nil {
+		t.Fatal("*** config reload should have failed")
+	}
+	t.Log(err)
+	configErrs := fxapp.ConfigErrors(err)
+	if len(configErrs) != 1 || configErrs[0].Vars[0] != "APP12X_SERVER_PORT" {
+		t.Errorf("*** config errors did not match: %v", configErrs)
+	}
+	if len(logEventNames(t, buf)[fxapp.ConfigReloadFailedEvent]) != 1 {
+		t.Errorf("*** ConfigReloadFailedEvent was not logged:\n%v", buf)
+	}
+
+	// reloading the same config values should not publish any changes
+	setenv("SERVER_PORT", "8080")
+	if err := reload(); err != nil {
+		t.Fatalf("*** config reload failed: %v", err)
+	}
+	select {
+	case change := <-subscription.Chan():
+		t.Errorf("*** no config change should have been published: %v", change)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	app.Shutdown()
+	<-app.Done()
+	if err := reload(); err != fxapp.ErrConfigServiceNotRunning {
+		t.Errorf("*** config reload should fail after the app is stopped: %v", err)
+	}
+}
+
+func TestConfigReload_SubscriptionDelivery(t *testing.T) {
+	keys := []string{"SERVER_HOST", "SERVER_PORT", "SERVER_TAGS", "SERVER_PASSWORD", "CONFIG_FILE"}
+	unsetenv(keys...)
+	defer unsetenv(keys...)
+
+	setenv("SERVER_PORT", "8000")
+
+	var reload fxapp.ReloadConfigs
+	var subscribe fxapp.SubscribeForConfigChanges
+	app, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		LogWriter(fxapptest.NewSyncLog()).
+		Config(&ServerConfig{}).
+		Invoke(func() {}).
+		Populate(&reload, &subscribe).
+		DisableHTTPServer().
+		Build()
+	if err != nil {
+		t.Fatalf("*** app failed to build: %v", err)
+	}
+
+	subscription := subscribe(nil)
+	// the subscriber is never published any changes
+	idleSubscription := subscribe(func(fxapp.ConfigChange) bool { return false })
+	go app.Run()
+	<-app.Started()
+
+	// When the configs are reloaded multiple times before the subscriber receives the changes
+	const reloads = 10
+	for i := 1; i <= reloads; i++ {
+		setenv("SERVER_PORT", strconv.Itoa(8000+i))
+		if err := reload(); err != nil {
+			t.Fatalf("*** config reload failed: %v", err)
+		}
+	}
+	// Then the changes are delivered in order
+	for i := 1; i <= reloads; i++ {
+		change := awaitConfigChange(t, subscription)
+		if reloaded, ok := change.Config.(ServerConfig); !ok || reloaded.Port != 8000+i {
+			t.Errorf("*** config changes were not delivered in order: %d : %#v", i, change.Config)
+		}
+	}
+
+	// When the app is stopped, then the subscription channels are closed
+	setenv("SERVER_PORT", "9000")
+	if err := reload(); err != nil {
+		t.Fatalf("*** config reload failed: %v", err)
+	}
+	app.Shutdown()
+	<-app.Done()
+	for _, subscription := range []fxapp.ConfigChangeSubscription{subscription, idleSubscription} {
+		closed := false
+		timeout := time.After(5 * time.Second)
+		for !closed {
+			select {
+			case _, ok := <-subscription.Chan():
+				closed = !ok
+			case <-timeout:
+				t.Fatal("*** subscription channel should have been closed when the app stopped")
+			}
+		}
+	}
+	if _, ok := <-subscribe(nil).Chan(); ok {
+		t.Error("*** subscription channel should be closed when subscribing after the app stopped")
+	}
+}
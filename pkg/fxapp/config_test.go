@@ -0,0 +1,451 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type DBConfig struct {
+	URL            string        `required:"true" envconfig:"DB_URL"`
+	MaxConnections int           `envconfig:"DB_MAX_CONNECTIONS"`
+	Timeout        time.Duration `envconfig:"DB_TIMEOUT"`
+}
+
+func (c *DBConfig) Validate() error {
+	if c.MaxConnections <= 0 {
+		return fxapp.ConfigFieldError{Field: "MaxConnections", Err: errors.New("must be > 0")}
+	}
+	return nil
+}
+
+type CacheConfig struct {
+	Size int `envconfig:"CACHE_SIZE" default:"100"`
+}
+
+func TestBuilder_Config(t *testing.T) {
+	keys := []string{"DB_URL", "DB_MAX_CONNECTIONS", "DB_TIMEOUT", "CACHE_SIZE"}
+	unsetenv(keys...)
+	defer unsetenv(keys...)
+
+	setenv("DB_URL", "postgres://localhost:5432/foo")
+	setenv("DB_TIMEOUT", "10s")
+
+	var dbConfig DBConfig
+	var cacheConfig CacheConfig
+	app, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		// struct field values are used as defaults
+		Config(&DBConfig{MaxConnections: 10}, &CacheConfig{}).
+		Invoke(func(dbConfig DBConfig, cacheConfig CacheConfig) {
+			t.Log(dbConfig, cacheConfig)
+		}).
+		Populate(&dbConfig, &cacheConfig).
+		DisableHTTPServer().
+		Build()
+
+	if err != nil {
+		t.Fatalf("*** app failed to build: %v", err)
+	}
+
+	if len(app.ConfigTypes()) != 2 {
+		t.Errorf("*** config types did not match: %v", app.ConfigTypes())
+	}
+	if dbConfig.URL != "postgres://localhost:5432/foo" {
+		t.Errorf("*** URL did not match: %v", dbConfig.URL)
+	}
+	if dbConfig.MaxConnections != 10 {
+		t.Errorf("*** MaxConnections default should have been applied: %v", dbConfig.MaxConnections)
+	}
+	if dbConfig.Timeout != 10*time.Second {
+		t.Errorf("*** Timeout did not match: %v", dbConfig.Timeout)
+	}
+	if cacheConfig.Size != 100 {
+		t.Errorf("*** Size did not match: %v", cacheConfig.Size)
+	}
+}
+
+func TestBuilder_Config_NotStructPointer(t *testing.T) {
+	_, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Config(DBConfig{}).
+		Invoke(func() {}).
+		DisableHTTPServer().
+		Build()
+
+	switch {
+	case err == nil:
+		t.Error("*** app should have failed to build because the config is not a struct pointer")
+	default:
+		t.Log(err)
+	}
+}
+
+func TestBuilder_Config_LoadFailures(t *testing.T) {
+	keys := []string{"DB_URL", "DB_MAX_CONNECTIONS", "DB_TIMEOUT", "CACHE_SIZE"}
+	unsetenv(keys...)
+	defer unsetenv(keys...)
+
+	type Config struct {
+		Type string
+		Vars []string
+		Err  string `json:"e"`
+	}
+
+	type Data struct {
+		Err    string `json:"e"`
+		Config []Config
+	}
+
+	type LogEvent struct {
+		Name string `json:"n"`
+		Data `json:"d"`
+	}
+
+	initFailedEvent := func(t *testing.T, buf *fxapptest.SyncLog) *LogEvent {
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if line == "" {
+				break
+			}
+			var logEvent LogEvent
+			if err := json.Unmarshal([]byte(line), &logEvent); err != nil {
+				t.Errorf("*** failed to parse log event: %v : %v", err, line)
+				continue
+			}
+			if logEvent.Name == fxapp.InitFailedEvent {
+				return &logEvent
+			}
+		}
+		return nil
+	}
+
+	build := func(buf *fxapptest.SyncLog) error {
+		_, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+			LogWriter(buf).
+			Config(&DBConfig{}, &CacheConfig{}).
+			Invoke(func(DBConfig) {}).
+			DisableHTTPServer().
+			Build()
+		return err
+	}
+
+	t.Run("required var is missing", func(t *testing.T) {
+		buf := fxapptest.NewSyncLog()
+		err := build(buf)
+		if err == nil {
+			t.Fatal("*** app should have failed to build because DB_URL is required")
+		}
+		t.Log(err)
+		logEvent := initFailedEvent(t, buf)
+		switch {
+		case logEvent == nil:
+			t.Errorf("*** InitFailedEvent was not logged:\n%v", buf)
+		case len(logEvent.Config) != 1:
+			t.Errorf("*** config errors did not match: %v", logEvent.Config)
+		default:
+			if len(logEvent.Config[0].Vars) != 1 || logEvent.Config[0].Vars[0] != "APP12X_DB_URL" {
+				t.Errorf("*** offending vars did not match: %v", logEvent.Config[0].Vars)
+			}
+		}
+	})
+
+	t.Run("var fails to parse", func(t *testing.T) {
+		setenv("DB_URL", "postgres://localhost:5432/foo")
+		setenv("DB_MAX_CONNECTIONS", "10")
+		setenv("CACHE_SIZE", "NOT_AN_INT")
+		defer unsetenv(keys...)
+
+		buf := fxapptest.NewSyncLog()
+		err := build(buf)
+		if err == nil {
+			t.Fatal("*** app should have failed to build because CACHE_SIZE is invalid")
+		}
+		t.Log(err)
+		configErrs := fxapp.ConfigErrors(err)
+		if len(configErrs) != 1 || configErrs[0].Vars[0] != "APP12X_CACHE_SIZE" {
+			t.Errorf("*** config errors did not match: %v", configErrs)
+		}
+		if logEvent := initFailedEvent(t, buf); logEvent == nil || len(logEvent.Config) != 1 {
+			t.Errorf("*** InitFailedEvent did not match:\n%v", buf)
+		}
+	})
+
+	t.Run("config fails validation", func(t *testing.T) {
+		setenv("DB_URL", "postgres://localhost:5432/foo")
+		setenv("DB_MAX_CONNECTIONS", "-1")
+		defer unsetenv(keys...)
+
+		buf := fxapptest.NewSyncLog()
+		err := build(buf)
+		if err == nil {
+			t.Fatal("*** app should have failed to build because DB_MAX_CONNECTIONS is invalid")
+		}
+		t.Log(err)
+		logEvent := initFailedEvent(t, buf)
+		switch {
+		case logEvent == nil:
+			t.Errorf("*** InitFailedEvent was not logged:\n%v", buf)
+		case len(logEvent.Config) != 1:
+			t.Errorf("*** config errors did not match: %v", logEvent.Config)
+		default:
+			if len(logEvent.Config[0].Vars) != 1 || logEvent.Config[0].Vars[0] != "APP12X_DB_MAX_CONNECTIONS" {
+				t.Errorf("*** offending vars did not match: %v", logEvent.Config[0].Vars)
+			}
+		}
+	})
+}
+
+type ServerConfig struct {
+	Host     string   `envconfig:"SERVER_HOST"`
+	Port     int      `envconfig:"SERVER_PORT"`
+	Tags     []string `envconfig:"SERVER_TAGS"`
+	Password string   `envconfig:"SERVER_PASSWORD" secret:"true"`
+}
+
+func writeConfigFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBuilder_Config_File(t *testing.T) {
+	keys := []string{"SERVER_HOST", "SERVER_PORT", "SERVER_TAGS", "SERVER_PASSWORD", "CONFIG_FILE"}
+	unsetenv(keys...)
+	defer unsetenv(keys...)
+
+	dir, err := ioutil.TempDir("", "fxapp-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFiles := map[string]string{
+		"config.json": `{"host": "example.com", "port": 8080, "tags": ["a", "b"], "unknown": true}`,
+		"config.yaml": "host: example.com\nport: 8080\ntags:\n  - a\n  - b\n",
+		"config.yml":  "host: example.com\nport: 8080\ntags: [a, b]\n",
+		"config.toml": "host = \"example.com\"\nport = 8080\ntags = [\"a\", \"b\"]\n",
+	}
+
+	for name, content := range configFiles {
+		name, content := name, content
+		t.Run(name, func(t *testing.T) {
+			setenv("CONFIG_FILE", writeConfigFile(t, dir, name, content))
+			defer unsetenv("CONFIG_FILE")
+
+			var config ServerConfig
+			_, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+				Config(&ServerConfig{}).
+				Invoke(func() {}).
+				Populate(&config).
+				DisableHTTPServer().
+				Build()
+			if err != nil {
+				t.Fatalf("*** app failed to build: %v", err)
+			}
+
+			if config.Host != "example.com" || config.Port != 8080 {
+				t.Errorf("*** config did not match: %v", config)
+			}
+			if len(config.Tags) != 2 || config.Tags[0] != "a" || config.Tags[1] != "b" {
+				t.Errorf("*** tags did not match: %v", config.Tags)
+			}
+		})
+	}
+
+	t.Run("unsupported file type", func(t *testing.T) {
+		setenv("CONFIG_FILE", writeConfigFile(t, dir, "config.ini", "host=example.com"))
+		defer unsetenv("CONFIG_FILE")
+
+		_, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+			Config(&ServerConfig{}).
+			Invoke(func() {}).
+			DisableHTTPServer().
+			Build()
+		if err == nil {
+			t.Fatal("*** app should have failed to build because the config file type is not supported")
+		}
+		t.Log(err)
+		configErrs := fxapp.ConfigErrors(err)
+		if len(configErrs) != 1 || configErrs[0].Vars[0] != fxapp.ConfigFileEnvVar {
+			t.Errorf("*** config errors did not match: %v", configErrs)
+		}
+	})
+
+	t.Run("file does not exist", func(t *testing.T) {
+		setenv("CONFIG_FILE", filepath.Join(dir, "missing.json"))
+		defer unsetenv("CONFIG_FILE")
+
+		_, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+			Config(&ServerConfig{}).
+			Invoke(func() {}).
+			DisableHTTPServer().
+			Build()
+		if err == nil {
+			t.Fatal("*** app should have failed to build because the config file does not exist")
+		}
+		t.Log(err)
+	})
+
+	t.Run("file fails to parse", func(t *testing.T) {
+		setenv("CONFIG_FILE", writeConfigFile(t, dir, "invalid.json", `{"port": "NOT_AN_INT"}`))
+		defer unsetenv("CONFIG_FILE")
+
+		_, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+			Config(&ServerConfig{}).
+			Invoke(func() {}).
+			DisableHTTPServer().
+			Build()
+		if err == nil {
+			t.Fatal("*** app should have failed to build because the config file is invalid")
+		}
+		t.Log(err)
+		configErrs := fxapp.ConfigErrors(err)
+		if len(configErrs) != 1 || configErrs[0].Type == nil || configErrs[0].Vars[0] != fxapp.ConfigFileEnvVar {
+			t.Errorf("*** config errors did not match: %v", configErrs)
+		}
+	})
+
+	t.Run("env vars and default struct tags override the config file", func(t *testing.T) {
+		setenv("CONFIG_FILE", writeConfigFile(t, dir, "override.json", `{"port": 8080, "size": 50}`))
+		setenv("SERVER_PORT", "9090")
+		defer unsetenv("CONFIG_FILE", "SERVER_PORT")
+
+		var serverConfig ServerConfig
+		var cacheConfig CacheConfig
+		_, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+			Config(&ServerConfig{}, &CacheConfig{}).
+			Invoke(func() {}).
+			Populate(&serverConfig, &cacheConfig).
+			DisableHTTPServer().
+			Build()
+		if err != nil {
+			t.Fatalf("*** app failed to build: %v", err)
+		}
+		if serverConfig.Port != 9090 {
+			t.Errorf("*** env var should override the config file: %v", serverConfig.Port)
+		}
+		if cacheConfig.Size != 100 {
+			t.Errorf("*** default struct tag should override the config file: %v", cacheConfig.Size)
+		}
+	})
+}
+
+func TestBuilder_Config_Provenance(t *testing.T) {
+	keys := []string{"SERVER_HOST", "SERVER_PORT", "SERVER_TAGS", "SERVER_PASSWORD", "CONFIG_FILE"}
+	unsetenv(keys...)
+	defer unsetenv(keys...)
+
+	dir, err := ioutil.TempDir("", "fxapp-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	setenv("CONFIG_FILE", writeConfigFile(t, dir, "config.yaml", "port: 8080\ntags: [a, b]\n"))
+	// env vars override the config file
+	setenv("SERVER_TAGS", "c")
+	setenv("SERVER_PASSWORD", "s3cr3t")
+
+	buf := fxapptest.NewSyncLog()
+	var config ServerConfig
+	_, err = fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		LogWriter(buf).
+		Config(&ServerConfig{Host: "localhost"}).
+		Invoke(func() {}).
+		Populate(&config).
+		DisableHTTPServer().
+		Build()
+	if err != nil {
+		t.Fatalf("*** app failed to build: %v", err)
+	}
+
+	if config.Host != "localhost" || config.Port != 8080 || len(config.Tags) != 1 || config.Tags[0] != "c" || config.Password != "s3cr3t" {
+		t.Errorf("*** config did not match: %v", config)
+	}
+
+	type Field struct {
+		Key   string
+		Field string
+		Value string
+		Src   string
+	}
+
+	type Config struct {
+		Type   string
+		Fields []Field
+	}
+
+	type Data struct {
+		Configs []Config
+	}
+
+	type LogEvent struct {
+		Name string `json:"n"`
+		Data `json:"d"`
+	}
+
+	var initializedEvent *LogEvent
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line == "" {
+			break
+		}
+		var logEvent LogEvent
+		if err := json.Unmarshal([]byte(line), &logEvent); err != nil {
+			t.Errorf("*** failed to parse log event: %v : %v", err, line)
+			continue
+		}
+		if logEvent.Name == fxapp.InitializedEvent {
+			initializedEvent = &logEvent
+			break
+		}
+	}
+	if initializedEvent == nil {
+		t.Fatalf("*** InitializedEvent was not logged:\n%v", buf)
+	}
+	if len(initializedEvent.Configs) != 1 {
+		t.Fatalf("*** configs did not match: %v", initializedEvent.Configs)
+	}
+
+	expected := map[string]Field{
+		"APP12X_SERVER_HOST":     {Key: "APP12X_SERVER_HOST", Field: "Host", Value: "localhost", Src: "default"},
+		"APP12X_SERVER_PORT":     {Key: "APP12X_SERVER_PORT", Field: "Port", Value: "8080", Src: "file"},
+		"APP12X_SERVER_TAGS":     {Key: "APP12X_SERVER_TAGS", Field: "Tags", Value: "[c]", Src: "env"},
+		"APP12X_SERVER_PASSWORD": {Key: "APP12X_SERVER_PASSWORD", Field: "Password", Value: "****", Src: "env"},
+	}
+	fields := initializedEvent.Configs[0].Fields
+	if len(fields) != len(expected) {
+		t.Errorf("*** config fields did not match: %v", fields)
+	}
+	for _, field := range fields {
+		if field != expected[field.Key] {
+			t.Errorf("*** config field did not match: %v != %v", field, expected[field.Key])
+		}
+	}
+	if strings.Contains(buf.String(), "s3cr3t") {
+		t.Error("*** secret config value was logged")
+	}
+}
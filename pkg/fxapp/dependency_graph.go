@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.uber.org/fx"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// DependencyGraphEndpoint is used to construct the dependency graph HTTP endpoint, which serves the app's dependency
+// injection graph.
+//
+// By default, the graph is served in DOT format (https://graphviz.gitlab.io/_pages/doc/info/lang.html). The graph is
+// served as JSON (see `DependencyGraph`) if the request specifies the `format=json` query param or accepts `application/json`.
+const DependencyGraphEndpoint = "01M51QZJG146ZNHAJSGEGJ63TS"
+
+// dependency graph node kinds
+const (
+	DependencyGraphConstructorNode = "constructor"
+	DependencyGraphTypeNode        = "type"
+	DependencyGraphGroupNode       = "group"
+)
+
+// dependency graph edge kinds
+const (
+	// DependencyGraphProvidesEdge links a constructor to a type that it provides
+	DependencyGraphProvidesEdge = "provides"
+	// DependencyGraphRequiresEdge links a constructor to a type that it depends on
+	DependencyGraphRequiresEdge = "requires"
+	// DependencyGraphMemberEdge links a value group to its members
+	DependencyGraphMemberEdge = "member"
+)
+
+// DependencyGraph is the JSON form of the app's dependency injection graph
+type DependencyGraph struct {
+	Nodes []DependencyGraphNode `json:"nodes"`
+	Edges []DependencyGraphEdge `json:"edges"`
+	// ConstructorTypes are the registered constructor types - see `Options.ConstructorTypes()`
+	ConstructorTypes []string `json:"constructor_types"`
+	// Funcs are the registered invoke function types - see `Options.FuncTypes()`
+	Funcs []string `json:"funcs"`
+}
+
+// DependencyGraphNode is a dependency graph node
+type DependencyGraphNode struct {
+	ID    string `json:"id"`
+	Kind  string `json:"kind"`
+	Label string `json:"label"`
+}
+
+// DependencyGraphEdge is a dependency graph edge
+type DependencyGraphEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Kind     string `json:"kind"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// dependencyGraph is provided for dependency injection when the app is built, but the graph is only complete after the
+// fx app has been constructed. Thus, the graph is initialized by Build once the fx app is constructed.
+type dependencyGraph struct {
+	dot  fx.DotGraph
+	json DependencyGraph
+}
+
+func (g *dependencyGraph) init(dot fx.DotGraph, constructors, funcs []reflect.Type) {
+	g.dot = dot
+	g.json = parseDotGraph(dot)
+	g.json.ConstructorTypes = typeNames(constructors)
+	g.json.Funcs = typeNames(funcs)
+}
+
+func typeNames(types []reflect.Type) []string {
+	names := make([]string, 0, len(types))
+	for _, t := range types {
+		names = append(names, t.String())
+	}
+	return names
+}
+
+// regexps used to parse the DOT graph that is generated by dig
+var (
+	dotClusterRegexp     = regexp.MustCompile(`^subgraph cluster_(\d+) \{$`)
+	dotConstructorRegexp = regexp.MustCompile(`^(constructor_\d+) \[shape=plaintext label="(.*)"\];$`)
+	dotRequiresRegexp    = regexp.MustCompile(`^(constructor_\d+) -> "(.*)" \[ltail=cluster_\d+( style=dashed)?\];$`)
+	dotMemberRegexp      = regexp.MustCompile(`^"(.*)" -> "(.*)";$`)
+	dotNodeRegexp        = regexp.MustCompile(`^"(.*)" \[(.*)\];$`)
+)
+
+// parseDotGraph parses the DOT graph that is generated by dig. Nodes are listed in the order they are declared.
+func parseDotGraph(dot fx.DotGraph) DependencyGraph {
+	var graph DependencyGraph
+	nodes := make(map[string]bool)
+	addNode := func(id, kind, label string) {
+		if nodes[id] {
+			return
+		}
+		nodes[id] = true
+		graph.Nodes = append(graph.Nodes, DependencyGraphNode{ID: id, Kind: kind, Label: label})
+	}
+
+	var constructor string // set while parsing a constructor cluster
+	for _, line := range strings.Split(string(dot), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case dotClusterRegexp.MatchString(line):
+			constructor = fmt.Sprintf("constructor_%s", dotClusterRegexp.FindStringSubmatch(line)[1])
+		case line == "}":
+			constructor = ""
+		case dotConstructorRegexp.MatchString(line):
+			match := dotConstructorRegexp.FindStringSubmatch(line)
+			addNode(match[1], DependencyGraphConstructorNode, match[2])
+		case dotRequiresRegexp.MatchString(line):
+			match := dotRequiresRegexp.FindStringSubmatch(line)
+			graph.Edges = append(graph.Edges, DependencyGraphEdge{
+				From:     match[1],
+				To:       match[2],
+				Kind:     DependencyGraphRequiresEdge,
+				Optional: match[3] != "",
+			})
+		case dotMemberRegexp.MatchString(line):
+			match := dotMemberRegexp.FindStringSubmatch(line)
+			graph.Edges = append(graph.Edges, DependencyGraphEdge{From: match[1], To: match[2], Kind: DependencyGraphMemberEdge})
+		case dotNodeRegexp.MatchString(line):
+			match := dotNodeRegexp.FindStringSubmatch(line)
+			if strings.Contains(match[2], "shape=diamond") {
+				addNode(match[1], DependencyGraphGroupNode, match[1])
+				continue
+			}
+			if constructor == "" {
+				// the node is being decorated, e.g., its color is set to flag a failure
+				continue
+			}
+			addNode(match[1], DependencyGraphTypeNode, match[1])
+			graph.Edges = append(graph.Edges, DependencyGraphEdge{From: constructor, To: match[1], Kind: DependencyGraphProvidesEdge})
+		}
+	}
+	// required types that are not provided by a constructor, e.g., fx.Lifecycle, are only declared as edges
+	for _, edge := range graph.Edges {
+		addNode(edge.To, DependencyGraphTypeNode, edge.To)
+	}
+	return graph
+}
+
+func dependencyGraphHTTPHandler(graph *dependencyGraph) HTTPHandler {
+	return NewAdminHTTPHandler(fmt.Sprintf("/%s", DependencyGraphEndpoint), func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Query().Get("format") == "json" || strings.Contains(request.Header.Get("Accept"), "application/json") {
+			writer.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(writer).Encode(graph.json)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/vnd.graphviz")
+		writer.Write([]byte(graph.dot))
+	})
+}
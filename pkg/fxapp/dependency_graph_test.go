@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"encoding/json"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type Greeter struct{}
+
+func TestDependencyGraphEndpoint(t *testing.T) {
+	t.Parallel()
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Provide(func() *Greeter { return new(Greeter) }).
+		Invoke(func(*Greeter) {}))
+
+	t.Run("DOT", func(t *testing.T) {
+		resp, err := http.Get(app.URL(fxapp.DependencyGraphEndpoint))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(body), "digraph {") || !strings.Contains(string(body), "*fxapp_test.Greeter") {
+			t.Errorf("*** DOT graph was not served: %s", body)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		resp, err := http.Get(app.URL(fxapp.DependencyGraphEndpoint) + "?format=json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var graph fxapp.DependencyGraph
+		if err := json.NewDecoder(resp.Body).Decode(&graph); err != nil {
+			t.Fatal(err)
+		}
+
+		nodes := make(map[string]fxapp.DependencyGraphNode)
+		for _, node := range graph.Nodes {
+			nodes[node.ID] = node
+		}
+		greeterProvided := false
+		for _, edge := range graph.Edges {
+			if _, ok := nodes[edge.From]; !ok {
+				t.Errorf("*** edge node is not declared: %v", edge.From)
+			}
+			if _, ok := nodes[edge.To]; !ok {
+				t.Errorf("*** edge node is not declared: %v", edge.To)
+			}
+			if edge.Kind == fxapp.DependencyGraphProvidesEdge && edge.To == "*fxapp_test.Greeter" {
+				greeterProvided = nodes[edge.From].Kind == fxapp.DependencyGraphConstructorNode
+			}
+		}
+		if !greeterProvided {
+			t.Errorf("*** constructor that provides the Greeter was not found: %v", graph)
+		}
+		if nodes["[type=fxapp.HTTPEndpoint group=HTTPHandler]"].Kind != fxapp.DependencyGraphGroupNode {
+			t.Errorf("*** HTTPEndpoint group node was not found: %v", graph.Nodes)
+		}
+
+		switch {
+		// the test harness provides the *http.Server
+		case len(graph.ConstructorTypes) != 2 || graph.ConstructorTypes[0] != "func() *fxapp_test.Greeter":
+			t.Errorf("*** constructor types did not match: %v", graph.ConstructorTypes)
+		case len(graph.Funcs) != 1 || graph.Funcs[0] != "func(*fxapp_test.Greeter)":
+			t.Errorf("*** funcs did not match: %v", graph.Funcs)
+		}
+	})
+}
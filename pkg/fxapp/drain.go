@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"github.com/oysterpack/andiamo/pkg/eventlog"
+	"github.com/rs/zerolog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReadinessDrainReasonHeader is the readiness probe HTTP response header that is used to report why the app is not
+// ready, when the app is draining.
+const ReadinessDrainReasonHeader = "x-readiness-drain-reason"
+
+// drain related events
+const (
+	// DrainingEvent is logged when the app starts draining, i.e., the readiness probe fails in order to signal that traffic
+	// should no longer be routed to the app. The app waits for the drain delay before the app is stopped.
+	//
+	// 	type Data struct {
+	//		Reason   string
+	//		InFlight uint `json:"in_flight"` // number of in-flight HTTP requests
+	//		Delay    uint // drain delay
+	//	}
+	DrainingEvent = "01M51Q4PQY5CCW4JH54DVA9RY6"
+	// 	type Data struct {
+	//		InFlight uint `json:"in_flight"` // number of in-flight HTTP requests
+	//		Duration uint
+	//	}
+	DrainedEvent = "01M51Q4PQYGXYQW5WDTYZ2EB34"
+)
+
+// drainer tracks in-flight HTTP requests and the drain state, which is used by the readiness probe and the gRPC health
+// service
+type drainer struct {
+	inFlight int64
+
+	sync.Mutex
+	reason   string
+	draining chan struct{} // closed when the app starts draining
+}
+
+// track wraps the HTTP handler in order to track in-flight requests
+func (d *drainer) track(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&d.inFlight, 1)
+		defer atomic.AddInt64(&d.inFlight, -1)
+		handler.ServeHTTP(w, req)
+	})
+}
+
+func (d *drainer) inFlightRequests() uint {
+	return uint(atomic.LoadInt64(&d.inFlight))
+}
+
+// drainReason returns the reason why the app is draining, and false if the app is not draining
+func (d *drainer) drainReason() (string, bool) {
+	d.Lock()
+	defer d.Unlock()
+	return d.reason, d.reason != ""
+}
+
+// drainingChan returns a channel that is closed when the app starts draining
+func (d *drainer) drainingChan() <-chan struct{} {
+	d.Lock()
+	defer d.Unlock()
+	if d.draining == nil {
+		d.draining = make(chan struct{})
+	}
+	return d.draining
+}
+
+// drain flips the readiness probe to unavailable, and the gRPC health service to NOT_SERVING, and then waits for the
+// drain delay
+func (d *drainer) drain(reason string, delay time.Duration, logger *zerolog.Logger) {
+	d.Lock()
+	d.reason = reason
+	if d.draining == nil {
+		d.draining = make(chan struct{})
+	}
+	close(d.draining)
+	d.Unlock()
+
+	eventlog.NewLogger(DrainingEvent, logger, zerolog.NoLevel)(draining{reason, d.inFlightRequests(), delay}, "app draining")
+	start := time.Now()
+	time.Sleep(delay)
+	eventlog.NewLogger(DrainedEvent, logger, zerolog.NoLevel)(drained{d.inFlightRequests(), time.Since(start)}, "app drained")
+}
+
+type draining struct {
+	reason   string
+	inFlight uint
+	delay    time.Duration
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (event draining) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("reason", event.reason)
+	e.Uint("in_flight", event.inFlight)
+	e.Dur("delay", event.delay)
+}
+
+type drained struct {
+	inFlight uint
+	duration time.Duration
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (event drained) MarshalZerologObject(e *zerolog.Event) {
+	e.Uint("in_flight", event.inFlight)
+	e.Dur("duration", event.duration)
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestApp_DrainOnShutdown(t *testing.T) {
+	buf := fxapptest.NewSyncLog()
+	requestReceived := make(chan struct{})
+	releaseRequest := make(chan struct{})
+	app, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Provide(
+			func() fxapp.HTTPHandler {
+				return fxapp.NewHTTPHandler("/slow", func(writer http.ResponseWriter, request *http.Request) {
+					close(requestReceived)
+					<-releaseRequest
+					writer.WriteHeader(http.StatusOK)
+				})
+			},
+			func() *http.Server {
+				return &http.Server{
+					Addr: ":5051",
+				}
+			},
+		).
+		Invoke(func() {}).
+		SetDrainDelay(200 * time.Millisecond).
+		LogWriter(buf).
+		Build()
+	if err != nil {
+		t.Fatalf("*** app build failed: %v", err)
+	}
+
+	go app.Run()
+	<-app.Ready()
+
+	readinessURL := fmt.Sprintf("http://:5051/%s", fxapp.ReadyEvent)
+	checkHTTPGetResponseStatusOK(t, readinessURL)
+
+	slowRequestDone := make(chan error)
+	go func() {
+		resp, err := http.Get("http://:5051/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+		slowRequestDone <- err
+	}()
+	<-requestReceived
+
+	app.Shutdown()
+	<-app.Stopping()
+
+	// Then the readiness probe fails while the app is draining
+	var drainReason string
+	for i := 0; i < 10 && drainReason == ""; i++ {
+		resp, err := http.Get(readinessURL)
+		if err != nil {
+			t.Fatalf("*** readiness probe request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			drainReason = resp.Header.Get(fxapp.ReadinessDrainReasonHeader)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if drainReason == "" {
+		t.Error("*** readiness probe should have failed with a drain reason while the app is draining")
+	}
+	t.Logf("drain reason: %s", drainReason)
+
+	close(releaseRequest)
+	if err := <-slowRequestDone; err != nil {
+		t.Errorf("*** in-flight request failed: %v", err)
+	}
+	<-app.Done()
+
+	type LogEvent struct {
+		Name string `json:"n"`
+		Data struct {
+			Reason   string
+			InFlight uint `json:"in_flight"`
+			Delay    uint
+		} `json:"d"`
+	}
+
+	events := logEventNames(t, buf)
+	switch {
+	case len(events[fxapp.DrainingEvent]) != 1:
+		t.Errorf("*** DrainingEvent was not logged:\n%v", buf)
+	case len(events[fxapp.DrainedEvent]) != 1:
+		t.Errorf("*** DrainedEvent was not logged:\n%v", buf)
+	default:
+		var logEvent LogEvent
+		if err := json.Unmarshal([]byte(events[fxapp.DrainingEvent][0]), &logEvent); err != nil {
+			t.Fatal(err)
+		}
+		if logEvent.Data.InFlight < 1 || logEvent.Data.Reason != drainReason {
+			t.Errorf("*** DrainingEvent did not match: %v", events[fxapp.DrainingEvent][0])
+		}
+	}
+}
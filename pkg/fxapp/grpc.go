@@ -0,0 +1,464 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/oysterpack/andiamo/pkg/eventlog"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"go.uber.org/fx"
+	"go.uber.org/multierr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GRPCServerModuleID is the gRPC server module ID - see `NewGRPCServerModule()`
+const GRPCServerModuleID = "01M51S10ZY3VVGJB1XKF812N9N"
+
+// gRPC server defaults
+const (
+	DefaultGRPCServerAddr          = ":8010"
+	DefaultGRPCHealthWatchInterval = time.Second
+)
+
+// GRPCService is used to group GRPCServiceRegistration(s) together.
+// The gRPC services are automatically registered with the app's gRPC server - see `NewGRPCServerModule()`.
+type GRPCService struct {
+	fx.Out
+
+	GRPCServiceRegistration `group:"GRPCService"`
+}
+
+// NewGRPCService constructs a new GRPCService. The register func is passed the gRPC server, e.g.,
+//
+//	fxapp.NewGRPCService(func(server grpc.ServiceRegistrar) { pb.RegisterGreeterServer(server, greeter) })
+func NewGRPCService(register func(server grpc.ServiceRegistrar)) GRPCService {
+	return GRPCService{
+		GRPCServiceRegistration: GRPCServiceRegistration{Register: register},
+	}
+}
+
+// GRPCServiceRegistration is used to register gRPC services with the app's gRPC server
+type GRPCServiceRegistration struct {
+	Register func(server grpc.ServiceRegistrar)
+}
+
+// GRPCServerOpts is used to configure the gRPC server. Zero values imply using the defaults.
+type GRPCServerOpts struct {
+	// Addr is the TCP address that the gRPC server listens on
+	Addr string
+	// ServerOptions are applied when the gRPC server is constructed, e.g., TLS credentials and keepalive params.
+	// Interceptors that are specified via `grpc.ChainUnaryInterceptor()` and `grpc.ChainStreamInterceptor()` are chained
+	// after the built-in request event and metrics interceptors.
+	ServerOptions []grpc.ServerOption
+	// HealthWatchInterval is how often the health status is checked for grpc.health.v1 Watch streams
+	HealthWatchInterval time.Duration
+}
+
+func (opts GRPCServerOpts) withDefaults() GRPCServerOpts {
+	if opts.Addr == "" {
+		opts.Addr = DefaultGRPCServerAddr
+	}
+	if opts.HealthWatchInterval <= 0 {
+		opts.HealthWatchInterval = DefaultGRPCHealthWatchInterval
+	}
+	return opts
+}
+
+// NewGRPCServerModule constructs a module that runs a gRPC server. The gRPC services are discovered via the "GRPCService"
+// group - see `NewGRPCService()`. The gRPC server is only run if the module is installed, i.e., it is independent of the
+// app's HTTP server.
+//
+// The gRPC server provides the following:
+//	- grpc.health.v1 is served using the app's health service, i.e., the overall health is reported for the blank service
+//	  name, and health checks are reported by using the health check ID as the service name - see `grpcHealthServer`
+//	- each request is logged - see `GRPCRequestEvent`
+//	- request metrics - see `GRPCRequestsMetricID` and `GRPCRequestDurationMetricID`
+//
+// The gRPC server lifecycle is bound to the app lifecycle, and the app is not ready until the gRPC server is running.
+// When the app starts draining, then grpc.health.v1 reports NOT_SERVING. When the app is stopped, the gRPC server is
+// stopped gracefully.
+func NewGRPCServerModule(opts GRPCServerOpts) Module {
+	opts = opts.withDefaults()
+	metrics := newGRPCRequestMetrics()
+	return Module{
+		ID:               GRPCServerModuleID,
+		Name:             "grpc",
+		MetricCollectors: metrics.collectors(),
+		Invoke: []interface{}{
+			func(deps grpcServerDeps, logger *zerolog.Logger, lc fx.Lifecycle, readiness ReadinessWaitGroup) error {
+				return runGRPCServer(opts, deps, metrics, logger, lc, readiness)
+			},
+		},
+	}
+}
+
+type grpcServerDeps struct {
+	fx.In
+
+	Services []GRPCServiceRegistration `group:"GRPCService"`
+
+	OverallHealth health.OverallHealth
+	CheckResults  health.CheckResults
+	Drainer       *drainer
+}
+
+func runGRPCServer(opts GRPCServerOpts, deps grpcServerDeps, metrics grpcRequestMetrics, logger *zerolog.Logger, lc fx.Lifecycle, readiness ReadinessWaitGroup) error {
+	requestLogger := newGRPCRequestLogger(logger)
+	serverOpts := append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryServerInterceptor(requestLogger.log), unaryServerInterceptor(metrics.observe)),
+		grpc.ChainStreamInterceptor(streamServerInterceptor(requestLogger.log), streamServerInterceptor(metrics.observe)),
+	}, opts.ServerOptions...)
+	server := grpc.NewServer(serverOpts...)
+
+	healthServer := newGRPCHealthServer(deps.OverallHealth, deps.CheckResults, deps.Drainer.drainingChan(), opts.HealthWatchInterval)
+	registrar := &grpcServiceRegistrar{server: server}
+	grpc_health_v1.RegisterHealthServer(registrar, healthServer)
+	for _, service := range deps.Services {
+		if service.Register == nil {
+			return errors.New("gRPC service register func is nil")
+		}
+		service.Register(registrar)
+	}
+	if registrar.err != nil {
+		return registrar.err
+	}
+
+	readiness.Inc()
+	logServerErr := eventlog.NewLogger(GRPCServerError, logger, zerolog.ErrorLevel)
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			listener, err := net.Listen("tcp", opts.Addr)
+			if err != nil {
+				return err
+			}
+			eventlog.NewLogger(GRPCServerStarting, logger, zerolog.InfoLevel)(newGRPCServerInfo(listener.Addr(), server), "starting gRPC server")
+			// wait for the gRPC server go routine to start running before returning
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				wg.Done()
+				readiness.Done()
+				if err := server.Serve(listener); err != nil && err != grpc.ErrServerStopped {
+					logServerErr(grpcServeError{err}, "gRPC server has exited with an error")
+				}
+			}()
+			wg.Wait()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			// Watch streams are long lived - they must be ended in order for the server to stop gracefully
+			healthServer.shutdown()
+			stopped := make(chan struct{})
+			go func() {
+				server.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+				return nil
+			case <-ctx.Done():
+				server.Stop()
+				return ctx.Err()
+			}
+		},
+	})
+	return nil
+}
+
+// grpcServiceRegistrar guards against duplicate service registrations, which grpc.Server treats as fatal
+type grpcServiceRegistrar struct {
+	server *grpc.Server
+	err    error
+}
+
+func (r *grpcServiceRegistrar) RegisterService(desc *grpc.ServiceDesc, impl interface{}) {
+	if _, ok := r.server.GetServiceInfo()[desc.ServiceName]; ok {
+		r.err = multierr.Append(r.err, fmt.Errorf("duplicate gRPC service: %v", desc.ServiceName))
+		return
+	}
+	r.server.RegisterService(desc, impl)
+}
+
+// grpcHealthServer implements grpc.health.v1 using the app's health service:
+//	- the overall health is reported for the blank service name
+//	- health checks are reported by using the health check ID as the service name. If there is no result for the health
+//	  check, then Check fails with NOT_FOUND, and Watch reports SERVICE_UNKNOWN.
+//
+// Green and Yellow health map to SERVING, and Red maps to NOT_SERVING. When the app is draining, then NOT_SERVING is
+// reported, in order for clients to stop routing requests to the app. When the gRPC server is stopping, then NOT_SERVING
+// is reported, and Watch streams are ended.
+type grpcHealthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+
+	overallHealth health.OverallHealth
+	checkResults  health.CheckResults
+	draining      <-chan struct{}
+	watchInterval time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newGRPCHealthServer(overallHealth health.OverallHealth, checkResults health.CheckResults, draining <-chan struct{}, watchInterval time.Duration) *grpcHealthServer {
+	return &grpcHealthServer{
+		overallHealth: overallHealth,
+		checkResults:  checkResults,
+		draining:      draining,
+		watchInterval: watchInterval,
+		stop:          make(chan struct{}),
+	}
+}
+
+func (s *grpcHealthServer) shutdown() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// status returns false if the service is unknown
+func (s *grpcHealthServer) status(service string) (grpc_health_v1.HealthCheckResponse_ServingStatus, bool) {
+	select {
+	case <-s.stop:
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING, true
+	case <-s.draining:
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING, true
+	default:
+	}
+	if service == "" {
+		return grpcServingStatus(s.overallHealth()), true
+	}
+	results := <-s.checkResults(func(result health.Result) bool { return result.ID == service })
+	if len(results) == 0 {
+		return grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN, false
+	}
+	return grpcServingStatus(results[0].Status), true
+}
+
+func (s *grpcHealthServer) Check(ctx context.Context, request *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	servingStatus, ok := s.status(request.Service)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown service: %q", request.Service)
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: servingStatus}, nil
+}
+
+// Watch sends the current status, and then polls the status on the watch interval. Status changes are sent.
+func (s *grpcHealthServer) Watch(request *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	ticker := time.NewTicker(s.watchInterval)
+	defer ticker.Stop()
+	var last grpc_health_v1.HealthCheckResponse_ServingStatus
+	sent := false
+	draining := s.draining
+	for {
+		servingStatus, _ := s.status(request.Service)
+		if !sent || servingStatus != last {
+			if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: servingStatus}); err != nil {
+				return err
+			}
+			sent, last = true, servingStatus
+		}
+		select {
+		case <-stream.Context().Done():
+			return status.Error(codes.Canceled, "health watch stream has ended")
+		case <-s.stop:
+			if last != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+				return stream.Send(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_NOT_SERVING})
+			}
+			return nil
+		case <-draining:
+			// the status is sent immediately - draining is only selected once
+			draining = nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func grpcServingStatus(status health.Status) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	if status == health.Red {
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}
+	return grpc_health_v1.HealthCheckResponse_SERVING
+}
+
+// grpcHealthMethodPrefix is used to identify grpc.health.v1 requests, which are logged at debug level
+const grpcHealthMethodPrefix = "/grpc.health.v1.Health/"
+
+// unaryServerInterceptor calls the observe func after each unary request
+func unaryServerInterceptor(observe func(method string, start time.Time, err error)) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		response, err := handler(ctx, request)
+		observe(info.FullMethod, start, err)
+		return response, err
+	}
+}
+
+// streamServerInterceptor calls the observe func after each stream ends
+func streamServerInterceptor(observe func(method string, start time.Time, err error)) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, stream)
+		observe(info.FullMethod, start, err)
+		return err
+	}
+}
+
+// gRPC server related events
+const (
+	// GRPCServerStarting is logged when the gRPC server is starting.
+	//
+	// 	type Data struct {
+	//		Addr     string
+	//		Services []string // sorted
+	//	}
+	GRPCServerStarting = "01M51S10ZYVH598Z09GCNF95BR"
+
+	// GRPCServerError indicates the gRPC server exited with an error.
+	//
+	// 	type Data struct {
+	//		Err string `json:"e"`
+	//	}
+	GRPCServerError = "01M51S10ZYKEF411BB417PHHXY"
+
+	// GRPCRequestEvent is logged for each gRPC request. grpc.health.v1 requests are logged at debug level.
+	//
+	// 	type Data struct {
+	//		Method string // full method name, e.g., "/helloworld.Greeter/SayHello"
+	//		Code   string // gRPC status code, e.g., "OK"
+	//		Err    string `json:"e"`
+	//		Dur    uint // msec
+	//	}
+	GRPCRequestEvent = "01M51S10ZYQ581B98NTBAGYPB1"
+)
+
+type grpcServerInfo struct {
+	addr     string
+	services []string
+}
+
+func newGRPCServerInfo(addr net.Addr, server *grpc.Server) grpcServerInfo {
+	services := make([]string, 0, len(server.GetServiceInfo()))
+	for service := range server.GetServiceInfo() {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return grpcServerInfo{
+		addr:     addr.String(),
+		services: services,
+	}
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (info grpcServerInfo) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("addr", info.addr).Strs("services", info.services)
+}
+
+type grpcServeError struct {
+	error
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (err grpcServeError) MarshalZerologObject(e *zerolog.Event) {
+	e.Err(err)
+}
+
+type grpcRequestLogger struct {
+	logRequest, logHealthRequest eventlog.Logger
+}
+
+func newGRPCRequestLogger(logger *zerolog.Logger) grpcRequestLogger {
+	return grpcRequestLogger{
+		logRequest:       eventlog.NewLogger(GRPCRequestEvent, logger, zerolog.InfoLevel),
+		logHealthRequest: eventlog.NewLogger(GRPCRequestEvent, logger, zerolog.DebugLevel),
+	}
+}
+
+func (l grpcRequestLogger) log(method string, start time.Time, err error) {
+	logRequest := l.logRequest
+	if strings.HasPrefix(method, grpcHealthMethodPrefix) {
+		logRequest = l.logHealthRequest
+	}
+	logRequest(grpcRequest{
+		method:   method,
+		code:     status.Code(err),
+		err:      err,
+		duration: time.Since(start),
+	}, "gRPC request")
+}
+
+type grpcRequest struct {
+	method   string
+	code     codes.Code
+	err      error
+	duration time.Duration
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (event grpcRequest) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("method", event.method)
+	e.Str("code", event.code.String())
+	if event.err != nil {
+		e.Err(event.err)
+	}
+	e.Dur("dur", event.duration)
+}
+
+// gRPC request metrics
+const (
+	// GRPCRequestsMetricID counts gRPC requests - labels: method, code
+	GRPCRequestsMetricID = "U01M51S10ZYK14TC69H6WJP2WFF"
+	// GRPCRequestDurationMetricID is the gRPC request latency histogram in seconds - labels: method
+	GRPCRequestDurationMetricID = "U01M51S10ZYWENBAK505589WZ18"
+)
+
+type grpcRequestMetrics struct {
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+}
+
+func newGRPCRequestMetrics() grpcRequestMetrics {
+	return grpcRequestMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: GRPCRequestsMetricID,
+			Help: "gRPC request count",
+		}, []string{"method", "code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    GRPCRequestDurationMetricID,
+			Help:    "gRPC request latency in seconds",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+	}
+}
+
+func (m grpcRequestMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.requests, m.latency}
+}
+
+func (m grpcRequestMetrics) observe(method string, start time.Time, err error) {
+	m.latency.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	m.requests.WithLabelValues(method, status.Code(err).String()).Inc()
+}
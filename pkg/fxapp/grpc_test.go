@@ -0,0 +1,244 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"context"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoServiceDesc is a hand written gRPC service, which echoes the request string
+var echoServiceDesc = grpc.ServiceDesc{
+	ServiceName: "test.Echo",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Echo",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				request := new(wrapperspb.StringValue)
+				if err := dec(request); err != nil {
+					return nil, err
+				}
+				echo := func(ctx context.Context, request interface{}) (interface{}, error) {
+					return request, nil
+				}
+				if interceptor == nil {
+					return echo(ctx, request)
+				}
+				return interceptor(ctx, request, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/test.Echo/Echo"}, echo)
+			},
+		},
+	},
+}
+
+func echoService() fxapp.GRPCService {
+	return fxapp.NewGRPCService(func(server grpc.ServiceRegistrar) {
+		server.RegisterService(&echoServiceDesc, struct{}{})
+	})
+}
+
+func TestGRPCServerModule(t *testing.T) {
+	t.Parallel()
+	addr := freeAddr(t)
+	checkID := ulids.MustNew().String()
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Modules(fxapp.NewGRPCServerModule(fxapp.GRPCServerOpts{
+			Addr:                addr,
+			HealthWatchInterval: 10 * time.Millisecond,
+		})).
+		Provide(echoService).
+		Invoke(func(register health.Register) error {
+			return register(health.Check{
+				ID:          checkID,
+				Description: "check",
+				RedImpact:   "none",
+			}, health.CheckerOpts{}, func() (health.Status, error) {
+				return health.Green, nil
+			})
+		}))
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("gRPC server starting event", func(t *testing.T) {
+		entry, err := fxapptest.AwaitEvent(app.Log, fxapp.GRPCServerStarting, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data struct {
+			Addr     string
+			Services []string
+		}
+		if err := entry.DecodeData(&data); err != nil {
+			t.Fatal(err)
+		}
+		if data.Addr != addr || strings.Join(data.Services, ",") != "grpc.health.v1.Health,test.Echo" {
+			t.Errorf("*** gRPC server starting event did not match: %v", entry)
+		}
+	})
+
+	t.Run("gRPC service request", func(t *testing.T) {
+		response := new(wrapperspb.StringValue)
+		if err := conn.Invoke(ctx, "/test.Echo/Echo", wrapperspb.String("hello"), response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Value != "hello" {
+			t.Errorf("*** response did not match: %v", response.Value)
+		}
+
+		entry, err := fxapptest.AwaitEvent(app.Log, fxapp.GRPCRequestEvent, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data struct{ Method, Code string }
+		if err := entry.DecodeData(&data); err != nil {
+			t.Fatal(err)
+		}
+		if data.Method != "/test.Echo/Echo" || data.Code != codes.OK.String() {
+			t.Errorf("*** gRPC request event did not match: %v", entry)
+		}
+
+		resp, err := http.Get(app.URL(fxapp.MetricsEndpoint))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		metrics, _ := ioutil.ReadAll(resp.Body)
+		for _, metric := range []string{fxapp.GRPCRequestsMetricID, fxapp.GRPCRequestDurationMetricID} {
+			if !strings.Contains(string(metrics), metric) || !strings.Contains(string(metrics), `method="/test.Echo/Echo"`) {
+				t.Errorf("*** gRPC request metric was not found: %v", metric)
+			}
+		}
+	})
+
+	t.Run("grpc.health.v1 Check", func(t *testing.T) {
+		client := grpc_health_v1.NewHealthClient(conn)
+		for _, service := range []string{"", checkID} {
+			response, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+			switch {
+			case err != nil:
+				t.Errorf("*** health check failed: %q : %v", service, err)
+			case response.Status != grpc_health_v1.HealthCheckResponse_SERVING:
+				t.Errorf("*** health status did not match: %q : %v", service, response.Status)
+			}
+		}
+		if _, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: ulids.MustNew().String()}); status.Code(err) != codes.NotFound {
+			t.Errorf("*** unknown service should have failed with NOT_FOUND: %v", err)
+		}
+	})
+
+	t.Run("grpc.health.v1 Watch", func(t *testing.T) {
+		stream, err := grpc_health_v1.NewHealthClient(conn).Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response, err := stream.Recv(); err != nil || response.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+			t.Fatalf("*** watch status did not match: %v : %v", response, err)
+		}
+
+		// When the app is shutdown
+		app.Shutdown()
+		// Then NOT_SERVING is sent, and the stream is ended
+		if response, err := stream.Recv(); err != nil || response.Status != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+			t.Errorf("*** watch status did not match: %v : %v", response, err)
+		}
+		select {
+		case <-app.Done():
+		case <-time.After(fxapptest.DoneTimeout):
+			t.Error("*** timed out waiting for app to shutdown")
+		}
+	})
+}
+
+func TestGRPCServerModule_Draining(t *testing.T) {
+	t.Parallel()
+	addr := freeAddr(t)
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Modules(fxapp.NewGRPCServerModule(fxapp.GRPCServerOpts{
+			Addr: addr,
+			// Watch streams are notified when the app starts draining, i.e., without waiting for the watch interval
+			HealthWatchInterval: time.Minute,
+		})).
+		SetDrainDelay(time.Second).
+		Invoke(func() {}))
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := grpc_health_v1.NewHealthClient(conn)
+	stream, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response, err := stream.Recv(); err != nil || response.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("*** watch status did not match: %v : %v", response, err)
+	}
+
+	// When the app is shutdown
+	app.Shutdown()
+	// Then NOT_SERVING is reported while the app is draining
+	if response, err := stream.Recv(); err != nil || response.Status != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("*** watch status did not match: %v : %v", response, err)
+	}
+	if response, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{}); err != nil || response.Status != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("*** health status did not match: %v : %v", response, err)
+	}
+	if len(fxapptest.EventsNamed(app.Log, fxapp.DrainedEvent)) != 0 {
+		t.Error("*** NOT_SERVING should have been reported before the app was drained")
+	}
+	select {
+	case <-app.Done():
+	case <-time.After(fxapptest.DoneTimeout):
+		t.Error("*** timed out waiting for app to shutdown")
+	}
+}
+
+func TestGRPCServerModule_DuplicateService(t *testing.T) {
+	t.Parallel()
+	_, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Modules(fxapp.NewGRPCServerModule(fxapp.GRPCServerOpts{})).
+		Provide(echoService, echoService).
+		Invoke(func() {}).
+		LogWriter(fxapptest.NewSyncLog()).
+		Build()
+	if err == nil {
+		t.Error("*** app should have failed to build because the gRPC service was registered twice")
+	}
+}
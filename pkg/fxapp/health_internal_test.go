@@ -18,10 +18,10 @@ package fxapp
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"github.com/oysterpack/andiamo/pkg/fx/health"
-	"github.com/oysterpack/andiamo/pkg/fxapptest"
 	"github.com/oysterpack/andiamo/pkg/ulids"
 	"github.com/rs/zerolog"
 	"go.uber.org/fx"
@@ -31,6 +31,25 @@ import (
 	"time"
 )
 
+// syncLog is a concurrency safe log buffer. fxapptest.SyncLog cannot be used by internal tests because fxapptest
+// imports fxapp.
+type syncLog struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *syncLog) Write(data []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.buf.Write(data)
+}
+
+func (l *syncLog) Read(p []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.buf.Read(p)
+}
+
 func TestLogYellowHealthCheckResult(t *testing.T) {
 	t.Parallel()
 
@@ -66,7 +85,7 @@ func TestLogYellowHealthCheckResult(t *testing.T) {
 		shutdowner.Shutdown()
 	}()
 
-	buf := fxapptest.NewSyncLog()
+	buf := new(syncLog)
 	logger := zerolog.New(zerolog.SyncWriter(buf))
 	done := make(chan struct{})
 	defer close(done)
@@ -185,7 +204,7 @@ func TestLogRedHealthCheckResult(t *testing.T) {
 		shutdowner.Shutdown()
 	}()
 
-	buf := fxapptest.NewSyncLog()
+	buf := new(syncLog)
 	logger := zerolog.New(zerolog.SyncWriter(buf))
 	done := make(chan struct{})
 	defer close(done)
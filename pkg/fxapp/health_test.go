@@ -17,15 +17,12 @@
 package fxapp_test
 
 import (
-	"bufio"
-	"encoding/json"
 	"github.com/oysterpack/andiamo/pkg/fx/health"
 	"github.com/oysterpack/andiamo/pkg/fxapp"
 	"github.com/oysterpack/andiamo/pkg/fxapptest"
 	"github.com/oysterpack/andiamo/pkg/ulids"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
-	"strings"
 	"testing"
 	"time"
 )
@@ -108,49 +105,26 @@ func TestRegisteredHealthChecksAreLogged(t *testing.T) {
 		RunInterval  uint `json:"run_interval"`
 	}
 
-	type LogEvent struct {
-		Name    string `json:"n"`
-		Message string `json:"m"`
-		Data    Data   `json:"d"`
+	logEvent, err := fxapptest.AwaitEvent(buf, fxapp.HealthCheckRegisteredEvent, time.Second)
+	if err != nil {
+		t.Fatalf("*** health check registration event was not logged: %v", err)
 	}
-	var logEvent LogEvent
-
-FoundEvent:
-	for i := 0; i < 3; i++ {
-		for _, line := range strings.Split(buf.String(), "\n") {
-			if line == "" {
-				break
-			}
-			t.Log(line)
-			err := json.Unmarshal([]byte(line), &logEvent)
-			if err != nil {
-				t.Errorf("*** failed to parse log event: %v : %v", err, line)
-				break
-			}
-			if logEvent.Name == "01DF3FV60A2J1WKX5NQHP47H61" {
-				break FoundEvent
-			}
-		}
-		time.Sleep(time.Millisecond)
+	t.Log(logEvent)
+	var data Data
+	if err := logEvent.DecodeData(&data); err != nil {
+		t.Fatal(err)
 	}
-
-	switch {
-	case logEvent.Name == "01DF3FV60A2J1WKX5NQHP47H61":
-		t.Logf("%#v", logEvent)
-		if logEvent.Data.ID != Foo.ID {
-			t.Errorf("*** health check ID did not match: %v != %v", logEvent.Data.ID, Foo.ID)
-		}
-		if logEvent.Data.Description != Foo.Description {
-			t.Error("*** health check description did not match")
-		}
-		if logEvent.Data.YellowImpact != Foo.YellowImpact {
-			t.Error("*** health check yellow impact did not match")
-		}
-		if logEvent.Data.RedImpact != Foo.RedImpact {
-			t.Error("*** health check red impact did not match")
-		}
-	default:
-		t.Error("*** health check registration event was not logged")
+	if data.ID != Foo.ID {
+		t.Errorf("*** health check ID did not match: %v != %v", data.ID, Foo.ID)
+	}
+	if data.Description != Foo.Description {
+		t.Error("*** health check description did not match")
+	}
+	if data.YellowImpact != Foo.YellowImpact {
+		t.Error("*** health check yellow impact did not match")
+	}
+	if data.RedImpact != Foo.RedImpact {
+		t.Error("*** health check red impact did not match")
 	}
 }
 
@@ -198,52 +172,26 @@ func TestHealthCheckResultsAreLogged(t *testing.T) {
 		Dur    uint
 	}
 
-	type LogEvent struct {
-		Name    string `json:"n"`
-		Message string `json:"m"`
-		Data    Data   `json:"d"`
+	logEvent, err := fxapptest.AwaitEvent(buf, fxapp.HealthCheckResultEvent, time.Second)
+	if err != nil {
+		t.Fatalf("*** health check result event was not logged: %v", err)
 	}
-	var logEvent LogEvent
-
-FoundEvent:
-	for i := 0; i < 3; i++ {
-		reader := bufio.NewReader(buf)
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				break
-			}
-			t.Log(line)
-			err = json.Unmarshal([]byte(line), &logEvent)
-			if err != nil {
-				t.Errorf("*** failed to parse log event: %v : %v", err, line)
-				break
-			}
-			if logEvent.Name == "01DF3X60Z7XFYVVXGE9TFFQ7Z1" {
-				break FoundEvent
-			}
-		}
-		time.Sleep(time.Millisecond)
+	t.Log(logEvent)
+	var data Data
+	if err := logEvent.DecodeData(&data); err != nil {
+		t.Fatal(err)
 	}
-
-	switch {
-	case logEvent.Name == "01DF3X60Z7XFYVVXGE9TFFQ7Z1":
-		t.Logf("%#v", logEvent)
-		if logEvent.Data.ID != Foo.ID {
-			t.Errorf("*** health check ID did not match: %v != %v", logEvent.Data.ID, Foo.ID)
-		}
-		if logEvent.Data.Status != uint8(health.Green) {
-			t.Error("*** status did not match")
-		}
-		if logEvent.Data.Start == 0 {
-			t.Error("*** start should be set")
-		}
-		if logEvent.Data.Dur == 0 {
-			t.Error("*** duration should be set")
-		}
-
-	default:
-		t.Error("*** health check result event was not logged")
+	if data.ID != Foo.ID {
+		t.Errorf("*** health check ID did not match: %v != %v", data.ID, Foo.ID)
+	}
+	if data.Status != uint8(health.Green) {
+		t.Error("*** status did not match")
+	}
+	if data.Start == 0 {
+		t.Error("*** start should be set")
+	}
+	if data.Dur == 0 {
+		t.Error("*** duration should be set")
 	}
 
 }
@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"fmt"
+	"github.com/oklog/ulid"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"github.com/prometheus/common/model"
+	"go.uber.org/fx"
+	"gopkg.in/yaml.v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PrometheusRulesEndpoint is used to construct the HTTP endpoint, which serves a prometheus operator PrometheusRule
+// manifest as YAML. The PrometheusRule is generated from the registered health checks - each health check is alerted
+// on via its `HealthCheckMetricID` gauge:
+//	- Yellow alert - fires when the health check is Yellow
+//	- Red alert - fires when the health check is Red
+//
+// The alert annotations carry the health check description and impacts, and the alert severity is mapped from the
+// health check tags - see `PrometheusRuleOpts`.
+const PrometheusRulesEndpoint = "01M51SRZPYDH62QNYBH7JPBKDY"
+
+// default alert severities
+const (
+	DefaultRedAlertSeverity    = "critical"
+	DefaultYellowAlertSeverity = "warning"
+)
+
+// PrometheusRuleOpts is used to configure the PrometheusRule that is generated from the registered health checks
+type PrometheusRuleOpts struct {
+	// Name is the PrometheusRule name - defaults to "app-{app ID}"
+	Name string
+	// Namespace is optional
+	Namespace string
+	// Labels are the PrometheusRule labels, e.g., used by the prometheus operator to select the rules
+	Labels map[string]string
+	// For is how long the health check must be Yellow or Red before the alert fires - defaults to 1 min
+	For time.Duration
+
+	// AlertSeverities are the default alert severities - defaults to `DefaultRedAlertSeverity` and `DefaultYellowAlertSeverity`
+	AlertSeverities
+	// TagAlertSeverities maps health check tags to alert severities. If a health check has more than 1 mapped tag, then
+	// the first mapped tag is used.
+	TagAlertSeverities map[string]AlertSeverities
+}
+
+// AlertSeverities are the alert severities, which are applied as the alert "severity" label
+type AlertSeverities struct {
+	Red, Yellow string
+}
+
+func (opts PrometheusRuleOpts) withDefaults(id ID) PrometheusRuleOpts {
+	if strings.TrimSpace(opts.Name) == "" {
+		opts.Name = fmt.Sprintf("app-%s", strings.ToLower(ulid.ULID(id).String()))
+	}
+	if opts.For == 0 {
+		opts.For = time.Minute
+	}
+	if opts.Red == "" {
+		opts.Red = DefaultRedAlertSeverity
+	}
+	if opts.Yellow == "" {
+		opts.Yellow = DefaultYellowAlertSeverity
+	}
+	return opts
+}
+
+func (opts PrometheusRuleOpts) alertSeverities(check health.Check) AlertSeverities {
+	for _, tag := range check.Tags {
+		if severities, ok := opts.TagAlertSeverities[tag]; ok {
+			if severities.Red == "" {
+				severities.Red = opts.Red
+			}
+			if severities.Yellow == "" {
+				severities.Yellow = opts.Yellow
+			}
+			return severities
+		}
+	}
+	return opts.AlertSeverities
+}
+
+type prometheusRulesHTTPHandlerParams struct {
+	fx.In
+
+	Opts             PrometheusRuleOpts `optional:"true"`
+	ID               ID
+	RegisteredChecks health.RegisteredChecks
+}
+
+func prometheusRulesHTTPHandler(params prometheusRulesHTTPHandlerParams) HTTPHandler {
+	return NewAdminHTTPHandler(fmt.Sprintf("/%s", PrometheusRulesEndpoint), func(writer http.ResponseWriter, request *http.Request) {
+		var checks []health.Check
+		for _, check := range <-params.RegisteredChecks() {
+			checks = append(checks, check.Check)
+		}
+		rule, err := yaml.Marshal(newPrometheusRule(params.ID, checks, params.Opts))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/yaml")
+		writer.Write(rule)
+	})
+}
+
+func newPrometheusRule(id ID, checks []health.Check, opts PrometheusRuleOpts) prometheusRule {
+	opts = opts.withDefaults(id)
+	appID := ulid.ULID(id).String()
+	rules := make([]alertingRule, 0, 2*len(checks))
+	for _, check := range checks {
+		severities := opts.alertSeverities(check)
+		alert := func(status health.Status, severity, impact string) alertingRule {
+			annotations := map[string]string{
+				"summary":     fmt.Sprintf("health check is %s: %s", status, check.ID),
+				"description": check.Description,
+			}
+			if impact != "" {
+				annotations["impact"] = impact
+			}
+			return alertingRule{
+				Alert: fmt.Sprintf("HealthCheck%s_%s", status, check.ID),
+				Expr:  fmt.Sprintf(`%s{%s=%q,h=%q} == %d`, HealthCheckMetricID, AppIDLabel, appID, check.ID, uint8(status)),
+				For:   model.Duration(opts.For).String(),
+				Labels: map[string]string{
+					"severity": severity,
+					"h":        check.ID,
+				},
+				Annotations: annotations,
+			}
+		}
+		rules = append(rules,
+			alert(health.Yellow, severities.Yellow, check.YellowImpact),
+			alert(health.Red, severities.Red, check.RedImpact),
+		)
+	}
+
+	return prometheusRule{
+		APIVersion: "monitoring.coreos.com/v1",
+		Kind:       "PrometheusRule",
+		Metadata: prometheusRuleMetadata{
+			Name:      opts.Name,
+			Namespace: opts.Namespace,
+			Labels:    opts.Labels,
+		},
+		Spec: prometheusRuleSpec{
+			Groups: []ruleGroup{
+				{
+					Name:  fmt.Sprintf("%s.health-checks", appID),
+					Rules: rules,
+				},
+			},
+		},
+	}
+}
+
+type prometheusRule struct {
+	APIVersion string                 `yaml:"apiVersion"`
+	Kind       string                 `yaml:"kind"`
+	Metadata   prometheusRuleMetadata `yaml:"metadata"`
+	Spec       prometheusRuleSpec     `yaml:"spec"`
+}
+
+type prometheusRuleMetadata struct {
+	Name      string            `yaml:"name"`
+	Namespace string            `yaml:"namespace,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+}
+
+type prometheusRuleSpec struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string         `yaml:"name"`
+	Rules []alertingRule `yaml:"rules"`
+}
+
+type alertingRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"fmt"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"gopkg.in/yaml.v2"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPrometheusRulesEndpoint(t *testing.T) {
+	t.Parallel()
+	DatabaseTag := ulids.MustNew().String()
+	Database := health.Check{
+		ID:          ulids.MustNew().String(),
+		Description: "Database",
+		RedImpact:   "app is unavailable",
+		Tags:        []string{DatabaseTag},
+	}
+	Cache := health.Check{
+		ID:           ulids.MustNew().String(),
+		Description:  "Cache",
+		RedImpact:    "app response times are slow",
+		YellowImpact: "cache hit ratio is low",
+	}
+
+	appID := ulids.MustNew()
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(appID), fxapp.ReleaseID(ulids.MustNew())).
+		Provide(func() fxapp.PrometheusRuleOpts {
+			return fxapp.PrometheusRuleOpts{
+				Namespace:          "monitoring",
+				Labels:             map[string]string{"role": "alert-rules"},
+				For:                5 * time.Minute,
+				TagAlertSeverities: map[string]fxapp.AlertSeverities{DatabaseTag: {Red: "page"}},
+			}
+		}).
+		Invoke(func(register health.Register) error {
+			green := func() (health.Status, error) { return health.Green, nil }
+			if err := register(Database, health.CheckerOpts{}, green); err != nil {
+				return err
+			}
+			return register(Cache, health.CheckerOpts{}, green)
+		}))
+
+	resp, err := http.Get(app.URL(fxapp.PrometheusRulesEndpoint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var rule struct {
+		Kind     string
+		Metadata struct {
+			Name, Namespace string
+			Labels          map[string]string
+		}
+		Spec struct {
+			Groups []struct {
+				Rules []struct {
+					Alert, Expr, For string
+					Labels           map[string]string
+					Annotations      map[string]string
+				}
+			}
+		}
+	}
+	if err := yaml.NewDecoder(resp.Body).Decode(&rule); err != nil {
+		t.Fatal(err)
+	}
+	if rule.Kind != "PrometheusRule" || rule.Metadata.Namespace != "monitoring" || rule.Metadata.Labels["role"] != "alert-rules" || rule.Metadata.Name == "" {
+		t.Errorf("*** PrometheusRule metadata did not match: %v", rule.Metadata)
+	}
+	if len(rule.Spec.Groups) != 1 || len(rule.Spec.Groups[0].Rules) != 4 {
+		t.Fatalf("*** a Yellow and Red alert should have been generated per health check: %v", rule.Spec)
+	}
+
+	type alert struct {
+		check    health.Check
+		status   health.Status
+		severity string
+		impact   string
+	}
+	alerts := []alert{
+		{Database, health.Yellow, fxapp.DefaultYellowAlertSeverity, ""},
+		{Database, health.Red, "page", Database.RedImpact},
+		{Cache, health.Yellow, fxapp.DefaultYellowAlertSeverity, Cache.YellowImpact},
+		{Cache, health.Red, fxapp.DefaultRedAlertSeverity, Cache.RedImpact},
+	}
+	for i, expected := range alerts {
+		rule := rule.Spec.Groups[0].Rules[i]
+		expr := fmt.Sprintf(`%s{a=%q,h=%q} == %d`, fxapp.HealthCheckMetricID, appID, expected.check.ID, expected.status)
+		switch {
+		case rule.Expr != expr:
+			t.Errorf("*** alert expr did not match: %q != %q", rule.Expr, expr)
+		case rule.For != "5m":
+			t.Errorf("*** alert for did not match: %v", rule.For)
+		case rule.Labels["severity"] != expected.severity:
+			t.Errorf("*** alert severity did not match: %v != %v", rule.Labels["severity"], expected.severity)
+		case rule.Annotations["description"] != expected.check.Description || rule.Annotations["impact"] != expected.impact:
+			t.Errorf("*** alert annotations did not match: %v", rule.Annotations)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HealthChecksEndpoint is used to construct the health checks HTTP endpoint, which serves the registered health checks
+// along with their latest results as JSON (see `HealthCheckReport`).
+//
+// The health checks can be filtered via the following query params:
+//	- tag - matches checks that have any of the specified tags, e.g., ?tag=01DF...&tag=01DG...
+//	- status - matches checks whose latest status is any of the specified statuses (case insensitive), e.g., ?status=red&status=yellow
+//
+// HTTP 400 is returned if a status query param is invalid.
+const HealthChecksEndpoint = "01M51R2A6GMWEPPY54QFZAG3A8"
+
+// HealthCheckReport is the health checks HTTP endpoint response
+type HealthCheckReport struct {
+	// Health is the app's overall health status, i.e., the overall health is not affected by the query filters
+	Health string              `json:"health"`
+	Checks []HealthCheckStatus `json:"checks"`
+}
+
+// HealthCheckStatus reports a registered health check along with its latest result
+type HealthCheckStatus struct {
+	ID           string   `json:"id"`
+	Description  string   `json:"description"`
+	RedImpact    string   `json:"red_impact"`
+	YellowImpact string   `json:"yellow_impact,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	Timeout      string   `json:"timeout"`
+	RunInterval  string   `json:"run_interval"`
+
+	// latest result - not set if the health check has not yet run
+	Status   string     `json:"status,omitempty"`
+	Err      string     `json:"err,omitempty"`
+	Start    *time.Time `json:"start,omitempty"`
+	Duration string     `json:"duration,omitempty"`
+}
+
+type healthCheckFilter struct {
+	tags     map[string]bool
+	statuses map[string]bool
+}
+
+func newHealthCheckFilter(request *http.Request) (healthCheckFilter, error) {
+	query := request.URL.Query()
+	filter := healthCheckFilter{
+		tags:     make(map[string]bool),
+		statuses: make(map[string]bool),
+	}
+	for _, tag := range query["tag"] {
+		filter.tags[tag] = true
+	}
+	for _, status := range query["status"] {
+		switch strings.ToLower(status) {
+		case "green", "yellow", "red":
+			filter.statuses[strings.ToLower(status)] = true
+		default:
+			return filter, fmt.Errorf("invalid status: %q - valid values are: green, yellow, red", status)
+		}
+	}
+	return filter, nil
+}
+
+func (f healthCheckFilter) matches(check HealthCheckStatus) bool {
+	if len(f.statuses) > 0 && !f.statuses[strings.ToLower(check.Status)] {
+		return false
+	}
+	if len(f.tags) == 0 {
+		return true
+	}
+	for _, tag := range check.Tags {
+		if f.tags[tag] {
+			return true
+		}
+	}
+	return false
+}
+
+func newHealthCheckStatus(check health.RegisteredCheck, result *health.Result) HealthCheckStatus {
+	status := HealthCheckStatus{
+		ID:           check.ID,
+		Description:  check.Description,
+		RedImpact:    check.RedImpact,
+		YellowImpact: check.YellowImpact,
+		Tags:         check.Tags,
+		Timeout:      check.Timeout.String(),
+		RunInterval:  check.RunInterval.String(),
+	}
+	if result != nil {
+		status.Status = result.Status.String()
+		if result.Err != nil {
+			status.Err = result.Err.Error()
+		}
+		start := result.Time
+		status.Start = &start
+		status.Duration = result.Duration.String()
+	}
+	return status
+}
+
+func healthChecksHTTPHandler(registeredChecks health.RegisteredChecks, checkResults health.CheckResults, overallHealth health.OverallHealth) HTTPHandler {
+	return NewAdminHTTPHandler(fmt.Sprintf("/%s", HealthChecksEndpoint), func(writer http.ResponseWriter, request *http.Request) {
+		filter, err := newHealthCheckFilter(request)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		results := make(map[string]health.Result)
+		for _, result := range <-checkResults(nil) {
+			results[result.ID] = result
+		}
+		report := HealthCheckReport{
+			Health: overallHealth().String(),
+			Checks: []HealthCheckStatus{},
+		}
+		for _, check := range <-registeredChecks() {
+			var status HealthCheckStatus
+			if result, ok := results[check.ID]; ok {
+				status = newHealthCheckStatus(check, &result)
+			} else {
+				status = newHealthCheckStatus(check, nil)
+			}
+			if filter.matches(status) {
+				report.Checks = append(report.Checks, status)
+			}
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(report)
+	})
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"encoding/json"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHealthChecksEndpoint(t *testing.T) {
+	t.Parallel()
+	DatabaseTag := ulids.MustNew().String()
+	Database := health.Check{
+		ID:          ulids.MustNew().String(),
+		Description: "Database",
+		RedImpact:   "app is unavailable",
+		Tags:        []string{DatabaseTag},
+	}
+	Cache := health.Check{
+		ID:           ulids.MustNew().String(),
+		Description:  "Cache",
+		RedImpact:    "app response times are slow",
+		YellowImpact: "cache hit ratio is low",
+		Tags:         []string{ulids.MustNew().String()},
+	}
+
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Invoke(func(register health.Register) error {
+			green := func() (health.Status, error) { return health.Green, nil }
+			if err := register(Database, health.CheckerOpts{Timeout: time.Second}, green); err != nil {
+				return err
+			}
+			return register(Cache, health.CheckerOpts{}, green)
+		}))
+
+	getReport := func(t *testing.T, query string) fxapp.HealthCheckReport {
+		resp, err := http.Get(app.URL(fxapp.HealthChecksEndpoint) + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("*** response status did not match: %v", resp.StatusCode)
+		}
+		var report fxapp.HealthCheckReport
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	t.Run("all checks", func(t *testing.T) {
+		report := getReport(t, "")
+		if report.Health != health.Green.String() || len(report.Checks) != 2 {
+			t.Fatalf("*** health report did not match: %v", report)
+		}
+		for _, check := range report.Checks {
+			switch {
+			case check.ID != Database.ID && check.ID != Cache.ID:
+				t.Errorf("*** unexpected health check: %v", check)
+			case check.Status != health.Green.String() || check.Start == nil || check.Duration == "":
+				t.Errorf("*** health check result was not reported: %v", check)
+			case check.Timeout == "" || check.RunInterval == "":
+				t.Errorf("*** health check opts were not reported: %v", check)
+			}
+			if check.ID == Database.ID && (check.Description != Database.Description || check.RedImpact != Database.RedImpact || check.Timeout != "1s") {
+				t.Errorf("*** health check did not match: %v", check)
+			}
+			if check.ID == Cache.ID && check.YellowImpact != Cache.YellowImpact {
+				t.Errorf("*** health check did not match: %v", check)
+			}
+		}
+	})
+
+	t.Run("filter by tag", func(t *testing.T) {
+		report := getReport(t, "?tag="+DatabaseTag)
+		if len(report.Checks) != 1 || report.Checks[0].ID != Database.ID {
+			t.Errorf("*** health checks should have been filtered by tag: %v", report)
+		}
+	})
+
+	t.Run("filter by status", func(t *testing.T) {
+		if report := getReport(t, "?status=red&status=yellow"); len(report.Checks) != 0 || report.Health != health.Green.String() {
+			t.Errorf("*** health checks should have been filtered by status: %v", report)
+		}
+		if report := getReport(t, "?status=GREEN"); len(report.Checks) != 2 {
+			t.Errorf("*** health checks should have been filtered by status: %v", report)
+		}
+	})
+
+	t.Run("invalid status", func(t *testing.T) {
+		resp, err := http.Get(app.URL(fxapp.HealthChecksEndpoint) + "?status=blue")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("*** response status did not match: %v", resp.StatusCode)
+		}
+	})
+}
@@ -18,6 +18,8 @@ package fxapp
 
 import (
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/oysterpack/andiamo/pkg/eventlog"
@@ -26,6 +28,7 @@ import (
 	"log"
 	"net/http"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -38,7 +41,7 @@ type HTTPHandler struct {
 	HTTPEndpoint `group:"HTTPHandler"`
 }
 
-// NewHTTPHandler constructs a new HTTPHandler
+// NewHTTPHandler constructs a new HTTPHandler for an app endpoint
 func NewHTTPHandler(path string, handler func(http.ResponseWriter, *http.Request)) HTTPHandler {
 	return HTTPHandler{
 		HTTPEndpoint: HTTPEndpoint{
@@ -48,12 +51,79 @@ func NewHTTPHandler(path string, handler func(http.ResponseWriter, *http.Request
 	}
 }
 
-// HTTPEndpoint maps an HTTP handler to an HTTP path
+// NewAdminHTTPHandler constructs a new HTTPHandler for an admin endpoint, e.g., probes, metrics, health checks.
+//
+// Admin endpoints are served by the admin HTTP server, if one is provided - see `AdminHTTPServer`.
+func NewAdminHTTPHandler(path string, handler func(http.ResponseWriter, *http.Request)) HTTPHandler {
+	httpHandler := NewHTTPHandler(path, handler)
+	httpHandler.Admin = true
+	return httpHandler
+}
+
+// AllowMethods restricts the HTTP methods that the endpoint handles. Requests using any other methods are rejected
+// with HTTP 405.
+func (h HTTPHandler) AllowMethods(methods ...string) HTTPHandler {
+	h.Methods = append([]string(nil), methods...)
+	return h
+}
+
+// HTTPEndpoint maps an HTTP handler to an HTTP path pattern and methods.
+//
+// The path is a pattern, which may declare path parameters, e.g., "/users/{id}" - see `PathParam()`. If the path ends
+// with '/', then the endpoint handles the subtree rooted at the path, which is consistent with http.ServeMux.
 type HTTPEndpoint struct {
 	Path    string
 	Handler func(http.ResponseWriter, *http.Request)
+	// Admin is used to tag admin endpoints. By default, endpoints are app endpoints.
+	Admin bool
+	// Methods are the allowed HTTP methods. If not specified, then all methods are allowed. If GET is allowed, then
+	// HEAD is also allowed.
+	Methods []string
+}
+
+// String returns the endpoint path prefixed with its methods, e.g., "GET,POST /users/{id}"
+func (e HTTPEndpoint) String() string {
+	if len(e.Methods) == 0 {
+		return e.Path
+	}
+	return fmt.Sprintf("%s %s", strings.Join(e.Methods, ","), e.Path)
 }
 
+// overlaps returns true if the endpoints handle any of the same HTTP methods
+func (e HTTPEndpoint) overlaps(other HTTPEndpoint) bool {
+	if len(e.Methods) == 0 || len(other.Methods) == 0 {
+		return true
+	}
+	for _, method := range e.Methods {
+		for _, otherMethod := range other.Methods {
+			if strings.EqualFold(method, otherMethod) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AdminHTTPServer is the name used to provide the admin *http.Server, e.g.,
+//
+//	builder.Provide(fx.Annotated{
+//		Name:   fxapp.AdminHTTPServer,
+//		Target: func() *http.Server { return &http.Server{Addr: ":8009"} },
+//	})
+//
+// If an admin HTTP server is provided, then admin endpoints are served by the admin HTTP server, and app endpoints are
+// served by the app HTTP server. This enables network policies to expose the app HTTP server port without exposing the
+// admin endpoints. The app HTTP server is only run if there are app endpoints.
+//
+// If an admin HTTP server is not provided, then all endpoints are served by the app HTTP server.
+const AdminHTTPServer = "admin"
+
+// HTTP server names, which are reported by the `HTTPServerStarting` event
+const (
+	appHTTPServerName   = "app"
+	adminHTTPServerName = AdminHTTPServer
+)
+
 // httpServerOpts is used by the app to configure and run an HTTP server only if HTTPEndpoint(s) are discovered, i.e.,
 // registered with the app via dependency injection.
 //
@@ -62,46 +132,63 @@ type HTTPEndpoint struct {
 // 	- Addr:              ":8008",
 //	- ReadHeaderTimeout: time.Second,
 //	- MaxHeaderBytes:    1024,
+//
+// The admin http.Server is optional - see `AdminHTTPServer`.
 type httpServerOpts struct {
 	fx.In
 
-	Server *http.Server `optional:"true"`
+	Server      *http.Server `optional:"true"`
+	AdminServer *http.Server `name:"admin" optional:"true"`
+
+	Endpoints  []HTTPEndpoint           `group:"HTTPHandler"`
+	Middleware []HTTPEndpointMiddleware `group:"HTTPMiddleware"`
 
-	Endpoints []HTTPEndpoint `group:"HTTPHandler"`
+	// TLS is only available if TLS is enabled - see `Builder.EnableTLS()`
+	TLS *tlsService `optional:"true"`
 }
 
 // validate runs the following checks:
-//	- endpoint paths are unique
+//	- endpoint paths are valid patterns
+//	- endpoints are unique, i.e., endpoints with the same path pattern must not allow the same methods
+//	- methods are not blank
 //	- handler funcs are not nil
+//	- the admin HTTP server is not the app HTTP server
+//	- an admin HTTP server is provided if mutual TLS is enabled
+//	- middleware names are unique, and wrap funcs are not nil
 func (opts httpServerOpts) validate() error {
-	paths := make(map[string]bool, len(opts.Endpoints))
+	patterns := make(map[string][]HTTPEndpoint, len(opts.Endpoints))
 	for _, endpoint := range opts.Endpoints {
-		if paths[endpoint.Path] {
-			return fmt.Errorf("duplicate HTTP endpoint path: %v", endpoint.Path)
+		pattern, err := parseHTTPPathPattern(endpoint.Path)
+		if err != nil {
+			return err
+		}
+		for _, other := range patterns[pattern.key()] {
+			if endpoint.overlaps(other) {
+				return fmt.Errorf("duplicate HTTP endpoint path: %v : %v", endpoint, other)
+			}
+		}
+		for _, method := range endpoint.Methods {
+			if strings.TrimSpace(method) == "" {
+				return fmt.Errorf("HTTP endpoint method is blank for: %v", endpoint.Path)
+			}
 		}
 		if endpoint.Handler == nil {
 			return fmt.Errorf("http handler func is nil for: %v", endpoint.Path)
 		}
-		paths[endpoint.Path] = true
+		patterns[pattern.key()] = append(patterns[pattern.key()], endpoint)
 	}
-
-	return nil
-}
-
-func (opts httpServerOpts) httpServerInfo() httpServerInfo {
-	endpoints := make([]string, 0, len(opts.Endpoints))
-	for _, endpoint := range opts.Endpoints {
-		endpoints = append(endpoints, endpoint.Path)
+	if opts.AdminServer != nil && opts.AdminServer == opts.Server {
+		return errors.New("the admin HTTP server must not be the same as the app HTTP server")
 	}
-	sort.Strings(endpoints)
-
-	return httpServerInfo{
-		addr:      opts.Server.Addr,
-		endpoints: endpoints,
+	if opts.TLS != nil && opts.TLS.mutual() && opts.AdminServer == nil {
+		// Kubernetes probes and Prometheus scrapes do not present client certs
+		return errors.New("mutual TLS requires an admin HTTP server, which serves the admin endpoints without mutual TLS")
 	}
+
+	return httpMiddlewares(opts.Middleware).validate()
 }
 
-func runHTTPServer(opts httpServerOpts, logger *zerolog.Logger, lc fx.Lifecycle, readiness ReadinessWaitGroup) error {
+func runHTTPServer(opts httpServerOpts, logger *zerolog.Logger, lc fx.Lifecycle, readiness ReadinessWaitGroup, drain *drainer) error {
 	if len(opts.Endpoints) == 0 {
 		// If there are no HTTP endpoints, then we don't need to run the HTTP server, but ...
 		//
@@ -119,29 +206,71 @@ func runHTTPServer(opts httpServerOpts, logger *zerolog.Logger, lc fx.Lifecycle,
 		return err
 	}
 
-	readiness.Inc()
+	if opts.Server == nil {
+		opts.Server = newHTTPServerWithDefaultOpts()
+	}
+	middleware := sortHTTPMiddlewares(opts.Middleware)
+	var tlsConfig *tls.Config
+	if opts.TLS != nil {
+		tlsConfig = opts.TLS.tlsConfig()
+	}
+	if opts.AdminServer == nil {
+		return startHTTPServer(appHTTPServerName, opts.Server, tlsConfig, opts.Endpoints, middleware, logger, lc, readiness, drain)
+	}
 
-	serveMux := http.NewServeMux()
+	var appEndpoints, adminEndpoints []HTTPEndpoint
 	for _, endpoint := range opts.Endpoints {
-		serveMux.HandleFunc(endpoint.Path, endpoint.Handler)
+		if endpoint.Admin {
+			adminEndpoints = append(adminEndpoints, endpoint)
+		} else {
+			appEndpoints = append(appEndpoints, endpoint)
+		}
+	}
+	if err := startHTTPServer(adminHTTPServerName, opts.AdminServer, nil, adminEndpoints, middleware, logger, lc, readiness, drain); err != nil {
+		return err
+	}
+	if len(appEndpoints) > 0 {
+		return startHTTPServer(appHTTPServerName, opts.Server, tlsConfig, appEndpoints, middleware, logger, lc, readiness, drain)
 	}
 
-	if opts.Server == nil {
-		opts.Server = newHTTPServerWithDefaultOpts()
+	return nil
+}
+
+// startHTTPServer registers lifecycle hooks to start and stop the HTTP server. Each HTTP server is accounted for by
+// the ReadinessWaitGroup. If the TLS config is not nil, then the HTTP server is run using TLS.
+//
+// The endpoint handlers are wrapped with the middleware that applies to them, and requests are routed by method and
+// path pattern - see `httpRouter`.
+func startHTTPServer(name string, server *http.Server, tlsConfig *tls.Config, endpoints []HTTPEndpoint, middleware httpMiddlewares, logger *zerolog.Logger, lc fx.Lifecycle, readiness ReadinessWaitGroup, drain *drainer) error {
+	router, err := newHTTPRouter(endpoints, middleware)
+	if err != nil {
+		return err
+	}
+	readiness.Inc()
+
+	server.Handler = drain.track(router)
+	if tlsConfig != nil {
+		server.TLSConfig = tlsConfig
 	}
-	opts.Server.Handler = serveMux
 
 	logHTTPServerErr := httpServerErrorLog(eventlog.NewLogger(HTTPServerError, logger, zerolog.ErrorLevel))
+	info := newHTTPServerInfo(name, server, tlsConfig != nil, endpoints)
 	lc.Append(fx.Hook{
 		OnStart: func(context.Context) error {
-			eventlog.NewLogger(HTTPServerStarting, logger, zerolog.InfoLevel)(opts.httpServerInfo(), "starting HTTP server")
+			eventlog.NewLogger(HTTPServerStarting, logger, zerolog.InfoLevel)(info, "starting HTTP server")
 			// wait for the HTTP server go routine to start running before returning
 			var wg sync.WaitGroup
 			wg.Add(1)
 			go func() {
 				wg.Done()
 				readiness.Done()
-				err := opts.Server.ListenAndServe()
+				var err error
+				if tlsConfig != nil {
+					// the cert is provided via the TLS config
+					err = server.ListenAndServeTLS("", "")
+				} else {
+					err = server.ListenAndServe()
+				}
 				if err != http.ErrServerClosed {
 					logHTTPServerErr(httpListenAndServerError{err}, "HTTP server has exited with an error")
 				}
@@ -150,10 +279,9 @@ func runHTTPServer(opts httpServerOpts, logger *zerolog.Logger, lc fx.Lifecycle,
 			return nil
 		},
 		OnStop: func(ctx context.Context) error {
-			return opts.Server.Shutdown(ctx)
+			return server.Shutdown(ctx)
 		},
 	})
-
 	return nil
 }
 
@@ -174,9 +302,13 @@ const (
 	//	}
 	HTTPServerError = "01DEDRH8A9X3SCSJRCJ4PM7749"
 
+	// HTTPServerStarting is logged for each HTTP server that is starting.
+	//
 	// 	type Data struct {
+	//		Server    string // app or admin
 	//		Addr      string
-	//		Endpoints []string
+	//		TLS       bool
+	//		Endpoints []string // sorted by path, and prefixed with the allowed methods, e.g., "GET,POST /foo"
 	//	}
 	HTTPServerStarting = "01DEFM9FFSH58ZGNPSR7Z4C3G2"
 )
@@ -202,12 +334,51 @@ func (err httpListenAndServerError) MarshalZerologObject(e *zerolog.Event) {
 }
 
 type httpServerInfo struct {
+	server    string
 	addr      string
+	tls       bool
 	endpoints []string
 }
 
+func newHTTPServerInfo(name string, server *http.Server, useTLS bool, httpEndpoints []HTTPEndpoint) httpServerInfo {
+	endpoints := make([]string, 0, len(httpEndpoints))
+	sorted := append([]HTTPEndpoint(nil), httpEndpoints...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+	for _, endpoint := range sorted {
+		endpoints = append(endpoints, endpoint.String())
+	}
+
+	return httpServerInfo{
+		server:    name,
+		addr:      server.Addr,
+		tls:       useTLS,
+		endpoints: endpoints,
+	}
+}
+
 func (info httpServerInfo) MarshalZerologObject(e *zerolog.Event) {
 	e.
+		Str("server", info.server).
 		Str("addr", info.addr).
+		Bool("tls", info.tls).
 		Strs("endpoints", info.endpoints)
 }
+
+// tokenGated gates access to the handler behind the token. Requests must specify the token via the HTTP Authorization
+// header using the bearer scheme, i.e., "Authorization: Bearer <token>" - otherwise HTTP 403 is returned.
+//
+// If the token is not set, then all requests are forbidden.
+func tokenGated(token Secret, handler http.HandlerFunc) http.HandlerFunc {
+	const scheme = "Bearer "
+	return func(writer http.ResponseWriter, request *http.Request) {
+		authorization := request.Header.Get("Authorization")
+		if token.IsZero() ||
+			len(authorization) < len(scheme) ||
+			!strings.EqualFold(authorization[:len(scheme)], scheme) ||
+			subtle.ConstantTimeCompare([]byte(authorization[len(scheme):]), token.Bytes()) != 1 {
+			http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		handler(writer, request)
+	}
+}
@@ -0,0 +1,336 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/oklog/ulid"
+	"github.com/oysterpack/andiamo/pkg/eventlog"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTP client defaults
+const (
+	DefaultHTTPClientTimeout      = 10 * time.Second
+	DefaultHTTPClientRetryMax     = 4
+	DefaultHTTPClientRetryWaitMin = 100 * time.Millisecond
+	DefaultHTTPClientRetryWaitMax = 5 * time.Second
+)
+
+// HTTPClients returns the named HTTP client, which is created on first use. Subsequent calls using the same name return
+// the same client. An error is returned if the name is blank, or if the name is reused with different options.
+//
+// The clients are instrumented:
+//	- retries are logged - see `HTTPClientRetryEvent`
+//	- request metrics are labeled with the client name and upstream base URL - see `HTTPClientRequestsMetricID`,
+//	  `HTTPClientRequestDurationMetricID`, and `HTTPClientRetriesMetricID`
+//	- the request ID is propagated via the `RequestIDHeader`, if the request context has a request ID - see `RequestID()`,
+//	  e.g., the HTTP request context that is passed to HTTP endpoint handlers
+//	- an upstream health check is registered per base URL, if enabled - see `HTTPClientOpts.HealthCheck`
+type HTTPClients func(name string, opts HTTPClientOpts) (*retryablehttp.Client, error)
+
+// HTTPClientOpts is used to configure a named HTTP client. Zero values imply using the defaults.
+type HTTPClientOpts struct {
+	// BaseURL is the upstream base URL, e.g., https://api.example.com
+	BaseURL string
+	// Timeout is applied per request attempt
+	Timeout time.Duration
+	// RetryMax is the max number of retries. If negative, then requests are not retried.
+	RetryMax     int
+	RetryWaitMin time.Duration
+	RetryWaitMax time.Duration
+
+	// HealthCheck is optional. If specified, then an upstream health check is registered for the base URL.
+	HealthCheck *HTTPClientHealthCheckOpts
+}
+
+func (opts HTTPClientOpts) withDefaults() HTTPClientOpts {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultHTTPClientTimeout
+	}
+	switch {
+	case opts.RetryMax == 0:
+		opts.RetryMax = DefaultHTTPClientRetryMax
+	case opts.RetryMax < 0:
+		opts.RetryMax = 0
+	}
+	if opts.RetryWaitMin <= 0 {
+		opts.RetryWaitMin = DefaultHTTPClientRetryWaitMin
+	}
+	if opts.RetryWaitMax <= 0 {
+		opts.RetryWaitMax = DefaultHTTPClientRetryWaitMax
+	}
+	return opts
+}
+
+func (opts HTTPClientOpts) validate() error {
+	if opts.HealthCheck != nil && strings.TrimSpace(opts.BaseURL) == "" {
+		return errors.New("HTTP client base URL is required for the health check")
+	}
+	if opts = opts.withDefaults(); opts.RetryWaitMin > opts.RetryWaitMax {
+		return fmt.Errorf("HTTP client RetryWaitMin must not be greater than RetryWaitMax: %v > %v", opts.RetryWaitMin, opts.RetryWaitMax)
+	}
+	return nil
+}
+
+// HTTPClientHealthCheckOpts is used to configure the upstream health check.
+//
+// The health check sends a GET request to the base URL joined with the path. The health check is Green if the response
+// status is 2xx, and Yellow otherwise. Upstream failures are reported as Yellow, and not Red, because restarting the
+// app will not fix the upstream, i.e., the default liveness policy fails liveness when any health check is Red - see
+// `AnyRedLivenessPolicy()`.
+//
+// The health check request times out before the health check timeout is reached, which means a slow upstream is also
+// reported as Yellow.
+type HTTPClientHealthCheckOpts struct {
+	// Path is appended to the base URL, e.g., "/health"
+	Path string
+	health.CheckerOpts
+}
+
+// HTTPClientHealthCheckTag is used to tag the upstream health checks
+const HTTPClientHealthCheckTag = "01M51S6X682QDSFETFVVHQ0G43"
+
+// HTTPClientHealthCheckID returns the upstream health check ID for the base URL. The ID is derived from the base URL,
+// i.e., the ID is stable across app restarts.
+func HTTPClientHealthCheckID(baseURL string) string {
+	var id ulid.ULID
+	sum := sha256.Sum256([]byte(baseURL))
+	copy(id[:], sum[:])
+	return id.String()
+}
+
+// HTTP client related events
+const (
+	// HTTPClientRetryEvent is logged when an HTTP client request is retried.
+	//
+	// 	type Data struct {
+	//		Client  string
+	//		Method  string
+	//		URL     string
+	//		Attempt int // retry attempt, i.e., starts at 1
+	//	}
+	HTTPClientRetryEvent = "01M51S6X6859B7H21MBF5M2SAK"
+)
+
+// HTTP client metrics
+const (
+	// HTTPClientRequestsMetricID counts HTTP client request attempts - labels: client, upstream, code
+	//
+	// If the request attempt failed without a response, then the code is "error".
+	HTTPClientRequestsMetricID = "U01M51S6X685XG28ZS08C39C8S9"
+	// HTTPClientRequestDurationMetricID is the HTTP client request attempt latency histogram in seconds - labels: client, upstream
+	HTTPClientRequestDurationMetricID = "U01M51S6X68P5BQX2KEKGM20NP8"
+	// HTTPClientRetriesMetricID counts HTTP client request retries - labels: client, upstream
+	HTTPClientRetriesMetricID = "U01M51S6X68E4E2B8N7VEZKD0BZ"
+)
+
+type httpClientMetrics struct {
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	retries  *prometheus.CounterVec
+}
+
+func newHTTPClientMetrics(registerer prometheus.Registerer) (*httpClientMetrics, error) {
+	metrics := &httpClientMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: HTTPClientRequestsMetricID,
+			Help: "HTTP client request attempt count",
+		}, []string{"client", "upstream", "code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    HTTPClientRequestDurationMetricID,
+			Help:    "HTTP client request attempt latency in seconds",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"client", "upstream"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: HTTPClientRetriesMetricID,
+			Help: "HTTP client request retry count",
+		}, []string{"client", "upstream"}),
+	}
+	for _, collector := range []prometheus.Collector{metrics.requests, metrics.latency, metrics.retries} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return metrics, nil
+}
+
+type httpClientFactory struct {
+	logger   *zerolog.Logger
+	metrics  *httpClientMetrics
+	register health.Register
+
+	sync.Mutex
+	clients      map[string]*httpClient
+	healthChecks map[string]bool // base URL -> registered
+}
+
+type httpClient struct {
+	opts   HTTPClientOpts
+	client *retryablehttp.Client
+}
+
+func provideHTTPClients(logger *zerolog.Logger, registerer prometheus.Registerer, register health.Register) (HTTPClients, error) {
+	metrics, err := newHTTPClientMetrics(registerer)
+	if err != nil {
+		return nil, err
+	}
+	factory := &httpClientFactory{
+		logger:       logger,
+		metrics:      metrics,
+		register:     register,
+		clients:      make(map[string]*httpClient),
+		healthChecks: make(map[string]bool),
+	}
+	return factory.client, nil
+}
+
+func (f *httpClientFactory) client(name string, opts HTTPClientOpts) (*retryablehttp.Client, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("HTTP client name must not be blank")
+	}
+	if err := opts.validate(); err != nil {
+		return nil, fmt.Errorf("invalid HTTP client opts: %v : %v", name, err)
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	if client, ok := f.clients[name]; ok {
+		if !reflect.DeepEqual(client.opts, opts) {
+			return nil, fmt.Errorf("HTTP client was already created with different options: %v", name)
+		}
+		return client.client, nil
+	}
+
+	if opts.HealthCheck != nil && !f.healthChecks[opts.BaseURL] {
+		if err := f.registerHealthCheck(name, opts.BaseURL, *opts.HealthCheck); err != nil {
+			return nil, err
+		}
+		f.healthChecks[opts.BaseURL] = true
+	}
+	client := f.newClient(name, opts.withDefaults())
+	f.clients[name] = &httpClient{opts, client}
+	return client, nil
+}
+
+func (f *httpClientFactory) newClient(name string, opts HTTPClientOpts) *retryablehttp.Client {
+	httpClient := cleanhttp.DefaultPooledClient()
+	httpClient.Timeout = opts.Timeout
+	httpClient.Transport = &httpClientTransport{
+		next:     httpClient.Transport,
+		client:   name,
+		upstream: opts.BaseURL,
+		metrics:  f.metrics,
+	}
+
+	logRetry := eventlog.NewLogger(HTTPClientRetryEvent, f.logger, zerolog.WarnLevel)
+	return &retryablehttp.Client{
+		HTTPClient:   httpClient,
+		RetryWaitMin: opts.RetryWaitMin,
+		RetryWaitMax: opts.RetryWaitMax,
+		RetryMax:     opts.RetryMax,
+		RequestLogHook: func(_ retryablehttp.Logger, request *http.Request, attempt int) {
+			if attempt == 0 {
+				return
+			}
+			f.metrics.retries.WithLabelValues(name, opts.BaseURL).Inc()
+			logRetry(httpClientRetry{name, request, attempt}, "HTTP client request retry")
+		},
+		CheckRetry: retryablehttp.DefaultRetryPolicy,
+		Backoff:    retryablehttp.DefaultBackoff,
+	}
+}
+
+func (f *httpClientFactory) registerHealthCheck(name, baseURL string, opts HTTPClientHealthCheckOpts) error {
+	url := strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimPrefix(opts.Path, "/")
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = health.DefaultTimeout
+	}
+	client := cleanhttp.DefaultPooledClient()
+	// leave headroom to report the request failure before the health check times out, which is reported as Red
+	client.Timeout = timeout - timeout/10
+	return f.register(health.Check{
+		ID:           HTTPClientHealthCheckID(baseURL),
+		Description:  fmt.Sprintf("HTTP upstream health check: %s", url),
+		RedImpact:    fmt.Sprintf("HTTP upstream health check timed out: %s", name),
+		YellowImpact: fmt.Sprintf("HTTP upstream is unavailable, which will cause HTTP client requests to fail: %s", name),
+		Tags:         []string{HTTPClientHealthCheckTag},
+	}, opts.CheckerOpts, func() (health.Status, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return health.Yellow, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return health.Yellow, fmt.Errorf("HTTP upstream health check failed: %s : %s", url, resp.Status)
+		}
+		return health.Green, nil
+	})
+}
+
+// httpClientTransport collects request metrics, and propagates the request ID
+type httpClientTransport struct {
+	next     http.RoundTripper
+	client   string
+	upstream string
+	metrics  *httpClientMetrics
+}
+
+func (t *httpClientTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if id, ok := RequestID(request.Context()); ok && request.Header.Get(RequestIDHeader) == "" {
+		// round trippers must not modify the request
+		request = request.Clone(request.Context())
+		request.Header.Set(RequestIDHeader, id.String())
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(request)
+	t.metrics.latency.WithLabelValues(t.client, t.upstream).Observe(time.Since(start).Seconds())
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	t.metrics.requests.WithLabelValues(t.client, t.upstream, code).Inc()
+	return resp, err
+}
+
+type httpClientRetry struct {
+	client  string
+	request *http.Request
+	attempt int
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (event httpClientRetry) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("client", event.client)
+	e.Str("method", event.request.Method)
+	e.Str("url", event.request.URL.String())
+	e.Int("attempt", event.attempt)
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPClients(t *testing.T) {
+	t.Parallel()
+	// the upstream fails the first 2 requests, and then echoes the request ID header
+	var requestCount int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/health" {
+			return
+		}
+		if atomic.AddInt32(&requestCount, 1) <= 2 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writer.Write([]byte(request.Header.Get(fxapp.RequestIDHeader)))
+	}))
+	defer upstream.Close()
+
+	opts := fxapp.HTTPClientOpts{
+		BaseURL:      upstream.URL,
+		RetryWaitMin: time.Millisecond,
+		RetryWaitMax: 10 * time.Millisecond,
+		HealthCheck:  &fxapp.HTTPClientHealthCheckOpts{Path: "/health"},
+	}
+	var clients fxapp.HTTPClients
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Provide(func(clients fxapp.HTTPClients) (fxapp.HTTPHandler, error) {
+			client, err := clients("upstream", opts)
+			if err != nil {
+				return fxapp.HTTPHandler{}, err
+			}
+			return fxapp.NewHTTPHandler("/proxy", func(writer http.ResponseWriter, request *http.Request) {
+				upstreamRequest, err := retryablehttp.NewRequest(http.MethodGet, upstream.URL+"/foo", nil)
+				if err != nil {
+					http.Error(writer, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				resp, err := client.Do(upstreamRequest.WithContext(request.Context()))
+				if err != nil {
+					http.Error(writer, err.Error(), http.StatusBadGateway)
+					return
+				}
+				defer resp.Body.Close()
+				io.Copy(writer, resp.Body)
+			}), nil
+		}).
+		Populate(&clients).
+		Invoke(func() {}))
+
+	t.Run("request is retried and the request ID is propagated", func(t *testing.T) {
+		resp, err := http.Get(app.URL("/proxy"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != resp.Header.Get(fxapp.RequestIDHeader) {
+			t.Errorf("*** request ID was not propagated: %v : %q != %q", resp.StatusCode, body, resp.Header.Get(fxapp.RequestIDHeader))
+		}
+
+		events := fxapptest.EventsNamed(app.Log, fxapp.HTTPClientRetryEvent)
+		if len(events) != 2 {
+			t.Fatalf("*** retries should have been logged: %v", events)
+		}
+		var data struct {
+			Client  string
+			Attempt int
+		}
+		if err := events[1].DecodeData(&data); err != nil {
+			t.Fatal(err)
+		}
+		if data.Client != "upstream" || data.Attempt != 2 {
+			t.Errorf("*** retry event did not match: %v", events[1])
+		}
+	})
+
+	t.Run("client metrics", func(t *testing.T) {
+		resp, err := http.Get(app.URL(fxapp.MetricsEndpoint))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		metrics, _ := ioutil.ReadAll(resp.Body)
+		for _, metric := range []string{fxapp.HTTPClientRequestsMetricID, fxapp.HTTPClientRequestDurationMetricID, fxapp.HTTPClientRetriesMetricID} {
+			if !strings.Contains(string(metrics), metric) {
+				t.Errorf("*** HTTP client metric was not found: %v", metric)
+			}
+		}
+		if !strings.Contains(string(metrics), `client="upstream",code="503"`) {
+			t.Errorf("*** HTTP client requests should be labeled by client and status code: %s", metrics)
+		}
+	})
+
+	t.Run("upstream health check is registered", func(t *testing.T) {
+		checkResult := func() (health.Status, bool) {
+			for _, entry := range fxapptest.EventsNamed(app.Log, fxapp.HealthCheckResultEvent) {
+				var data struct {
+					ID     string
+					Status health.Status
+				}
+				if err := entry.DecodeData(&data); err != nil {
+					t.Fatal(err)
+				}
+				if data.ID == fxapp.HTTPClientHealthCheckID(upstream.URL) {
+					return data.Status, true
+				}
+			}
+			return health.Red, false
+		}
+		status, ok := checkResult()
+		for i := 0; i < 100 && !ok; i++ {
+			time.Sleep(10 * time.Millisecond)
+			status, ok = checkResult()
+		}
+		switch {
+		case !ok:
+			t.Errorf("*** upstream health check was not registered: %v", app.Log)
+		case status != health.Green:
+			t.Errorf("*** upstream health check status did not match: %v", status)
+		}
+	})
+
+	t.Run("named clients are cached", func(t *testing.T) {
+		client, err := clients("upstream", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other, _ := clients("upstream", opts); other != client {
+			t.Error("*** the same client should have been returned")
+		}
+		if _, err := clients("upstream", fxapp.HTTPClientOpts{BaseURL: upstream.URL}); err == nil {
+			t.Error("*** client name should not be reused with different options")
+		}
+		if _, err := clients(" ", opts); err == nil {
+			t.Error("*** client name should not be blank")
+		}
+	})
+}
+
+func TestHTTPClients_UpstreamHealthCheckFailure(t *testing.T) {
+	t.Parallel()
+	unavailable := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer slow.Close()
+
+	// the upstream health checks are registered after the app has started because the app only starts if all health
+	// checks are Green
+	var clients fxapp.HTTPClients
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Invoke(func() {}).
+		Populate(&clients))
+	for name, baseURL := range map[string]string{"unavailable": unavailable.URL, "slow": slow.URL} {
+		_, err := clients(name, fxapp.HTTPClientOpts{
+			BaseURL: baseURL,
+			HealthCheck: &fxapp.HTTPClientHealthCheckOpts{
+				Path:        "/health",
+				CheckerOpts: health.CheckerOpts{Timeout: 200 * time.Millisecond},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkResults := func() map[string]health.Status {
+		results := make(map[string]health.Status)
+		for _, entry := range fxapptest.EventsNamed(app.Log, fxapp.HealthCheckResultEvent) {
+			var data struct {
+				ID     string
+				Status health.Status
+			}
+			if err := entry.DecodeData(&data); err != nil {
+				t.Fatal(err)
+			}
+			results[data.ID] = data.Status
+		}
+		return results
+	}
+	results := checkResults()
+	for i := 0; i < 100 && len(results) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		results = checkResults()
+	}
+	for _, baseURL := range []string{unavailable.URL, slow.URL} {
+		status, ok := results[fxapp.HTTPClientHealthCheckID(baseURL)]
+		switch {
+		case !ok:
+			t.Errorf("*** upstream health check result was not logged: %v", baseURL)
+		case status != health.Yellow:
+			t.Errorf("*** upstream health check should be Yellow: %v : %v", baseURL, status)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/oysterpack/andiamo/pkg/eventlog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/xid"
+	"github.com/rs/zerolog"
+	"go.uber.org/fx"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPMiddleware is used to group HTTPEndpointMiddleware(s) together.
+// The HTTPEndpointMiddleware(s) are automatically applied to the HTTP endpoints that are registered with the app's HTTP
+// server(s).
+type HTTPMiddleware struct {
+	fx.Out
+
+	HTTPEndpointMiddleware `group:"HTTPMiddleware"`
+}
+
+// NewHTTPMiddleware constructs a new HTTPMiddleware. If no paths are specified, then the middleware is applied to all
+// HTTP endpoints.
+func NewHTTPMiddleware(name string, order int, wrap func(endpoint HTTPEndpoint, next http.Handler) http.Handler, paths ...string) HTTPMiddleware {
+	return HTTPMiddleware{
+		HTTPEndpointMiddleware: HTTPEndpointMiddleware{
+			Name:  name,
+			Order: order,
+			Paths: paths,
+			Wrap:  wrap,
+		},
+	}
+}
+
+// HTTPEndpointMiddleware wraps HTTP endpoint handlers.
+//
+// Middleware is applied in ascending order, i.e., the middleware with the lowest order is the outermost handler and
+// runs first. The built-in middleware orders are:
+//	- HTTPRequestIDMiddlewareOrder
+//	- HTTPPanicRecoveryMiddlewareOrder
+//	- HTTPRequestEventMiddlewareOrder
+//	- HTTPRequestMetricsMiddlewareOrder
+//
+// Panics are only recovered for middleware that is ordered after HTTPPanicRecoveryMiddlewareOrder.
+type HTTPEndpointMiddleware struct {
+	// Name must be unique
+	Name  string
+	Order int
+	// Paths are the HTTP endpoint paths that the middleware applies to. If blank, then the middleware applies to all
+	// HTTP endpoints.
+	Paths []string
+	Wrap  func(endpoint HTTPEndpoint, next http.Handler) http.Handler
+}
+
+func (m HTTPEndpointMiddleware) appliesTo(endpoint HTTPEndpoint) bool {
+	if len(m.Paths) == 0 {
+		return true
+	}
+	for _, path := range m.Paths {
+		if path == endpoint.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// built-in middleware orders
+const (
+	HTTPRequestIDMiddlewareOrder      = 100
+	HTTPPanicRecoveryMiddlewareOrder  = 200
+	HTTPRequestEventMiddlewareOrder   = 300
+	HTTPRequestMetricsMiddlewareOrder = 400
+)
+
+// httpMiddlewares is sorted by order - see `sortHTTPMiddlewares()`
+type httpMiddlewares []HTTPEndpointMiddleware
+
+// validate runs the following checks:
+//	- middleware names are unique
+//	- wrap funcs are not nil
+func (middlewares httpMiddlewares) validate() error {
+	names := make(map[string]bool, len(middlewares))
+	for _, middleware := range middlewares {
+		if names[middleware.Name] {
+			return fmt.Errorf("duplicate HTTP middleware name: %v", middleware.Name)
+		}
+		if middleware.Wrap == nil {
+			return fmt.Errorf("http middleware wrap func is nil for: %v", middleware.Name)
+		}
+		names[middleware.Name] = true
+	}
+	return nil
+}
+
+// sortHTTPMiddlewares sorts the middleware by order, and then by name for middleware with the same order
+func sortHTTPMiddlewares(middlewares []HTTPEndpointMiddleware) httpMiddlewares {
+	sorted := append(httpMiddlewares(nil), middlewares...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Order == sorted[j].Order {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].Order < sorted[j].Order
+	})
+	return sorted
+}
+
+// handler returns the endpoint handler wrapped with the middleware that applies to the endpoint
+func (middlewares httpMiddlewares) handler(endpoint HTTPEndpoint) http.Handler {
+	var handler http.Handler = http.HandlerFunc(endpoint.Handler)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if middlewares[i].appliesTo(endpoint) {
+			handler = middlewares[i].Wrap(endpoint, handler)
+		}
+	}
+	return handler
+}
+
+// RequestIDHeader is the HTTP header used to propagate the request ID
+const RequestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// RequestID returns the request ID (XID) that was assigned to the HTTP request by the request ID middleware.
+func RequestID(ctx context.Context) (xid.ID, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(xid.ID)
+	return id, ok
+}
+
+// requestIDMiddleware assigns each request an ID (XID), which is added to the request context and to the response via
+// the `RequestIDHeader`. If the request specifies a valid XID via the `RequestIDHeader`, then it is used.
+func requestIDMiddleware() HTTPMiddleware {
+	return NewHTTPMiddleware("request-id", HTTPRequestIDMiddlewareOrder, func(_ HTTPEndpoint, next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			id, err := xid.FromString(request.Header.Get(RequestIDHeader))
+			if err != nil {
+				id = xid.New()
+			}
+			writer.Header().Set(RequestIDHeader, id.String())
+			next.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), requestIDKey{}, id)))
+		})
+	})
+}
+
+// HTTP middleware related events
+const (
+	// HTTPRequestEvent is logged for each HTTP request. Requests for admin endpoints are logged at debug level.
+	//
+	// 	type Data struct {
+	//		ID     string // request ID
+	//		Method string
+	//		Path   string // HTTP endpoint path
+	//		URL    string
+	//		Status int
+	//		Bytes  int
+	//		Dur    uint // msec
+	//	}
+	HTTPRequestEvent = "01M51RKDY9M3BAGR1Q2MEBDGCN"
+
+	// HTTPHandlerPanicEvent is logged when an HTTP handler panics. The panic is recovered, and HTTP 500 is returned.
+	//
+	// 	type Data struct {
+	//		ID    string // request ID
+	//		Path  string // HTTP endpoint path
+	//		Err   string `json:"e"`
+	//		Stack string
+	//	}
+	HTTPHandlerPanicEvent = "01M51RKDYAGGCV0J4P1SF57VJG"
+)
+
+func requestEventMiddleware(logger *zerolog.Logger) HTTPMiddleware {
+	logAppRequest := eventlog.NewLogger(HTTPRequestEvent, logger, zerolog.InfoLevel)
+	logAdminRequest := eventlog.NewLogger(HTTPRequestEvent, logger, zerolog.DebugLevel)
+	return NewHTTPMiddleware("request-event", HTTPRequestEventMiddlewareOrder, func(endpoint HTTPEndpoint, next http.Handler) http.Handler {
+		logRequest := logAppRequest
+		if endpoint.Admin {
+			logRequest = logAdminRequest
+		}
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			start := time.Now()
+			recorder := newResponseRecorder(writer)
+			recorder.serve(next, request, func() {
+				logRequest(httpRequest{
+					request:  request,
+					path:     endpoint.Path,
+					status:   recorder.status,
+					bytes:    recorder.bytes,
+					duration: time.Since(start),
+				}, "HTTP request")
+			})
+		})
+	})
+}
+
+type httpRequest struct {
+	request  *http.Request
+	path     string
+	status   int
+	bytes    int
+	duration time.Duration
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (event httpRequest) MarshalZerologObject(e *zerolog.Event) {
+	if id, ok := RequestID(event.request.Context()); ok {
+		e.Str("id", id.String())
+	}
+	e.Str("method", event.request.Method)
+	e.Str("path", event.path)
+	e.Str("url", event.request.URL.String())
+	e.Int("status", event.status)
+	e.Int("bytes", event.bytes)
+	e.Dur("dur", event.duration)
+}
+
+// HTTP request metrics
+const (
+	// HTTPRequestsMetricID counts HTTP requests - labels: path, code
+	HTTPRequestsMetricID = "U01M51RKDYACNXTM4KRK3990GGR"
+	// HTTPRequestErrorsMetricID counts HTTP requests that failed with a 5xx status code - labels: path
+	HTTPRequestErrorsMetricID = "U01M51RKDYAMBNTNDAWX6KYD943"
+	// HTTPRequestDurationMetricID is the HTTP request latency histogram in seconds - labels: path
+	HTTPRequestDurationMetricID = "U01M51RKDYAE4842HESTHTPGMNE"
+)
+
+func requestMetricsMiddleware(registerer prometheus.Registerer) (HTTPMiddleware, error) {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: HTTPRequestsMetricID,
+		Help: "HTTP request count",
+	}, []string{"path", "code"})
+	requestErrors := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: HTTPRequestErrorsMetricID,
+		Help: "HTTP request error count, i.e., requests that failed with a 5xx status code",
+	}, []string{"path"})
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    HTTPRequestDurationMetricID,
+		Help:    "HTTP request latency in seconds",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"path"})
+	for _, collector := range []prometheus.Collector{requests, requestErrors, latency} {
+		if err := registerer.Register(collector); err != nil {
+			return HTTPMiddleware{}, err
+		}
+	}
+
+	return NewHTTPMiddleware("request-metrics", HTTPRequestMetricsMiddlewareOrder, func(endpoint HTTPEndpoint, next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			start := time.Now()
+			recorder := newResponseRecorder(writer)
+			recorder.serve(next, request, func() {
+				latency.WithLabelValues(endpoint.Path).Observe(time.Since(start).Seconds())
+				requests.WithLabelValues(endpoint.Path, strconv.Itoa(recorder.status)).Inc()
+				if recorder.status >= http.StatusInternalServerError {
+					requestErrors.WithLabelValues(endpoint.Path).Inc()
+				}
+			})
+		})
+	}), nil
+}
+
+func panicRecoveryMiddleware(logger *zerolog.Logger) HTTPMiddleware {
+	logPanic := eventlog.NewLogger(HTTPHandlerPanicEvent, logger, zerolog.ErrorLevel)
+	return NewHTTPMiddleware("panic-recovery", HTTPPanicRecoveryMiddlewareOrder, func(endpoint HTTPEndpoint, next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			defer func() {
+				if p := recover(); p != nil {
+					if p == http.ErrAbortHandler {
+						// used to abort the response, which the HTTP server handles
+						panic(p)
+					}
+					logPanic(httpHandlerPanic{request, endpoint.Path, p, debug.Stack()}, "HTTP handler panicked")
+					http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(writer, request)
+		})
+	})
+}
+
+type httpHandlerPanic struct {
+	request *http.Request
+	path    string
+	err     interface{}
+	stack   []byte
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (event httpHandlerPanic) MarshalZerologObject(e *zerolog.Event) {
+	if id, ok := RequestID(event.request.Context()); ok {
+		e.Str("id", id.String())
+	}
+	e.Str("path", event.path)
+	e.Err(fmt.Errorf("%v", event.err))
+	e.Str("stack", strings.TrimSpace(string(event.stack)))
+}
+
+// responseRecorder records the response status and the number of bytes written
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func newResponseRecorder(writer http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: writer, status: http.StatusOK}
+}
+
+// serve serves the request and then calls record. If the handler panics, then the response status is recorded as
+// HTTP 500, which is returned by the panic recovery middleware, and the panic is propagated after record is called.
+func (r *responseRecorder) serve(next http.Handler, request *http.Request, record func()) {
+	defer func() {
+		if p := recover(); p != nil {
+			r.status = http.StatusInternalServerError
+			record()
+			panic(p)
+		}
+	}()
+	next.ServeHTTP(r, request)
+	record()
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Flush implements http.Flusher, if the underlying response writer supports it
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, if the underlying response writer supports it, e.g., for WebSocket upgrades
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.Hijacker is not supported by the underlying response writer")
+	}
+	return hijacker.Hijack()
+}
@@ -0,0 +1,299 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"github.com/rs/xid"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPMiddleware_BuiltIn(t *testing.T) {
+	t.Parallel()
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Provide(func() fxapp.HTTPHandler {
+			return fxapp.NewHTTPHandler("/foo", func(writer http.ResponseWriter, request *http.Request) {
+				id, _ := fxapp.RequestID(request.Context())
+				writer.Write([]byte(id.String()))
+			})
+		}).
+		Invoke(func() {}))
+
+	get := func(t *testing.T, url string, header http.Header) (*http.Response, string) {
+		request, _ := http.NewRequest(http.MethodGet, url, nil)
+		for key, values := range header {
+			request.Header[key] = values
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	t.Run("request ID", func(t *testing.T) {
+		resp, body := get(t, app.URL("/foo"), nil)
+		id, err := xid.FromString(resp.Header.Get(fxapp.RequestIDHeader))
+		switch {
+		case err != nil:
+			t.Errorf("*** request ID header is not an XID: %v", err)
+		case body != id.String():
+			t.Errorf("*** request ID was not added to the request context: %v != %v", body, id)
+		}
+
+		// When the request specifies a request ID, then it is used
+		requestID := xid.New().String()
+		if resp, body := get(t, app.URL("/foo"), http.Header{fxapp.RequestIDHeader: {requestID}}); resp.Header.Get(fxapp.RequestIDHeader) != requestID || body != requestID {
+			t.Errorf("*** request ID should have been propagated: %v : %v", resp.Header.Get(fxapp.RequestIDHeader), body)
+		}
+	})
+
+	t.Run("request event", func(t *testing.T) {
+		resp, body := get(t, app.URL("/foo?a=b"), nil)
+		type Data struct {
+			ID     string
+			Method string
+			Path   string
+			URL    string
+			Status int
+			Bytes  int
+		}
+		var data Data
+		for i := 0; i < 100 && data.ID != resp.Header.Get(fxapp.RequestIDHeader); i++ {
+			time.Sleep(10 * time.Millisecond)
+			for _, entry := range fxapptest.EventsNamed(app.Log, fxapp.HTTPRequestEvent) {
+				if err := entry.DecodeData(&data); err != nil {
+					t.Fatal(err)
+				}
+				if data.ID == resp.Header.Get(fxapp.RequestIDHeader) {
+					break
+				}
+			}
+		}
+		expected := Data{ID: body, Method: http.MethodGet, Path: "/foo", URL: "/foo?a=b", Status: http.StatusOK, Bytes: len(body)}
+		if data != expected {
+			t.Errorf("*** request event did not match: %v != %v", data, expected)
+		}
+	})
+
+	t.Run("request metrics", func(t *testing.T) {
+		_, metrics := get(t, app.URL(fxapp.MetricsEndpoint), nil)
+		for _, metric := range []string{fxapp.HTTPRequestsMetricID, fxapp.HTTPRequestDurationMetricID} {
+			if !strings.Contains(metrics, metric) || !strings.Contains(metrics, `path="/foo"`) {
+				t.Errorf("*** HTTP request metric was not found: %v", metric)
+			}
+		}
+	})
+}
+
+func TestHTTPMiddleware_Custom(t *testing.T) {
+	t.Parallel()
+	ok := func(writer http.ResponseWriter, request *http.Request) {}
+	traceMiddleware := func(name string) func(endpoint fxapp.HTTPEndpoint, next http.Handler) http.Handler {
+		return func(endpoint fxapp.HTTPEndpoint, next http.Handler) http.Handler {
+			return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				writer.Header().Add("X-Trace", name)
+				next.ServeHTTP(writer, request)
+			})
+		}
+	}
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Provide(
+			func() fxapp.HTTPHandler { return fxapp.NewHTTPHandler("/foo", ok) },
+			func() fxapp.HTTPHandler { return fxapp.NewHTTPHandler("/bar", ok) },
+			// registered out of order
+			func() fxapp.HTTPMiddleware { return fxapp.NewHTTPMiddleware("b", 20, traceMiddleware("b")) },
+			func() fxapp.HTTPMiddleware { return fxapp.NewHTTPMiddleware("foo", 30, traceMiddleware("foo"), "/foo") },
+			func() fxapp.HTTPMiddleware { return fxapp.NewHTTPMiddleware("a", 10, traceMiddleware("a")) },
+		).
+		Invoke(func() {}))
+
+	for path, expected := range map[string]string{"/foo": "a,b,foo", "/bar": "a,b"} {
+		resp, err := http.Get(app.URL(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if trace := strings.Join(resp.Header["X-Trace"], ","); trace != expected {
+			t.Errorf("*** middleware was not applied in order: %v : %v != %v", path, trace, expected)
+		}
+	}
+}
+
+func TestHTTPMiddleware_DuplicateNames(t *testing.T) {
+	t.Parallel()
+	wrap := func(endpoint fxapp.HTTPEndpoint, next http.Handler) http.Handler { return next }
+	_, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Provide(
+			func() fxapp.HTTPMiddleware { return fxapp.NewHTTPMiddleware("a", 10, wrap) },
+			func() fxapp.HTTPMiddleware { return fxapp.NewHTTPMiddleware("a", 20, wrap) },
+		).
+		Invoke(func() {}).
+		LogWriter(fxapptest.NewSyncLog()).
+		Build()
+	if err == nil {
+		t.Error("*** app should have failed to build because middleware names are not unique")
+	}
+}
+
+func TestHTTPMiddleware_PanicRecovery(t *testing.T) {
+	t.Parallel()
+	panicky := func(endpoint fxapp.HTTPEndpoint, next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			panic("middleware panic")
+		})
+	}
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Provide(
+			func() fxapp.HTTPHandler {
+				return fxapp.NewHTTPHandler("/panic", func(writer http.ResponseWriter, request *http.Request) {
+					panic("handler panic")
+				})
+			},
+			func() fxapp.HTTPHandler {
+				return fxapp.NewHTTPHandler("/middleware-panic", func(writer http.ResponseWriter, request *http.Request) {})
+			},
+			// panics in middleware that is ordered after the panic recovery middleware are recovered
+			func() fxapp.HTTPMiddleware {
+				return fxapp.NewHTTPMiddleware("panicky", fxapp.HTTPRequestMetricsMiddlewareOrder+1, panicky, "/middleware-panic")
+			},
+		).
+		Invoke(func() {}))
+
+	for _, path := range []string{"/panic", "/middleware-panic"} {
+		resp, err := http.Get(app.URL(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		requestID := resp.Header.Get(fxapp.RequestIDHeader)
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("*** panic should have been recovered with HTTP 500: %v : %v", path, resp.StatusCode)
+		}
+
+		// the panic event and the request event are logged with the request ID
+		awaitEvent := func(name string, v interface{ id() string }) bool {
+			for i := 0; i < 100; i++ {
+				for _, entry := range fxapptest.EventsNamed(app.Log, name) {
+					if err := entry.DecodeData(v); err != nil {
+						t.Fatal(err)
+					}
+					if v.id() == requestID {
+						return true
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			return false
+		}
+		var panicEvent httpHandlerPanicData
+		if !awaitEvent(fxapp.HTTPHandlerPanicEvent, &panicEvent) {
+			t.Errorf("*** panic event was not logged: %v", path)
+		} else if panicEvent.Path != path || panicEvent.Stack == "" {
+			t.Errorf("*** panic event did not match: %v", panicEvent)
+		}
+		var requestEvent httpRequestData
+		if !awaitEvent(fxapp.HTTPRequestEvent, &requestEvent) {
+			t.Errorf("*** request event was not logged: %v", path)
+		} else if requestEvent.Status != http.StatusInternalServerError {
+			t.Errorf("*** request event status should be HTTP 500: %v", requestEvent)
+		}
+	}
+
+	resp, err := http.Get(app.URL(fxapp.MetricsEndpoint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	metrics, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/panic", "/middleware-panic"} {
+		recorded := false
+		for _, line := range strings.Split(string(metrics), "\n") {
+			if strings.HasPrefix(line, fxapp.HTTPRequestErrorsMetricID+"{") && strings.Contains(line, `path="`+path+`"`) && strings.HasSuffix(line, " 1") {
+				recorded = true
+			}
+		}
+		if !recorded {
+			t.Errorf("*** HTTP request error metric was not recorded for the panic: %v", path)
+		}
+	}
+}
+
+type httpHandlerPanicData struct {
+	ID    string
+	Path  string
+	Stack string
+}
+
+func (d *httpHandlerPanicData) id() string { return d.ID }
+
+type httpRequestData struct {
+	ID     string
+	Status int
+}
+
+func (d *httpRequestData) id() string { return d.ID }
+
+func TestHTTPMiddleware_Hijacker(t *testing.T) {
+	t.Parallel()
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Provide(func() fxapp.HTTPHandler {
+			return fxapp.NewHTTPHandler("/hijack", func(writer http.ResponseWriter, request *http.Request) {
+				hijacker, ok := writer.(http.Hijacker)
+				if !ok {
+					http.Error(writer, "http.Hijacker is not supported", http.StatusInternalServerError)
+					return
+				}
+				conn, buf, err := hijacker.Hijack()
+				if err != nil {
+					http.Error(writer, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				defer conn.Close()
+				buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+				buf.Flush()
+			})
+		}).
+		Invoke(func() {}))
+
+	resp, err := http.Get(app.URL("/hijack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "hijacked" {
+		t.Errorf("*** connection should have been hijacked: %v : %v", resp.StatusCode, string(body))
+	}
+}
@@ -0,0 +1,256 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// PathParam returns the value of the path parameter for the request, where path parameters are declared in the HTTP
+// endpoint path pattern, e.g., "/users/{id}". A blank string is returned if the path parameter is not defined.
+func PathParam(request *http.Request, name string) string {
+	params, _ := request.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+type pathParamsKey struct{}
+
+// httpPathPattern is a parsed HTTP endpoint path pattern:
+//	- path segments are separated by '/'
+//	- a segment in the form of "{name}" is a path parameter, which matches any non-blank segment
+//	- if the pattern ends with '/', then the pattern matches the subtree rooted at the path, e.g., "/debug/pprof/"
+//	  matches "/debug/pprof/heap"
+type httpPathPattern struct {
+	segments []string
+	params   map[int]string // segment index -> param name
+	subtree  bool
+}
+
+func parseHTTPPathPattern(pattern string) (httpPathPattern, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return httpPathPattern{}, fmt.Errorf("HTTP endpoint path must start with '/': %q", pattern)
+	}
+	p := httpPathPattern{
+		params:  make(map[int]string),
+		subtree: strings.HasSuffix(pattern, "/"),
+	}
+	trimmed := strings.Trim(pattern, "/")
+	if trimmed == "" {
+		return p, nil
+	}
+	names := make(map[string]bool)
+	for i, segment := range strings.Split(trimmed, "/") {
+		if strings.ContainsAny(segment, "{}") {
+			name := strings.TrimSuffix(strings.TrimPrefix(segment, "{"), "}")
+			if name == "" || len(name)+2 != len(segment) || strings.ContainsAny(name, "{}") {
+				return httpPathPattern{}, fmt.Errorf("invalid HTTP endpoint path parameter: %q : %q", pattern, segment)
+			}
+			if names[name] {
+				return httpPathPattern{}, fmt.Errorf("duplicate HTTP endpoint path parameter: %q : %q", pattern, name)
+			}
+			names[name] = true
+			p.params[i] = name
+		}
+		p.segments = append(p.segments, segment)
+	}
+	return p, nil
+}
+
+// key normalizes the pattern, i.e., parameter names are ignored - patterns with the same key match the same paths
+func (p httpPathPattern) key() string {
+	segments := make([]string, len(p.segments))
+	for i, segment := range p.segments {
+		if _, ok := p.params[i]; ok {
+			segment = "{}"
+		}
+		segments[i] = segment
+	}
+	key := "/" + strings.Join(segments, "/")
+	if p.subtree && len(segments) > 0 {
+		key += "/"
+	}
+	return key
+}
+
+// match returns the path params if the path matches the pattern
+func (p httpPathPattern) match(path string) (map[string]string, bool) {
+	trimmed := strings.Trim(path, "/")
+	var segments []string
+	if trimmed != "" {
+		segments = strings.Split(trimmed, "/")
+	}
+	switch {
+	case p.subtree && len(segments) < len(p.segments):
+		return nil, false
+	case p.subtree && len(segments) == len(p.segments) && !strings.HasSuffix(path, "/"):
+		// the subtree root must be requested with the trailing slash, e.g., "/debug/pprof" is redirected to "/debug/pprof/"
+		return nil, false
+	case !p.subtree && (len(segments) != len(p.segments) || (strings.HasSuffix(path, "/") && path != "/")):
+		return nil, false
+	}
+	params := make(map[string]string, len(p.params))
+	for i, segment := range p.segments {
+		if name, ok := p.params[i]; ok {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[name] = segments[i]
+			continue
+		}
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// specificity is used to select the best matching route: exact patterns rank before subtree patterns, and then
+// patterns with more literal segments rank first
+func (p httpPathPattern) specificity() (exact bool, literals int, segments int) {
+	return !p.subtree, len(p.segments) - len(p.params), len(p.segments)
+}
+
+type httpRoute struct {
+	pattern httpPathPattern
+	methods map[string]bool // if empty, then all methods are allowed
+	handler http.Handler
+}
+
+func (r httpRoute) allows(method string) bool {
+	return len(r.methods) == 0 || r.methods[method]
+}
+
+// httpRouter routes requests to HTTP endpoints by method and path pattern.
+//
+// If the path matches but the method is not allowed, then HTTP 405 is returned with the `Allow` header set to the
+// allowed methods. Consistent with http.ServeMux, requests are redirected if:
+//	- the path is not in canonical form, e.g., contains "//", "." or ".." elements
+//	- the path matches a subtree pattern without the trailing slash
+type httpRouter struct {
+	routes []httpRoute // sorted by specificity
+}
+
+func newHTTPRouter(endpoints []HTTPEndpoint, middleware httpMiddlewares) (*httpRouter, error) {
+	router := &httpRouter{}
+	for _, endpoint := range endpoints {
+		pattern, err := parseHTTPPathPattern(endpoint.Path)
+		if err != nil {
+			return nil, err
+		}
+		route := httpRoute{
+			pattern: pattern,
+			methods: make(map[string]bool, len(endpoint.Methods)),
+			handler: middleware.handler(endpoint),
+		}
+		for _, method := range endpoint.Methods {
+			method = strings.ToUpper(strings.TrimSpace(method))
+			route.methods[method] = true
+			if method == http.MethodGet {
+				route.methods[http.MethodHead] = true
+			}
+		}
+		router.routes = append(router.routes, route)
+	}
+	sort.SliceStable(router.routes, func(i, j int) bool {
+		iExact, iLiterals, iSegments := router.routes[i].pattern.specificity()
+		jExact, jLiterals, jSegments := router.routes[j].pattern.specificity()
+		switch {
+		case iExact != jExact:
+			return iExact
+		case iLiterals != jLiterals:
+			return iLiterals > jLiterals
+		default:
+			return iSegments > jSegments
+		}
+	})
+	return router, nil
+}
+
+func (router *httpRouter) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodConnect {
+		if cleaned := cleanHTTPPath(request.URL.Path); cleaned != request.URL.Path {
+			url := *request.URL
+			url.Path = cleaned
+			http.Redirect(writer, request, url.String(), http.StatusMovedPermanently)
+			return
+		}
+	}
+
+	allowed := make(map[string]bool)
+	matched := false
+	for _, route := range router.routes {
+		params, ok := route.pattern.match(request.URL.Path)
+		if !ok {
+			continue
+		}
+		matched = true
+		if !route.allows(request.Method) {
+			for method := range route.methods {
+				allowed[method] = true
+			}
+			continue
+		}
+		if len(params) > 0 {
+			request = request.WithContext(context.WithValue(request.Context(), pathParamsKey{}, params))
+		}
+		route.handler.ServeHTTP(writer, request)
+		return
+	}
+
+	if matched {
+		methods := make([]string, 0, len(allowed))
+		for method := range allowed {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		writer.Header().Set("Allow", strings.Join(methods, ", "))
+		http.Error(writer, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasSuffix(request.URL.Path, "/") {
+		for _, route := range router.routes {
+			if _, ok := route.pattern.match(request.URL.Path + "/"); ok && route.pattern.subtree {
+				url := *request.URL
+				url.Path += "/"
+				http.Redirect(writer, request, url.String(), http.StatusMovedPermanently)
+				return
+			}
+		}
+	}
+	http.NotFound(writer, request)
+}
+
+// cleanHTTPPath returns the canonical path, i.e., "." and ".." elements are resolved, and repeated slashes are removed.
+// The trailing slash is retained. This is consistent with http.ServeMux.
+func cleanHTTPPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
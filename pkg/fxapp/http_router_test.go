@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"fmt"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestHTTPRouter(t *testing.T) {
+	t.Parallel()
+	reply := func(name string) func(http.ResponseWriter, *http.Request) {
+		return func(writer http.ResponseWriter, request *http.Request) {
+			fmt.Fprintf(writer, "%s %s %s", name, fxapp.PathParam(request, "id"), fxapp.PathParam(request, "item"))
+		}
+	}
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Provide(
+			func() fxapp.HTTPHandler {
+				return fxapp.NewHTTPHandler("/users/{id}", reply("get-user")).AllowMethods(http.MethodGet)
+			},
+			func() fxapp.HTTPHandler {
+				return fxapp.NewHTTPHandler("/users/{id}", reply("update-user")).AllowMethods(http.MethodPut, http.MethodPatch)
+			},
+			func() fxapp.HTTPHandler {
+				return fxapp.NewHTTPHandler("/users/me", reply("me")).AllowMethods(http.MethodGet)
+			},
+			func() fxapp.HTTPHandler {
+				return fxapp.NewHTTPHandler("/users/{id}/items/{item}", reply("user-item"))
+			},
+			func() fxapp.HTTPHandler {
+				return fxapp.NewHTTPHandler("/files/", reply("files"))
+			},
+		).
+		Invoke(func() {}))
+
+	tests := []struct {
+		method, path string
+		status       int
+		body         string
+		location     string
+	}{
+		{http.MethodGet, "/users/123", http.StatusOK, "get-user 123 ", ""},
+		{http.MethodPut, "/users/123", http.StatusOK, "update-user 123 ", ""},
+		{http.MethodGet, "/users/me", http.StatusOK, "me  ", ""},
+		{http.MethodDelete, "/users/123/items/abc", http.StatusOK, "user-item 123 abc", ""},
+		{http.MethodGet, "/files/a/b/c", http.StatusOK, "files  ", ""},
+		{http.MethodGet, "/users", http.StatusNotFound, "", ""},
+		{http.MethodGet, "/users/123/items", http.StatusNotFound, "", ""},
+		{http.MethodDelete, "/users/123", http.StatusMethodNotAllowed, "", ""},
+		{http.MethodGet, "/files/", http.StatusOK, "files  ", ""},
+		// subtree roots are redirected to the path with the trailing slash
+		{http.MethodGet, "/files", http.StatusMovedPermanently, "", "/files/"},
+		// paths are redirected to their canonical form
+		{http.MethodGet, "//users/123", http.StatusMovedPermanently, "", "/users/123"},
+		{http.MethodGet, "/files/../users/me", http.StatusMovedPermanently, "", "/users/me"},
+		{http.MethodGet, "/users/./me/", http.StatusMovedPermanently, "", "/users/me/"},
+	}
+	// redirects are not followed
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for _, test := range tests {
+		request, _ := http.NewRequest(test.method, app.BaseURL+test.path, nil)
+		resp, err := client.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode != test.status:
+			t.Errorf("*** response status did not match: %s %s : %v", test.method, test.path, resp.StatusCode)
+		case test.status == http.StatusOK && string(body) != test.body:
+			t.Errorf("*** response body did not match: %s %s : %q != %q", test.method, test.path, body, test.body)
+		case test.status == http.StatusMethodNotAllowed && resp.Header.Get("Allow") != "GET, HEAD, PATCH, PUT":
+			t.Errorf("*** Allow header did not match: %v", resp.Header.Get("Allow"))
+		case test.status == http.StatusMovedPermanently && resp.Header.Get("Location") != test.location:
+			t.Errorf("*** redirect location did not match: %s : %v", test.path, resp.Header.Get("Location"))
+		}
+	}
+
+	// And the HTTP server starting event includes the endpoint methods
+	entry, err := fxapptest.AwaitEvent(app.Log, fxapp.HTTPServerStarting, fxapptest.ReadyTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data struct{ Endpoints []string }
+	if err := entry.DecodeData(&data); err != nil {
+		t.Fatal(err)
+	}
+	endpoints := make(map[string]bool)
+	for _, endpoint := range data.Endpoints {
+		endpoints[endpoint] = true
+	}
+	for _, endpoint := range []string{"GET /users/{id}", "PUT,PATCH /users/{id}", "/files/"} {
+		if !endpoints[endpoint] {
+			t.Errorf("*** endpoint was not logged: %v : %v", endpoint, data.Endpoints)
+		}
+	}
+}
+
+func TestHTTPRouter_DuplicateEndpoints(t *testing.T) {
+	t.Parallel()
+	ok := func(http.ResponseWriter, *http.Request) {}
+	tests := []struct {
+		name      string
+		endpoints []fxapp.HTTPHandler
+		valid     bool
+	}{
+		{
+			name: "methods do not overlap",
+			endpoints: []fxapp.HTTPHandler{
+				fxapp.NewHTTPHandler("/foo", ok).AllowMethods(http.MethodGet),
+				fxapp.NewHTTPHandler("/foo", ok).AllowMethods(http.MethodPost),
+			},
+			valid: true,
+		},
+		{
+			name: "methods overlap",
+			endpoints: []fxapp.HTTPHandler{
+				fxapp.NewHTTPHandler("/foo", ok).AllowMethods(http.MethodGet, http.MethodPost),
+				fxapp.NewHTTPHandler("/foo", ok).AllowMethods(http.MethodPost),
+			},
+		},
+		{
+			name: "all methods overlap",
+			endpoints: []fxapp.HTTPHandler{
+				fxapp.NewHTTPHandler("/foo", ok),
+				fxapp.NewHTTPHandler("/foo", ok).AllowMethods(http.MethodPost),
+			},
+		},
+		{
+			name: "path param names are ignored",
+			endpoints: []fxapp.HTTPHandler{
+				fxapp.NewHTTPHandler("/foo/{id}", ok),
+				fxapp.NewHTTPHandler("/foo/{name}", ok),
+			},
+		},
+		{
+			name:      "invalid path param",
+			endpoints: []fxapp.HTTPHandler{fxapp.NewHTTPHandler("/foo/{id", ok)},
+		},
+		{
+			name:      "duplicate path param",
+			endpoints: []fxapp.HTTPHandler{fxapp.NewHTTPHandler("/foo/{id}/{id}", ok)},
+		},
+	}
+
+	for _, test := range tests {
+		builder := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+			Invoke(func() {}).
+			LogWriter(fxapptest.NewSyncLog())
+		for _, endpoint := range test.endpoints {
+			endpoint := endpoint
+			builder.Provide(func() fxapp.HTTPHandler { return endpoint })
+		}
+		_, err := builder.Build()
+		if test.valid != (err == nil) {
+			t.Errorf("*** %s: app build result did not match: %v", test.name, err)
+		}
+	}
+}
@@ -25,7 +25,9 @@ import (
 	"github.com/oysterpack/andiamo/pkg/fxapptest"
 	"github.com/oysterpack/andiamo/pkg/ulids"
 	"io"
+	"net"
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -160,7 +162,8 @@ func TestHTTPServer_WithNilhandler(t *testing.T) {
 }
 
 func TestHTTPServer_HandlerPanic(t *testing.T) {
-	app, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+	t.Parallel()
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
 		Provide(
 			func() fxapp.HTTPHandler {
 				return fxapp.NewHTTPHandler("/foo", func(writer http.ResponseWriter, request *http.Request) {
@@ -168,29 +171,97 @@ func TestHTTPServer_HandlerPanic(t *testing.T) {
 				})
 			},
 		).
-		Invoke(func() {}).
-		Build()
+		Invoke(func() {}))
 
-	switch {
-	case err != nil:
-		t.Errorf("*** app build failed: %v", err)
-	default:
-		go app.Run()
-		<-app.Started()
-		defer func() {
-			app.Shutdown()
-			<-app.Done()
-		}()
+	// Then the panic is recovered and the GET /foo request fails with HTTP 500
+	checkHTTPGetResponseStatus(t, app.URL("/foo"), http.StatusInternalServerError)
+	// And the panic is logged
+	entry, err := fxapptest.AwaitEvent(app.Log, fxapp.HTTPHandlerPanicEvent, fxapptest.ReadyTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data struct {
+		ID    string
+		Path  string
+		Err   string `json:"e"`
+		Stack string
+	}
+	if err := entry.DecodeData(&data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Path != "/foo" || data.Err != "BOOM" || data.ID == "" || data.Stack == "" {
+		t.Errorf("*** HTTP handler panic event did not match: %v", entry)
+	}
+	// And HTTP server should still be able to serve other requests
+	checkHTTPGetResponseStatusOK(t, app.URL(fxapp.MetricsEndpoint))
+}
 
-		// Then the GET /foo request will fail because the handler panics
-		if _, err := http.Get("http://:8008/foo"); err == nil {
-			t.Error("HTTP request should have failed with an EOF error")
-		} else {
-			t.Log(err)
+// When an admin HTTP server is provided, then admin endpoints are served separately from the app endpoints.
+func TestHTTPServer_WithAdminServer(t *testing.T) {
+	t.Parallel()
+	app := fxapptest.RunWithAdminServer(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Provide(func() fxapp.HTTPHandler {
+			return fxapp.NewHTTPHandler("/foo", func(writer http.ResponseWriter, request *http.Request) {
+				writer.WriteHeader(http.StatusOK)
+			})
+		}).
+		Invoke(func() {}))
+	adminAddr := strings.TrimPrefix(app.AdminBaseURL, "http://")
+
+	// Then app endpoints are only served by the app HTTP server
+	checkHTTPGetResponseStatusOK(t, app.URL("/foo"))
+	checkHTTPGetResponseStatus(t, app.AdminURL("/foo"), http.StatusNotFound)
+	// And admin endpoints are only served by the admin HTTP server
+	checkHTTPGetResponseStatusOK(t, app.AdminURL(fxapp.MetricsEndpoint))
+	checkHTTPGetResponseStatusOK(t, app.AdminURL(fxapp.ReadyEvent))
+	checkHTTPGetResponseStatus(t, fmt.Sprintf("%s/%s", app.BaseURL, fxapp.MetricsEndpoint), http.StatusNotFound)
+
+	// And both HTTP servers are logged
+	type Data struct {
+		Server    string
+		Addr      string
+		Endpoints []string
+	}
+	servers := make(map[string]Data)
+	for _, entry := range fxapptest.EventsNamed(app.Log, fxapp.HTTPServerStarting) {
+		var data Data
+		if err := entry.DecodeData(&data); err != nil {
+			t.Fatal(err)
 		}
-		// And HTTP server should still be able to serve other requests
-		checkHTTPGetResponseStatusOK(t, fmt.Sprintf("http://:8008/%s", fxapp.MetricsEndpoint))
+		servers[data.Server] = data
+	}
+	switch {
+	case len(servers) != 2:
+		t.Errorf("*** both HTTP servers should have been logged: %v", servers)
+	case servers["app"].Addr == adminAddr || len(servers["app"].Endpoints) != 1:
+		t.Errorf("*** app HTTP server did not match: %v", servers["app"])
+	case servers["admin"].Addr != adminAddr || len(servers["admin"].Endpoints) == 0:
+		t.Errorf("*** admin HTTP server did not match: %v", servers["admin"])
+	}
+}
+
+// When an admin HTTP server is provided and there are no app endpoints, then only the admin HTTP server is run.
+func TestHTTPServer_WithAdminServerOnly(t *testing.T) {
+	t.Parallel()
+	app := fxapptest.RunWithAdminServer(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Invoke(func() {}))
+
+	checkHTTPGetResponseStatusOK(t, app.URL(fxapp.LivenessProbeEvent))
+	if events := fxapptest.EventsNamed(app.Log, fxapp.HTTPServerStarting); len(events) != 1 {
+		t.Errorf("*** only the admin HTTP server should have been started: %v", events)
+	}
+	if _, err := http.Get(fmt.Sprintf("%s/%s", app.BaseURL, fxapp.MetricsEndpoint)); err == nil {
+		t.Error("*** app HTTP server should not be running")
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer l.Close()
+	return l.Addr().String()
 }
 
 func checkHTTPGetResponseStatusOK(t *testing.T, url string) {
@@ -0,0 +1,244 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"context"
+	"fmt"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"go.uber.org/fx"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LivenessPolicy decides whether the app is live based on the current state of the health checks.
+//
+// The liveness policy can be customized by providing a LivenessPolicy, e.g.,
+//
+//	builder.Provide(func() fxapp.LivenessPolicy {
+//		return fxapp.CriticalChecksLivenessPolicy(fxapp.SustainedRedLivenessPolicy(5 * time.Minute))
+//	})
+//
+// If a LivenessPolicy is not provided, then `AnyRedLivenessPolicy()` is used.
+type LivenessPolicy func(checks []LivenessCheckState) LivenessDecision
+
+// LivenessCheckState is the health check state that is evaluated by the LivenessPolicy. Only health checks that have
+// run are evaluated.
+type LivenessCheckState struct {
+	health.Check
+	// Result is the latest health check result
+	Result health.Result
+	// RedSince is when the health check turned Red, i.e., the time of the first Red result in the current run of
+	// consecutive Red results. If the health check is not Red, then it is the zero value.
+	RedSince time.Time
+	// ConsecutiveRed is the number of consecutive Red results
+	ConsecutiveRed uint
+}
+
+// LivenessDecision is the LivenessPolicy decision. The reasons explain the decision, e.g., which health checks caused
+// the liveness probe to fail, or which Red health checks were tolerated. The decision is logged by the liveness probe
+// and returned as JSON in the liveness probe HTTP response.
+type LivenessDecision struct {
+	Live    bool     `json:"live"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// Error implements the error interface, which is used to report the decision as an error if the app is not live
+func (d LivenessDecision) Error() string {
+	return fmt.Sprintf("liveness probe failed: %s", strings.Join(d.Reasons, " : "))
+}
+
+// LivenessCriticalTag is used to tag health checks that are liveness critical - see `CriticalChecksLivenessPolicy()`
+const LivenessCriticalTag = "01M51SDDA9X2R9P1M8ZR5Y2NBV"
+
+// AnyRedLivenessPolicy fails liveness if any health check is Red
+func AnyRedLivenessPolicy() LivenessPolicy {
+	return redChecksLivenessPolicy(func(check LivenessCheckState) (string, bool) {
+		return fmt.Sprintf("health check is Red: %s : %v", check.ID, check.Result.Err), true
+	})
+}
+
+// SustainedRedLivenessPolicy fails liveness if any health check has been Red for at least the specified duration.
+// Red health checks that have not been Red long enough are tolerated.
+func SustainedRedLivenessPolicy(duration time.Duration) LivenessPolicy {
+	return redChecksLivenessPolicy(func(check LivenessCheckState) (string, bool) {
+		redDuration := check.Result.Time.Sub(check.RedSince)
+		if redDuration >= duration {
+			return fmt.Sprintf("health check has been Red for %s: %s : %v", redDuration, check.ID, check.Result.Err), true
+		}
+		return fmt.Sprintf("health check has been Red for %s, which is tolerated for up to %s: %s : %v", redDuration, duration, check.ID, check.Result.Err), false
+	})
+}
+
+// ConsecutiveRedLivenessPolicy fails liveness if any health check has been Red for at least the specified number of
+// consecutive results. Red health checks that have fewer consecutive Red results are tolerated.
+func ConsecutiveRedLivenessPolicy(count uint) LivenessPolicy {
+	return redChecksLivenessPolicy(func(check LivenessCheckState) (string, bool) {
+		if check.ConsecutiveRed >= count {
+			return fmt.Sprintf("health check has been Red %d consecutive times: %s : %v", check.ConsecutiveRed, check.ID, check.Result.Err), true
+		}
+		return fmt.Sprintf("health check has been Red %d consecutive times, which is tolerated for up to %d times: %s : %v", check.ConsecutiveRed, count, check.ID, check.Result.Err), false
+	})
+}
+
+// CriticalChecksLivenessPolicy only applies the policy to health checks that are tagged with `LivenessCriticalTag`.
+// If the policy is nil, then `AnyRedLivenessPolicy()` is applied.
+func CriticalChecksLivenessPolicy(policy LivenessPolicy) LivenessPolicy {
+	if policy == nil {
+		policy = AnyRedLivenessPolicy()
+	}
+	return func(checks []LivenessCheckState) LivenessDecision {
+		critical := make([]LivenessCheckState, 0, len(checks))
+		for _, check := range checks {
+			for _, tag := range check.Tags {
+				if tag == LivenessCriticalTag {
+					critical = append(critical, check)
+					break
+				}
+			}
+		}
+		return policy(critical)
+	}
+}
+
+// redChecksLivenessPolicy evaluates each Red health check. The evaluator returns the reason, and true if the health
+// check fails liveness.
+func redChecksLivenessPolicy(evaluate func(check LivenessCheckState) (reason string, fail bool)) LivenessPolicy {
+	return func(checks []LivenessCheckState) LivenessDecision {
+		decision := LivenessDecision{Live: true}
+		for _, check := range checks {
+			if check.Result.Status != health.Red {
+				continue
+			}
+			reason, fail := evaluate(check)
+			if fail {
+				decision.Live = false
+			}
+			decision.Reasons = append(decision.Reasons, reason)
+		}
+		return decision
+	}
+}
+
+// livenessMonitor tracks the health check state that is evaluated by the LivenessPolicy
+type livenessMonitor struct {
+	policy           LivenessPolicy
+	registeredChecks health.RegisteredChecks
+	checkResults     health.CheckResults
+
+	sync.Mutex
+	checks map[string]*LivenessCheckState
+}
+
+type livenessMonitorOpts struct {
+	fx.In
+
+	Policy LivenessPolicy `optional:"true"`
+
+	RegisteredChecks health.RegisteredChecks
+	CheckResults     health.CheckResults
+	Subscribe        health.SubscribeForCheckResults
+	Lifecycle        fx.Lifecycle
+}
+
+// newLivenessMonitor subscribes to health check results - it is invoked before any app functions are invoked, i.e.,
+// before health checks are registered by the app
+func newLivenessMonitor(opts livenessMonitorOpts) *livenessMonitor {
+	if opts.Policy == nil {
+		opts.Policy = AnyRedLivenessPolicy()
+	}
+	monitor := &livenessMonitor{
+		policy:           opts.Policy,
+		registeredChecks: opts.RegisteredChecks,
+		checkResults:     opts.CheckResults,
+		checks:           make(map[string]*LivenessCheckState),
+	}
+	subscription := opts.Subscribe(nil)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case result, ok := <-subscription.Chan():
+				if !ok {
+					return
+				}
+				monitor.observe(result)
+			}
+		}
+	}()
+	opts.Lifecycle.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			close(done)
+			return nil
+		},
+	})
+	return monitor
+}
+
+func (m *livenessMonitor) observe(result health.Result) {
+	m.Lock()
+	defer m.Unlock()
+	state, ok := m.checks[result.ID]
+	if !ok {
+		state = &LivenessCheckState{}
+		m.checks[result.ID] = state
+	}
+	if !result.Time.After(state.Result.Time) {
+		// results are published asynchronously, i.e., results may be received more than once and out of order
+		return
+	}
+	if result.Status == health.Red {
+		if state.ConsecutiveRed == 0 {
+			state.RedSince = result.Time
+		}
+		state.ConsecutiveRed++
+	} else {
+		state.RedSince = time.Time{}
+		state.ConsecutiveRed = 0
+	}
+	state.Result = result
+}
+
+// decide applies the liveness policy to the health checks that have run, sorted by health check ID.
+//
+// The latest health check results are observed before the policy is applied because the subscription may lag behind.
+func (m *livenessMonitor) decide() LivenessDecision {
+	for _, result := range <-m.checkResults(nil) {
+		m.observe(result)
+	}
+	registeredChecks := <-m.registeredChecks()
+	m.Lock()
+	checks := make([]LivenessCheckState, 0, len(m.checks))
+	for _, registeredCheck := range registeredChecks {
+		if state, ok := m.checks[registeredCheck.ID]; ok {
+			check := *state
+			check.Check = registeredCheck.Check
+			checks = append(checks, check)
+		}
+	}
+	m.Unlock()
+	sort.Slice(checks, func(i, j int) bool { return checks[i].ID < checks[j].ID })
+	return m.policy(checks)
+}
+
+// startLivenessMonitor ensures the liveness monitor subscribes to health check results before any health checks are
+// registered
+func startLivenessMonitor(*livenessMonitor) {}
@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLivenessPolicies(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	redCheck := func(redFor time.Duration, consecutiveRed uint, tags ...string) fxapp.LivenessCheckState {
+		return fxapp.LivenessCheckState{
+			Check:          health.Check{ID: ulids.MustNew().String(), Tags: tags},
+			Result:         health.Result{Status: health.Red, Err: errors.New("RED"), Time: now},
+			RedSince:       now.Add(-redFor),
+			ConsecutiveRed: consecutiveRed,
+		}
+	}
+	greenCheck := fxapp.LivenessCheckState{
+		Check:  health.Check{ID: ulids.MustNew().String()},
+		Result: health.Result{Status: health.Green, Time: now},
+	}
+
+	tests := []struct {
+		name    string
+		policy  fxapp.LivenessPolicy
+		checks  []fxapp.LivenessCheckState
+		live    bool
+		reasons int
+	}{
+		{"any red - green", fxapp.AnyRedLivenessPolicy(), []fxapp.LivenessCheckState{greenCheck}, true, 0},
+		{"any red - red", fxapp.AnyRedLivenessPolicy(), []fxapp.LivenessCheckState{greenCheck, redCheck(0, 1)}, false, 1},
+		{"critical - non critical red", fxapp.CriticalChecksLivenessPolicy(nil), []fxapp.LivenessCheckState{redCheck(0, 1)}, true, 0},
+		{"critical - critical red", fxapp.CriticalChecksLivenessPolicy(nil), []fxapp.LivenessCheckState{redCheck(0, 1), redCheck(0, 1, fxapp.LivenessCriticalTag)}, false, 1},
+		{"sustained red - tolerated", fxapp.SustainedRedLivenessPolicy(time.Minute), []fxapp.LivenessCheckState{redCheck(time.Second, 1)}, true, 1},
+		{"sustained red - failed", fxapp.SustainedRedLivenessPolicy(time.Minute), []fxapp.LivenessCheckState{redCheck(time.Second, 1), redCheck(time.Minute, 1)}, false, 2},
+		{"consecutive red - tolerated", fxapp.ConsecutiveRedLivenessPolicy(3), []fxapp.LivenessCheckState{redCheck(0, 2)}, true, 1},
+		{"consecutive red - failed", fxapp.ConsecutiveRedLivenessPolicy(3), []fxapp.LivenessCheckState{redCheck(0, 3)}, false, 1},
+		{"critical consecutive red", fxapp.CriticalChecksLivenessPolicy(fxapp.ConsecutiveRedLivenessPolicy(3)), []fxapp.LivenessCheckState{redCheck(0, 5), redCheck(0, 3, fxapp.LivenessCriticalTag)}, false, 1},
+	}
+	for _, test := range tests {
+		decision := test.policy(test.checks)
+		if decision.Live != test.live || len(decision.Reasons) != test.reasons {
+			t.Errorf("*** %s: decision did not match: %v", test.name, decision)
+		}
+	}
+}
+
+func TestLivenessPolicy(t *testing.T) {
+	t.Parallel()
+	nonCriticalCheck := health.Check{
+		ID:          ulids.MustNew().String(),
+		Description: "non critical",
+		RedImpact:   "none",
+	}
+	criticalCheck := health.Check{
+		ID:          ulids.MustNew().String(),
+		Description: "critical",
+		RedImpact:   "app is dead",
+		Tags:        []string{fxapp.LivenessCriticalTag},
+	}
+	// health checks must be Green on startup - otherwise the app will fail to start
+	var nonCriticalStatus, criticalStatus int32 = int32(health.Green), int32(health.Green)
+	checker := func(status *int32) func() (health.Status, error) {
+		return func() (health.Status, error) {
+			if status := health.Status(atomic.LoadInt32(status)); status == health.Red {
+				return status, errors.New("RED")
+			}
+			return health.Green, nil
+		}
+	}
+	var probe fxapp.LivenessProbe
+	var checkResults health.CheckResults
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Provide(func() fxapp.LivenessPolicy {
+			return fxapp.CriticalChecksLivenessPolicy(fxapp.ConsecutiveRedLivenessPolicy(2))
+		}).
+		Invoke(func(register health.Register) error {
+			if err := register(nonCriticalCheck, health.CheckerOpts{RunInterval: time.Second}, checker(&nonCriticalStatus)); err != nil {
+				return err
+			}
+			return register(criticalCheck, health.CheckerOpts{RunInterval: time.Second}, checker(&criticalStatus))
+		}).
+		Populate(&probe, &checkResults))
+
+	getDecision := func(t *testing.T) (int, fxapp.LivenessDecision) {
+		resp, err := http.Get(app.URL(fxapp.LivenessProbeEvent))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var decision fxapp.LivenessDecision
+		if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, decision
+	}
+
+	t.Run("non critical Red health check is tolerated", func(t *testing.T) {
+		atomic.StoreInt32(&nonCriticalStatus, int32(health.Red))
+		isRed := func() bool {
+			return len(<-checkResults(func(result health.Result) bool {
+				return result.ID == nonCriticalCheck.ID && result.Status == health.Red
+			})) == 1
+		}
+		for i := 0; i < 50 && !isRed(); i++ {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if !isRed() {
+			t.Fatal("*** non critical health check should be Red")
+		}
+		if err := probe(); err != nil {
+			t.Errorf("*** probe should succeed, but instead failed: %v", err)
+		}
+		status, decision := getDecision(t)
+		if status != http.StatusOK || !decision.Live || len(decision.Reasons) != 0 {
+			t.Errorf("*** liveness decision did not match: %v : %v", status, decision)
+		}
+	})
+
+	t.Run("critical Red health check fails the probe", func(t *testing.T) {
+		atomic.StoreInt32(&criticalStatus, int32(health.Red))
+		status, decision := getDecision(t)
+		// the critical health check must be Red 2 consecutive times
+		for i := 0; i < 50 && decision.Live; i++ {
+			time.Sleep(100 * time.Millisecond)
+			status, decision = getDecision(t)
+		}
+		if status != http.StatusServiceUnavailable || decision.Live || len(decision.Reasons) != 1 || !strings.Contains(decision.Reasons[0], criticalCheck.ID) {
+			t.Errorf("*** liveness decision did not match: %v : %v", status, decision)
+		}
+		if err := probe(); err == nil || !strings.Contains(err.Error(), criticalCheck.ID) {
+			t.Errorf("*** probe should have failed: %v", err)
+		}
+
+		var data struct {
+			Live    bool
+			Reasons []string
+		}
+		events := fxapptest.EventsNamed(app.Log, fxapp.LivenessProbeEvent)
+		if err := events[len(events)-1].DecodeData(&data); err != nil {
+			t.Fatal(err)
+		}
+		if data.Live || len(data.Reasons) != 1 {
+			t.Errorf("*** liveness probe event did not match: %v", events[len(events)-1])
+		}
+	})
+}
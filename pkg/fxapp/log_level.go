@@ -0,0 +1,281 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/oysterpack/andiamo/pkg/eventlog"
+	"github.com/rs/zerolog"
+	"go.uber.org/fx"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LogLevelsEndpoint is used to construct the log levels HTTP endpoint, which is used to view and change log levels at
+// runtime:
+//	- GET - returns the current log levels as JSON (see `LogLevels`)
+//	- POST - sets the log level via the following query or form params, and returns the updated log levels
+//	  - level - debug, info, warn, or error (required)
+//	  - component - if specified, then the component log level is set - otherwise the global log level is set
+//	  - ttl - if specified, then the log level is reverted after the TTL expires, e.g., 10m (Go duration format)
+//	- DELETE - resets the component log level specified by the component query param
+//
+// POST and DELETE requests are gated behind the token - see `Builder.LogLevelsToken()`. HTTP 403 is returned if the
+// request does not specify the token. HTTP 400 is returned if the params are invalid, and HTTP 405 for any other methods.
+const LogLevelsEndpoint = "01M51R5FK40K6A5Y550FRM0NJ0"
+
+// LogLevelChangedEvent is logged when a log level is changed, or reverted after its TTL expires.
+//
+// 	type Data struct {
+//		Component string // blank for the global log level
+//		Level     string // blank if the component log level was reset
+//		Prev      string // blank if the component log level was not set
+//		TTL       uint
+//		Trigger   string // api, http, ttl
+//	}
+const LogLevelChangedEvent = "01M51R5FK4CEYTNGRG3YDV27TA"
+
+// log level change triggers
+const (
+	logLevelTriggerAPI  = "api"
+	logLevelTriggerHTTP = "http"
+	logLevelTriggerTTL  = "ttl"
+)
+
+// LogLevels reports the current log levels
+type LogLevels struct {
+	Global     LogLevel
+	Components map[string]LogLevel
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (l LogLevels) MarshalJSON() ([]byte, error) {
+	components := make(map[string]string, len(l.Components))
+	for component, level := range l.Components {
+		components[component] = level.String()
+	}
+	return json.Marshal(struct {
+		Level      string            `json:"level"`
+		Components map[string]string `json:"components"`
+	}{l.Global.String(), components})
+}
+
+// LogLevelController is used to change log levels at runtime. Log levels are process wide.
+//
+// Component log levels apply to component loggers, i.e., loggers that are created via `eventlog.ForComponent()`, e.g.,
+// "fx" and "log" are app component loggers.
+//
+// If a TTL is specified, then the change is reverted after the TTL expires - unless the level has been changed again
+// in the meantime. When the app is stopped, pending reverts are canceled, and the log levels that were changed via the
+// controller are restored to the levels that were set before they were first changed.
+type LogLevelController interface {
+	// SetLevel sets the global log level
+	SetLevel(level LogLevel, ttl time.Duration)
+	// SetComponentLevel sets the component log level
+	SetComponentLevel(component string, level LogLevel, ttl time.Duration)
+	// ResetComponentLevel removes the component log level, i.e., the component reverts to the global log level
+	ResetComponentLevel(component string)
+	// Levels returns the current log levels
+	Levels() LogLevels
+}
+
+type logLevelController struct {
+	sync.Mutex
+	reverts map[string]*time.Timer // keyed by component - the global log level is keyed by a blank component
+	saved   map[string]*LogLevel   // levels before they were first changed - keyed by component
+	stopped bool
+
+	logLevelChanged eventlog.Logger
+}
+
+func newLogLevelController(logger *zerolog.Logger, lc fx.Lifecycle) *logLevelController {
+	c := &logLevelController{
+		reverts:         make(map[string]*time.Timer),
+		saved:           make(map[string]*LogLevel),
+		logLevelChanged: eventlog.NewLogger(LogLevelChangedEvent, logger, zerolog.NoLevel),
+	}
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			c.stop()
+			return nil
+		},
+	})
+	return c
+}
+
+func provideLogLevelController(c *logLevelController) LogLevelController {
+	return c
+}
+
+func (c *logLevelController) SetLevel(level LogLevel, ttl time.Duration) {
+	c.set("", &level, ttl, logLevelTriggerAPI)
+}
+
+func (c *logLevelController) SetComponentLevel(component string, level LogLevel, ttl time.Duration) {
+	c.set(component, &level, ttl, logLevelTriggerAPI)
+}
+
+func (c *logLevelController) ResetComponentLevel(component string) {
+	c.set(component, nil, 0, logLevelTriggerAPI)
+}
+
+func (c *logLevelController) Levels() LogLevels {
+	levels := LogLevels{
+		Global:     zerologLogLevel(eventlog.Level()),
+		Components: make(map[string]LogLevel),
+	}
+	for component, level := range eventlog.ComponentLevels() {
+		levels.Components[component] = zerologLogLevel(level)
+	}
+	return levels
+}
+
+// set sets the log level for the component, where a blank component refers to the global log level.
+// A nil level resets the component log level.
+func (c *logLevelController) set(component string, level *LogLevel, ttl time.Duration, trigger string) {
+	c.Lock()
+	defer c.Unlock()
+	if revert, ok := c.reverts[component]; ok {
+		revert.Stop()
+		delete(c.reverts, component)
+	}
+	prev := c.level(component)
+	if _, ok := c.saved[component]; !ok {
+		c.saved[component] = prev
+	}
+	c.apply(component, level)
+	c.logLevelChanged(logLevelChange{component, level, prev, ttl, trigger}, "log level changed")
+
+	if ttl > 0 && !c.stopped {
+		var revert *time.Timer
+		revert = time.AfterFunc(ttl, func() {
+			c.Lock()
+			defer c.Unlock()
+			if c.reverts[component] != revert {
+				// the revert was canceled
+				return
+			}
+			delete(c.reverts, component)
+			current := c.level(component)
+			c.apply(component, prev)
+			c.logLevelChanged(logLevelChange{component, prev, current, 0, logLevelTriggerTTL}, "log level reverted")
+		})
+		c.reverts[component] = revert
+	}
+}
+
+// level returns the current level for the component, where nil means the component log level is not set
+func (c *logLevelController) level(component string) *LogLevel {
+	if component == "" {
+		level := zerologLogLevel(eventlog.Level())
+		return &level
+	}
+	if level, ok := eventlog.ComponentLevels()[component]; ok {
+		componentLevel := zerologLogLevel(level)
+		return &componentLevel
+	}
+	return nil
+}
+
+func (c *logLevelController) apply(component string, level *LogLevel) {
+	switch {
+	case component == "" && level != nil:
+		eventlog.SetLevel(level.ZerologLevel())
+	case level == nil:
+		eventlog.ResetComponentLevel(component)
+	default:
+		eventlog.SetComponentLevel(component, level.ZerologLevel())
+	}
+}
+
+func (c *logLevelController) stop() {
+	c.Lock()
+	defer c.Unlock()
+	c.stopped = true
+	for component, revert := range c.reverts {
+		revert.Stop()
+		delete(c.reverts, component)
+	}
+	// log levels are process wide, thus they are restored in order not to leak beyond the app's lifetime
+	for component, level := range c.saved {
+		c.apply(component, level)
+		delete(c.saved, component)
+	}
+}
+
+type logLevelChange struct {
+	component   string
+	level, prev *LogLevel
+	ttl         time.Duration
+	trigger     string
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (event logLevelChange) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("component", event.component)
+	if event.level != nil {
+		e.Str("level", event.level.String())
+	}
+	if event.prev != nil {
+		e.Str("prev", event.prev.String())
+	}
+	e.Dur("ttl", event.ttl)
+	e.Str("trigger", event.trigger)
+}
+
+func logLevelsHTTPHandler(c *logLevelController, token Secret) HTTPHandler {
+	writeLevels := func(writer http.ResponseWriter) {
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(c.Levels())
+	}
+	changeLevels := tokenGated(token, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodPost:
+			level, err := ParseLogLevel(request.FormValue("level"))
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var ttl time.Duration
+			if param := request.FormValue("ttl"); param != "" {
+				if ttl, err = time.ParseDuration(param); err != nil || ttl < 0 {
+					http.Error(writer, fmt.Sprintf("invalid ttl: %q", param), http.StatusBadRequest)
+					return
+				}
+			}
+			c.set(request.FormValue("component"), &level, ttl, logLevelTriggerHTTP)
+		case http.MethodDelete:
+			component := request.FormValue("component")
+			if component == "" {
+				http.Error(writer, "component is required", http.StatusBadRequest)
+				return
+			}
+			c.set(component, nil, 0, logLevelTriggerHTTP)
+		}
+		writeLevels(writer)
+	})
+	return NewAdminHTTPHandler(fmt.Sprintf("/%s", LogLevelsEndpoint), func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodGet {
+			writeLevels(writer)
+			return
+		}
+		changeLevels(writer, request)
+	}).AllowMethods(http.MethodGet, http.MethodPost, http.MethodDelete)
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"encoding/json"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogLevelController(t *testing.T) {
+	var controller fxapp.LogLevelController
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Invoke(func(c fxapp.LogLevelController) { controller = c }))
+
+	component := ulids.MustNew().String()
+	controller.SetComponentLevel(component, fxapp.DebugLogLevel, 50*time.Millisecond)
+	if level, ok := controller.Levels().Components[component]; !ok || level != fxapp.DebugLogLevel {
+		t.Errorf("*** component log level was not set: %v", controller.Levels())
+	}
+
+	// Then the component log level reverts after the TTL expires
+	type LogEvent struct {
+		Component string
+		Level     string
+		Prev      string
+		TTL       uint
+		Trigger   string
+	}
+	events := make(map[string]LogEvent)
+	for i := 0; i < 100 && len(events) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		for _, entry := range fxapptest.EventsNamed(app.Log, fxapp.LogLevelChangedEvent) {
+			var data LogEvent
+			if err := entry.DecodeData(&data); err != nil {
+				t.Fatal(err)
+			}
+			if data.Component == component {
+				events[data.Trigger] = data
+			}
+		}
+	}
+	switch {
+	case events["api"].Level != "debug" || events["api"].Prev != "" || events["api"].TTL == 0:
+		t.Errorf("*** log level changed event did not match: %v", events)
+	case events["ttl"].Level != "" || events["ttl"].Prev != "debug":
+		t.Errorf("*** log level reverted event did not match: %v", events)
+	}
+	if _, ok := controller.Levels().Components[component]; ok {
+		t.Errorf("*** component log level should have been reverted: %v", controller.Levels())
+	}
+}
+
+func TestLogLevelController_RestoresLevelsOnStop(t *testing.T) {
+	var controller fxapp.LogLevelController
+	app, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		LogWriter(fxapptest.NewSyncLog()).
+		Invoke(func(c fxapp.LogLevelController) { controller = c }).
+		DisableHTTPServer().
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Run()
+	<-app.Ready()
+
+	global := controller.Levels().Global
+	component := ulids.MustNew().String()
+	controller.SetComponentLevel(component, fxapp.ErrorLogLevel, 0)
+	controller.SetComponentLevel(component, fxapp.DebugLogLevel, time.Hour)
+	controller.SetLevel(fxapp.ErrorLogLevel, 0)
+
+	app.Shutdown()
+	<-app.Done()
+	levels := controller.Levels()
+	if _, ok := levels.Components[component]; ok || levels.Global != global {
+		t.Errorf("*** log levels should have been restored when the app stopped: %v", levels)
+	}
+}
+
+func TestLogLevelsEndpoint(t *testing.T) {
+	token := ulids.MustNew().String()
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		LogLevelsToken(fxapp.NewSecret([]byte(token))).
+		Invoke(func() {}))
+	endpoint := app.URL(fxapp.LogLevelsEndpoint)
+	component := ulids.MustNew().String()
+
+	// changeLevels sends the params as a form for POST requests, and as query params otherwise. The token is specified
+	// via the HTTP Authorization header, if not blank.
+	changeLevels := func(t *testing.T, method string, params url.Values, token string) *http.Response {
+		var request *http.Request
+		if method == http.MethodPost {
+			request, _ = http.NewRequest(method, endpoint, strings.NewReader(params.Encode()))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			request, _ = http.NewRequest(method, endpoint+"?"+params.Encode(), nil)
+		}
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	type LogLevels struct {
+		Level      string
+		Components map[string]string
+	}
+	decode := func(t *testing.T, resp *http.Response) LogLevels {
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("*** response status did not match: %v", resp.StatusCode)
+		}
+		var levels LogLevels
+		if err := json.NewDecoder(resp.Body).Decode(&levels); err != nil {
+			t.Fatal(err)
+		}
+		return levels
+	}
+
+	// changing log levels requires the token
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		for _, token := range []string{"", ulids.MustNew().String()} {
+			resp := changeLevels(t, method, url.Values{"level": {"debug"}, "component": {component}}, token)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusForbidden {
+				t.Errorf("*** log levels should not be changed without the token: %v : %v", method, resp.StatusCode)
+			}
+		}
+	}
+
+	resp := changeLevels(t, http.MethodPost, url.Values{"level": {"debug"}, "component": {component}, "ttl": {"1h"}}, token)
+	if levels := decode(t, resp); levels.Components[component] != "debug" {
+		t.Errorf("*** component log level was not set: %v", levels)
+	}
+
+	resp, err := http.Get(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if levels := decode(t, resp); levels.Components[component] != "debug" || levels.Level == "" {
+		t.Errorf("*** log levels did not match: %v", levels)
+	}
+
+	resp = changeLevels(t, http.MethodDelete, url.Values{"component": {component}}, token)
+	if levels := decode(t, resp); levels.Components[component] != "" {
+		t.Errorf("*** component log level should have been reset: %v", levels)
+	}
+
+	for _, entry := range fxapptest.EventsNamed(app.Log, fxapp.LogLevelChangedEvent) {
+		var data struct{ Trigger string }
+		if err := entry.DecodeData(&data); err != nil {
+			t.Fatal(err)
+		}
+		if data.Trigger != "http" {
+			t.Errorf("*** log level change should have been triggered via http: %v", entry)
+		}
+	}
+
+	t.Run("bad requests", func(t *testing.T) {
+		for _, params := range []url.Values{{"level": {"verbose"}}, {"level": {"info"}, "ttl": {"forever"}}} {
+			resp := changeLevels(t, http.MethodPost, params, token)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("*** response status did not match: %v : %v", params, resp.StatusCode)
+			}
+		}
+
+		request, _ := http.NewRequest(http.MethodPut, endpoint, nil)
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") == "" {
+			t.Errorf("*** response status did not match: %v", resp.StatusCode)
+		}
+	})
+}
@@ -187,7 +187,7 @@ func newPrometheusHTTPHandler(params prometheusHTTPHandlerParams) HTTPHandler {
 		Timeout:             params.Opts.Timeout,
 	}
 	handler := promhttp.HandlerFor(params.Gatherer, promhttpHandlerOpts)
-	return NewHTTPHandler(params.Opts.Endpoint, handler.ServeHTTP)
+	return NewAdminHTTPHandler(params.Opts.Endpoint, handler.ServeHTTP)
 }
 
 // PrometheusHTTPError indicates an error occurred while handling a metrics scrape HTTP request.
@@ -0,0 +1,306 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/oklog/ulid"
+	"github.com/oysterpack/andiamo/pkg/eventlog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/rs/zerolog"
+	"go.uber.org/fx"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MetricsPushModuleID is the metrics push module ID - see `NewMetricsPushModule()`
+const MetricsPushModuleID = "01M51SW9ZH2K3ZVXYNNAFQM06D"
+
+// metrics push defaults
+const (
+	DefaultMetricsPushInterval     = 15 * time.Second
+	DefaultMetricsPushTimeout      = 5 * time.Second
+	DefaultMetricsPushRetryMax     = 4
+	DefaultMetricsPushRetryWaitMin = 100 * time.Millisecond
+	DefaultMetricsPushRetryWaitMax = 5 * time.Second
+)
+
+// MetricsPushOpts is used to configure pushing metrics. Zero values imply using the defaults.
+type MetricsPushOpts struct {
+	// URL is the push endpoint base URL, e.g., the prometheus pushgateway URL - required
+	URL string
+	// Job is the job name used for the metrics grouping key - defaults to the app ID
+	Job string
+	// Interval is how often metrics are pushed while the app is running
+	Interval time.Duration
+	// Timeout is the HTTP request timeout per push attempt
+	Timeout time.Duration
+	// RetryMax is the max number of times a failed push is retried. If negative, then failed pushes are not retried.
+	RetryMax     int
+	RetryWaitMin time.Duration
+	RetryWaitMax time.Duration
+}
+
+func (opts MetricsPushOpts) withDefaults() MetricsPushOpts {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultMetricsPushInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultMetricsPushTimeout
+	}
+	switch {
+	case opts.RetryMax == 0:
+		opts.RetryMax = DefaultMetricsPushRetryMax
+	case opts.RetryMax < 0:
+		opts.RetryMax = 0
+	}
+	if opts.RetryWaitMin <= 0 {
+		opts.RetryWaitMin = DefaultMetricsPushRetryWaitMin
+	}
+	if opts.RetryWaitMax <= 0 {
+		opts.RetryWaitMax = DefaultMetricsPushRetryWaitMax
+	}
+	return opts
+}
+
+func (opts MetricsPushOpts) validate() error {
+	if strings.TrimSpace(opts.URL) == "" {
+		return errors.New("metrics push URL is required")
+	}
+	if _, err := url.Parse(opts.URL); err != nil {
+		return fmt.Errorf("invalid metrics push URL: %v", err)
+	}
+	if opts.RetryWaitMin > opts.RetryWaitMax {
+		return fmt.Errorf("metrics push retry wait min must not be greater than retry wait max: %v > %v", opts.RetryWaitMin, opts.RetryWaitMax)
+	}
+	return nil
+}
+
+// NewMetricsPushModule constructs a module that pushes the app's metrics to a prometheus pushgateway compatible endpoint,
+// which is meant to be used by short-lived apps, e.g., batch jobs that exit before prometheus scrapes the metrics
+// endpoint.
+//
+// The metrics gathered from the app's prometheus.Gatherer are pushed:
+//	- on the configured interval while the app is running
+//	- once more when the app is stopped
+//
+// Metrics are pushed via HTTP PUT, i.e., each push replaces the metrics for the grouping key. The metrics are grouped
+// by job and the app ID, release ID, and instance ID labels:
+//
+//	{URL}/metrics/job/{job}/a/{app ID}/r/{release ID}/i/{instance ID}
+//
+// Failed pushes are retried with exponential backoff. Pushes are logged - see `MetricsPushEvent` and
+// `MetricsPushRetryEvent`.
+func NewMetricsPushModule(opts MetricsPushOpts) Module {
+	return Module{
+		ID:   MetricsPushModuleID,
+		Name: "metrics-push",
+		Invoke: []interface{}{
+			func(gatherer prometheus.Gatherer, id ID, releaseID ReleaseID, instanceID InstanceID, logger *zerolog.Logger, lc fx.Lifecycle) error {
+				if err := opts.validate(); err != nil {
+					return err
+				}
+				pusher := newMetricsPusher(opts.withDefaults(), gatherer, id, releaseID, instanceID, logger)
+				runMetricsPusher(pusher, lc)
+				return nil
+			},
+		},
+	}
+}
+
+// metrics push related events
+const (
+	// MetricsPushEvent is logged for each push. If the push fails after all retries, then the event is logged with the
+	// error.
+	//
+	// 	type Data struct {
+	//		URL      string
+	//		Attempts int
+	//		Err      string `json:"e"`
+	//		Dur      uint
+	//	}
+	MetricsPushEvent = "01M51SW9ZH0Y4PKVPTGAC95K4B"
+
+	// MetricsPushRetryEvent is logged when a failed push is retried.
+	//
+	// 	type Data struct {
+	//		URL     string
+	//		Attempt int
+	//		Err     string `json:"e"`
+	//	}
+	MetricsPushRetryEvent = "01M51SW9ZHKJYP1ZG3N35Z5CNR"
+)
+
+type metricsPush struct {
+	url      string
+	attempts int
+	err      error
+	duration time.Duration
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (event metricsPush) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("url", event.url)
+	e.Int("attempts", event.attempts)
+	if event.err != nil {
+		e.Err(event.err)
+	}
+	e.Dur("dur", event.duration)
+}
+
+type metricsPushRetry struct {
+	url     string
+	attempt int
+	err     error
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (event metricsPushRetry) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("url", event.url)
+	e.Int("attempt", event.attempt)
+	e.Err(event.err)
+}
+
+type metricsPusher struct {
+	opts     MetricsPushOpts
+	url      string
+	gatherer prometheus.Gatherer
+	client   *http.Client
+
+	logPush, logPushFailure, logRetry eventlog.Logger
+}
+
+func newMetricsPusher(opts MetricsPushOpts, gatherer prometheus.Gatherer, id ID, releaseID ReleaseID, instanceID InstanceID, logger *zerolog.Logger) *metricsPusher {
+	job := opts.Job
+	if strings.TrimSpace(job) == "" {
+		job = ulid.ULID(id).String()
+	}
+	client := cleanhttp.DefaultPooledClient()
+	client.Timeout = opts.Timeout
+	return &metricsPusher{
+		opts: opts,
+		url: fmt.Sprintf("%s/metrics/job/%s/%s/%s/%s/%s/%s/%s",
+			strings.TrimSuffix(opts.URL, "/"), url.PathEscape(job),
+			AppIDLabel, ulid.ULID(id), AppReleaseIDLabel, ulid.ULID(releaseID), AppInstanceIDLabel, ulid.ULID(instanceID),
+		),
+		gatherer:       gatherer,
+		client:         client,
+		logPush:        eventlog.NewLogger(MetricsPushEvent, logger, zerolog.InfoLevel),
+		logPushFailure: eventlog.NewLogger(MetricsPushEvent, logger, zerolog.ErrorLevel),
+		logRetry:       eventlog.NewLogger(MetricsPushRetryEvent, logger, zerolog.WarnLevel),
+	}
+}
+
+func runMetricsPusher(pusher *metricsPusher, lc fx.Lifecycle) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+				ticker := time.NewTicker(pusher.opts.Interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+						pusher.push(ctx)
+					}
+				}
+			}()
+			return nil
+		},
+		// the interval pusher is stopped before the final push, which is bounded by the app stop timeout
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+			<-done
+			return pusher.push(stopCtx)
+		},
+	})
+}
+
+// push gathers the metrics and pushes them, retrying failed pushes with exponential backoff
+func (p *metricsPusher) push(ctx context.Context) error {
+	start := time.Now()
+	body, err := p.gather()
+	if err != nil {
+		p.logPushFailure(metricsPush{url: p.url, err: err, duration: time.Since(start)}, "failed to gather metrics")
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err := p.put(ctx, body)
+		if err == nil {
+			p.logPush(metricsPush{url: p.url, attempts: attempt, duration: time.Since(start)}, "metrics pushed")
+			return nil
+		}
+		if attempt > p.opts.RetryMax {
+			p.logPushFailure(metricsPush{url: p.url, attempts: attempt, err: err, duration: time.Since(start)}, "metrics push failed")
+			return err
+		}
+		p.logRetry(metricsPushRetry{url: p.url, attempt: attempt, err: err}, "metrics push failed - retrying")
+		select {
+		case <-ctx.Done():
+			p.logPushFailure(metricsPush{url: p.url, attempts: attempt, err: err, duration: time.Since(start)}, "metrics push failed")
+			return err
+		case <-time.After(retryablehttp.DefaultBackoff(p.opts.RetryWaitMin, p.opts.RetryWaitMax, attempt-1, nil)):
+		}
+	}
+}
+
+func (p *metricsPusher) gather() ([]byte, error) {
+	mfs, err := p.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	encoder := expfmt.NewEncoder(&buf, expfmt.FmtProtoDelim)
+	for _, mf := range mfs {
+		if err := encoder.Encode(mf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (p *metricsPusher) put(ctx context.Context, body []byte) error {
+	request, err := http.NewRequest(http.MethodPut, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", string(expfmt.FmtProtoDelim))
+	resp, err := p.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected HTTP status code: %d : %s", resp.StatusCode, msg)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"fmt"
+	"github.com/oklog/ulid"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	io_prometheus_client "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pushGateway is a local stand-in for the prometheus pushgateway, which fails the first push
+type pushGateway struct {
+	sync.Mutex
+	paths   []string
+	metrics []string
+}
+
+func (g *pushGateway) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	g.Lock()
+	defer g.Unlock()
+	g.paths = append(g.paths, request.URL.Path)
+	if len(g.paths) == 1 || request.Method != http.MethodPut {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	decoder := expfmt.NewDecoder(request.Body, expfmt.ResponseFormat(request.Header))
+	for {
+		mf := new(io_prometheus_client.MetricFamily)
+		if err := decoder.Decode(mf); err != nil {
+			break
+		}
+		g.metrics = append(g.metrics, mf.GetName())
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+func (g *pushGateway) pushedMetrics() []string {
+	g.Lock()
+	defer g.Unlock()
+	return append([]string(nil), g.metrics...)
+}
+
+func (g *pushGateway) requests() []string {
+	g.Lock()
+	defer g.Unlock()
+	return append([]string(nil), g.paths...)
+}
+
+func TestMetricsPushModule(t *testing.T) {
+	t.Parallel()
+
+	t.Run("metrics are pushed when the app is stopped", func(t *testing.T) {
+		t.Parallel()
+		gateway := new(pushGateway)
+		server := httptest.NewServer(gateway)
+		defer server.Close()
+
+		app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+			Modules(fxapp.NewMetricsPushModule(fxapp.MetricsPushOpts{
+				URL:          server.URL,
+				Job:          "batch",
+				Interval:     time.Hour,
+				RetryWaitMin: time.Millisecond,
+				RetryWaitMax: 10 * time.Millisecond,
+			})).
+			Invoke(func() {}))
+		if requests := gateway.requests(); len(requests) != 0 {
+			t.Errorf("*** metrics should not have been pushed yet: %v", requests)
+		}
+
+		app.Shutdown()
+		select {
+		case <-app.Done():
+		case <-time.After(fxapptest.DoneTimeout):
+			t.Fatal("*** timed out waiting for app to shutdown")
+		}
+
+		// Then the first push fails, and is retried
+		requests := gateway.requests()
+		path := fmt.Sprintf("/metrics/job/batch/a/%s/r/%s/i/%s", ulid.ULID(app.ID()), ulid.ULID(app.ReleaseID()), ulid.ULID(app.InstanceID()))
+		if len(requests) != 2 || requests[0] != path || requests[1] != path {
+			t.Errorf("*** metrics push requests did not match: %v != %v", requests, path)
+		}
+		pushed := false
+		for _, metric := range gateway.pushedMetrics() {
+			pushed = pushed || metric == "go_goroutines"
+		}
+		if !pushed {
+			t.Errorf("*** app metrics should have been pushed: %v", gateway.pushedMetrics())
+		}
+		if events := fxapptest.EventsNamed(app.Log, fxapp.MetricsPushRetryEvent); len(events) != 1 {
+			t.Errorf("*** metrics push retry should have been logged: %v", events)
+		}
+		events := fxapptest.EventsNamed(app.Log, fxapp.MetricsPushEvent)
+		if len(events) != 1 {
+			t.Fatalf("*** metrics push should have been logged: %v", events)
+		}
+		var data struct{ Attempts int }
+		if err := events[0].DecodeData(&data); err != nil {
+			t.Fatal(err)
+		}
+		if data.Attempts != 2 {
+			t.Errorf("*** metrics push attempts did not match: %v", events[0])
+		}
+	})
+
+	t.Run("metrics are pushed on an interval", func(t *testing.T) {
+		t.Parallel()
+		gateway := new(pushGateway)
+		server := httptest.NewServer(gateway)
+		defer server.Close()
+
+		app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+			Modules(fxapp.NewMetricsPushModule(fxapp.MetricsPushOpts{
+				URL:          server.URL,
+				Interval:     10 * time.Millisecond,
+				RetryWaitMin: time.Millisecond,
+				RetryWaitMax: 10 * time.Millisecond,
+			})).
+			Invoke(func() {}))
+
+		for i := 0; i < 100 && len(fxapptest.EventsNamed(app.Log, fxapp.MetricsPushEvent)) < 2; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if events := fxapptest.EventsNamed(app.Log, fxapp.MetricsPushEvent); len(events) < 2 {
+			t.Errorf("*** metrics should have been pushed on an interval: %v", events)
+		}
+		// the job defaults to the app ID
+		path := fmt.Sprintf("/metrics/job/%s/", ulid.ULID(app.ID()))
+		if requests := gateway.requests(); len(requests) == 0 || !strings.HasPrefix(requests[0], path) {
+			t.Errorf("*** metrics push job should default to the app ID: %v", requests)
+		}
+	})
+
+	t.Run("push URL is required", func(t *testing.T) {
+		t.Parallel()
+		_, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+			Modules(fxapp.NewMetricsPushModule(fxapp.MetricsPushOpts{})).
+			Invoke(func() {}).
+			LogWriter(fxapptest.NewSyncLog()).
+			Build()
+		if err == nil {
+			t.Error("*** app should have failed to build because the push URL is blank")
+		}
+	})
+}
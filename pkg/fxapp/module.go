@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"errors"
+	"fmt"
+	"github.com/oysterpack/andiamo/pkg/eventlog"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"go.uber.org/fx"
+	"go.uber.org/multierr"
+	"reflect"
+	"strings"
+)
+
+// Module bundles together application components that are reused across apps, e.g., DB, cache, and messaging setups.
+//
+// Each module is assigned its own component logger, i.e., `eventlog.ForComponent(logger, module.Name)`. Module constructor
+// and function parameters of type `*zerolog.Logger` are injected with the module's component logger.
+//
+// NOTE: the module logger is not injected into `fx.In` struct fields.
+type Module struct {
+	// ID format is ULID
+	ID string
+	// Name is used as the module's logger component name
+	Name string
+
+	// Provide is used to provide dependency injection
+	Provide []interface{}
+	// Invoke functions are invoked in the order they are specified, before the app's functions are invoked.
+	Invoke []interface{}
+
+	// HealthChecks are registered with the app's health service
+	HealthChecks []ModuleHealthCheck
+	// MetricCollectors are registered with the app's prometheus.Registerer
+	MetricCollectors []prometheus.Collector
+	// HTTPEndpoints are registered with the app's HTTP server
+	HTTPEndpoints []HTTPEndpoint
+}
+
+// ModuleHealthCheck is used to register a module health check.
+//
+// If the health check has dependencies, then it can be registered via a module Invoke function using `health.Register`.
+type ModuleHealthCheck struct {
+	health.Check
+	health.CheckerOpts
+	Checker func() (health.Status, error)
+}
+
+// module validation errors
+var (
+	ErrModuleIDNotULID       = errors.New("module ID must be a ULID")
+	ErrModuleNameBlank       = errors.New("module name must not be blank")
+	ErrModuleFuncNotFunction = errors.New("module Provide and Invoke must be functions")
+)
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (m Module) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("id", m.ID)
+	e.Str("name", m.Name)
+}
+
+func (m Module) validate() error {
+	var err error
+	if _, e := ulids.Parse(m.ID); e != nil {
+		err = multierr.Append(ErrModuleIDNotULID, e)
+	}
+	if strings.TrimSpace(m.Name) == "" {
+		err = multierr.Append(err, ErrModuleNameBlank)
+	}
+	for _, f := range append(append([]interface{}{}, m.Provide...), m.Invoke...) {
+		if t := reflect.TypeOf(f); t == nil || t.Kind() != reflect.Func {
+			err = multierr.Append(err, fmt.Errorf("%v : %v", ErrModuleFuncNotFunction, t))
+		}
+	}
+	if err != nil {
+		return multierr.Append(fmt.Errorf("invalid module: %s : %s", m.ID, m.Name), err)
+	}
+	return nil
+}
+
+// options returns the module fx options, which are composed as follows:
+//	1. module constructors are provided
+//	2. module HTTP endpoints are provided
+//	3. module health checks and metric collectors are registered
+//	4. module functions are invoked
+func (m Module) options() []fx.Option {
+	var options []fx.Option
+	for _, f := range m.Provide {
+		options = append(options, fx.Provide(m.injectLogger(f)))
+	}
+	for _, endpoint := range m.HTTPEndpoints {
+		endpoint := endpoint
+		options = append(options, fx.Provide(func() HTTPHandler { return HTTPHandler{HTTPEndpoint: endpoint} }))
+	}
+	if len(m.HealthChecks) > 0 {
+		options = append(options, fx.Invoke(m.registerHealthChecks))
+	}
+	if len(m.MetricCollectors) > 0 {
+		options = append(options, fx.Invoke(m.registerMetricCollectors))
+	}
+	for _, f := range m.Invoke {
+		options = append(options, fx.Invoke(m.injectLogger(f)))
+	}
+	return options
+}
+
+func (m Module) registerHealthChecks(register health.Register) error {
+	for _, check := range m.HealthChecks {
+		if err := register(check.Check, check.CheckerOpts, check.Checker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m Module) registerMetricCollectors(registerer prometheus.Registerer) error {
+	for _, collector := range m.MetricCollectors {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var zerologLoggerType = reflect.TypeOf((*zerolog.Logger)(nil))
+
+// injectLogger wraps the function if it has any `*zerolog.Logger` parameters. The wrapper function has the same
+// signature, and replaces the `*zerolog.Logger` args with the module logger.
+func (m Module) injectLogger(f interface{}) interface{} {
+	fn := reflect.ValueOf(f)
+	fnType := fn.Type()
+	var loggerArgs []int
+	for i := 0; i < fnType.NumIn(); i++ {
+		if fnType.In(i) == zerologLoggerType {
+			loggerArgs = append(loggerArgs, i)
+		}
+	}
+	if len(loggerArgs) == 0 {
+		return f
+	}
+
+	return reflect.MakeFunc(fnType, func(args []reflect.Value) []reflect.Value {
+		logger := reflect.ValueOf(eventlog.ForComponent(args[loggerArgs[0]].Interface().(*zerolog.Logger), m.Name))
+		for _, i := range loggerArgs {
+			args[i] = logger
+		}
+		if fnType.IsVariadic() {
+			return fn.CallSlice(args)
+		}
+		return fn.Call(args)
+	}).Interface()
+}
+
+type modules []Module
+
+// MarshalZerologArray implements zerolog.LogArrayMarshaler interface
+func (m modules) MarshalZerologArray(a *zerolog.Array) {
+	for _, module := range m {
+		a.Object(module)
+	}
+}
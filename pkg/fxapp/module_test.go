@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"encoding/json"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type DB struct {
+	URL string
+}
+
+func dbModule() fxapp.Module {
+	return fxapp.Module{
+		ID:   ulids.MustNew().String(),
+		Name: "db",
+		Provide: []interface{}{
+			func(logger *zerolog.Logger) *DB {
+				logger.Info().Msg("DB provided")
+				return &DB{URL: "postgres://localhost:5432/foo"}
+			},
+		},
+		Invoke: []interface{}{
+			func(db *DB, logger *zerolog.Logger) {
+				logger.Info().Msg("DB initialized")
+			},
+		},
+		HealthChecks: []fxapp.ModuleHealthCheck{
+			{
+				Check: health.Check{
+					ID:          ulids.MustNew().String(),
+					Description: "DB ping",
+					RedImpact:   "DB is unavailable",
+				},
+				Checker: func() (health.Status, error) { return health.Green, nil },
+			},
+		},
+		MetricCollectors: []prometheus.Collector{
+			prometheus.NewCounter(prometheus.CounterOpts{Name: "db_queries", Help: "DB query count"}),
+		},
+		HTTPEndpoints: []fxapp.HTTPEndpoint{
+			{Path: "/db", Handler: func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNoContent) }},
+		},
+	}
+}
+
+func TestBuilder_Modules(t *testing.T) {
+	buf := fxapptest.NewSyncLog()
+	module := dbModule()
+	var db *DB
+	var registeredChecks health.RegisteredChecks
+	var gatherer prometheus.Gatherer
+	app, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		LogWriter(buf).
+		Modules(module).
+		Invoke(func(db *DB) {}).
+		Populate(&db, &registeredChecks, &gatherer).
+		DisableHTTPServer().
+		Build()
+	if err != nil {
+		t.Fatalf("*** app failed to build: %v", err)
+	}
+
+	if db == nil || db.URL == "" {
+		t.Errorf("*** module constructor did not provide DB: %v", db)
+	}
+	if checks := <-registeredChecks(); len(checks) != 1 || checks[0].ID != module.HealthChecks[0].ID {
+		t.Errorf("*** module health check was not registered: %v", checks)
+	}
+	mfs, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fxapp.FindMetricFamily(mfs, func(mf *dto.MetricFamily) bool { return mf.GetName() == "db_queries" }) == nil {
+		t.Error("*** module metric collector was not registered")
+	}
+	if len(app.Modules()) != 1 || app.Modules()[0].ID != module.ID {
+		t.Errorf("*** installed modules did not match: %v", app.Modules())
+	}
+
+	type LogEvent struct {
+		Name      string `json:"n"`
+		Component string `json:"c"`
+		Message   string `json:"m"`
+		Data      struct {
+			Modules []struct {
+				ID   string
+				Name string
+			}
+		} `json:"d"`
+	}
+
+	var moduleLogEvents int
+	var initializedEvent *LogEvent
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		var logEvent LogEvent
+		if err := json.Unmarshal([]byte(line), &logEvent); err != nil {
+			t.Errorf("*** failed to parse log event: %v : %v", err, line)
+			continue
+		}
+		switch {
+		case logEvent.Name == fxapp.InitializedEvent:
+			initializedEvent = &logEvent
+		case logEvent.Message == "DB provided" || logEvent.Message == "DB initialized":
+			moduleLogEvents++
+			if logEvent.Component != "db" {
+				t.Errorf("*** module logger component did not match: %v", line)
+			}
+		}
+	}
+	if moduleLogEvents != 2 {
+		t.Errorf("*** module log events were not logged:\n%v", buf)
+	}
+	switch {
+	case initializedEvent == nil:
+		t.Errorf("*** InitializedEvent was not logged:\n%v", buf)
+	case len(initializedEvent.Data.Modules) != 1 || initializedEvent.Data.Modules[0].ID != module.ID || initializedEvent.Data.Modules[0].Name != "db":
+		t.Errorf("*** InitializedEvent modules did not match: %v", initializedEvent.Data.Modules)
+	}
+}
+
+func TestBuilder_Modules_Invalid(t *testing.T) {
+	duplicate := dbModule()
+	modules := map[string][]fxapp.Module{
+		"ID is not a ULID":     {{ID: "db", Name: "db"}},
+		"name is blank":        {{ID: ulids.MustNew().String()}},
+		"invoke is not a func": {{ID: ulids.MustNew().String(), Name: "db", Invoke: []interface{}{"db"}}},
+		"duplicate module":     {duplicate, duplicate},
+	}
+	for name, modules := range modules {
+		modules := modules
+		t.Run(name, func(t *testing.T) {
+			_, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+				Modules(modules...).
+				Invoke(func() {}).
+				DisableHTTPServer().
+				Build()
+			if err == nil {
+				t.Error("*** app should have failed to build")
+				return
+			}
+			t.Log(err)
+		})
+	}
+}
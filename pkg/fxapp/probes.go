@@ -17,12 +17,10 @@
 package fxapp
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/oysterpack/andiamo/pkg/eventlog"
-	"github.com/oysterpack/andiamo/pkg/fx/health"
-	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
-	"go.uber.org/multierr"
 	"net/http"
 	"sync"
 	"time"
@@ -95,8 +93,14 @@ func (r *readinessWaitGroup) Ready() <-chan struct{} {
 	return c
 }
 
-func readinessProbeHTTPHandler(readiness ReadinessWaitGroup) HTTPHandler {
-	return NewHTTPHandler(fmt.Sprintf("/%s", ReadyEvent), func(writer http.ResponseWriter, request *http.Request) {
+// When the app is draining, the readiness probe fails and the drain reason is reported via the ReadinessDrainReasonHeader.
+func readinessProbeHTTPHandler(readiness ReadinessWaitGroup, drain *drainer) HTTPHandler {
+	return NewAdminHTTPHandler(fmt.Sprintf("/%s", ReadyEvent), func(writer http.ResponseWriter, request *http.Request) {
+		if reason, draining := drain.drainReason(); draining {
+			writer.Header().Add(ReadinessDrainReasonHeader, reason)
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
 		count := readiness.Count()
 		switch count {
 		case 0:
@@ -108,40 +112,77 @@ func readinessProbeHTTPHandler(readiness ReadinessWaitGroup) HTTPHandler {
 	})
 }
 
+// startupState is used to signal when the app has started, i.e., all lifecycle OnStart hooks have completed. It is
+// shared by the startup probe and the liveness probe.
+type startupState struct {
+	started chan struct{}
+}
+
+func newStartupState() *startupState {
+	return &startupState{started: make(chan struct{})}
+}
+
+func (s *startupState) isStarted() bool {
+	select {
+	case <-s.started:
+		return true
+	default:
+		return false
+	}
+}
+
+// The startup probe succeeds once the app has started, even if the app is not yet ready. While the app is starting,
+// HTTP 503 is returned.
+func startupProbeHTTPHandler(startup *startupState) HTTPHandler {
+	return NewAdminHTTPHandler(fmt.Sprintf("/%s", StartedEvent), func(writer http.ResponseWriter, request *http.Request) {
+		if startup.isStarted() {
+			writer.WriteHeader(http.StatusOK)
+			return
+		}
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	})
+}
+
 // LivenessProbe checks if the app is healthy. It returns an error if probe fails, indicating the app is unhealthy.
+// The error is a LivenessDecision, which reports the reasons why the probe failed.
+//
+// The probe always succeeds until the app has started, i.e., slow starting apps are not killed because health checks
+// are Red while the app is starting up - the startup probe is used to monitor app startup. Once the app has started,
+// the LivenessPolicy decides if the app is live.
 type LivenessProbe func() error
 
-func livenessProbe(checkResults health.CheckResults) LivenessProbe {
+func livenessProbe(monitor *livenessMonitor, startup *startupState) LivenessProbe {
 	return func() error {
-		redCheckResults := <-checkResults(func(result health.Result) bool {
-			return result.Status == health.Red
-		})
-		if len(redCheckResults) > 0 {
-			err := errors.New("liveness probe failed because health checks are RED")
-			for _, result := range redCheckResults {
-				err = multierr.Append(err, fmt.Errorf("[%v] %v", result.ID, result.Err))
-			}
-			return err
+		if decision := livenessDecision(monitor, startup); !decision.Live {
+			return decision
 		}
-
 		return nil
 	}
 }
 
-// if any health check status is Red, then the liveness check fails
-func livenessProbeHTTPHandler(probe LivenessProbe, logger *zerolog.Logger) HTTPHandler {
+func livenessDecision(monitor *livenessMonitor, startup *startupState) LivenessDecision {
+	if !startup.isStarted() {
+		return LivenessDecision{Live: true, Reasons: []string{"app is starting"}}
+	}
+	return monitor.decide()
+}
+
+// The LivenessPolicy decision is returned as JSON. If the app is not live, then HTTP 503 is returned.
+func livenessProbeHTTPHandler(monitor *livenessMonitor, startup *startupState, logger *zerolog.Logger) HTTPHandler {
 	logProbeSuccess := eventlog.NewLogger(LivenessProbeEvent, logger, zerolog.InfoLevel)
 	logProbeFailure := eventlog.NewLogger(LivenessProbeEvent, logger, zerolog.ErrorLevel)
-	return NewHTTPHandler(fmt.Sprintf("/%s", LivenessProbeEvent), func(writer http.ResponseWriter, request *http.Request) {
+	return NewAdminHTTPHandler(fmt.Sprintf("/%s", LivenessProbeEvent), func(writer http.ResponseWriter, request *http.Request) {
 		start := time.Now()
-		err := probe()
-		probeDuration := duration(time.Since(start))
-		if err != nil {
+		decision := livenessDecision(monitor, startup)
+		result := &livenessProbeResult{decision, time.Since(start)}
+		writer.Header().Set("Content-Type", "application/json")
+		if !decision.Live {
 			writer.WriteHeader(http.StatusServiceUnavailable)
-			logProbeFailure(eventlog.NewError(err), "liveness probe failed")
-			return
+			logProbeFailure(result, "liveness probe failed")
+		} else {
+			writer.WriteHeader(http.StatusOK)
+			logProbeSuccess(result, "liveness probe success")
 		}
-		writer.WriteHeader(http.StatusOK)
-		logProbeSuccess(probeDuration, "liveness probe success")
+		json.NewEncoder(writer).Encode(decision)
 	})
 }
@@ -456,3 +456,75 @@ func TestLivenessProbHTTPEndpoint(t *testing.T) {
 		checkProbe(t, health.Red)
 	})
 }
+
+// slowStart is used to block app startup after the HTTP server has started
+type slowStart struct{}
+
+func TestStartupProbe(t *testing.T) {
+	t.Parallel()
+	addr := freeAddr(t)
+	check := health.Check{
+		ID:          ulids.MustNew().String(),
+		Description: "Foo",
+		RedImpact:   "Red",
+	}
+	release := make(chan struct{})
+	registered := make(chan struct{})
+	var checkResults health.CheckResults
+	app, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Provide(
+			func() *http.Server { return &http.Server{Addr: addr} },
+			// the constructor is invoked via Populate, which means its hook runs after the HTTP server has started
+			func(lc fx.Lifecycle, register health.Register) *slowStart {
+				lc.Append(fx.Hook{
+					OnStart: func(context.Context) error {
+						// the health check is Red while the app is starting
+						err := register(check, health.CheckerOpts{}, func() (health.Status, error) {
+							return health.Red, errors.New("RED")
+						})
+						close(registered)
+						<-release
+						return err
+					},
+				})
+				return &slowStart{}
+			},
+		).
+		Invoke(func() {}).
+		Populate(new(*slowStart), &checkResults).
+		LogWriter(fxapptest.NewSyncLog()).
+		Build()
+	if err != nil {
+		t.Fatalf("*** app failed to build: %v", err)
+	}
+	go app.Run()
+	defer func() {
+		app.Shutdown()
+		<-app.Done()
+	}()
+
+	startupProbeURL := fmt.Sprintf("http://%s/%s", addr, fxapp.StartedEvent)
+	livenessProbeURL := fmt.Sprintf("http://%s/%s", addr, fxapp.LivenessProbeEvent)
+	<-registered
+	// wait for the HTTP server to start listening
+	for i := 0; i < 100; i++ {
+		if resp, err := http.Get(startupProbeURL); err == nil {
+			resp.Body.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 100 && len(<-checkResults(func(result health.Result) bool { return result.ID == check.ID })) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// While the app is starting, the startup probe fails, and the liveness probe succeeds even though a health check is Red
+	checkHTTPGetResponseStatus(t, startupProbeURL, http.StatusServiceUnavailable)
+	checkHTTPGetResponseStatus(t, livenessProbeURL, http.StatusOK)
+
+	// Once the app has started, the startup probe succeeds, and the liveness probe reports the Red health check
+	close(release)
+	<-app.Started()
+	checkHTTPGetResponseStatus(t, startupProbeURL, http.StatusOK)
+	checkHTTPGetResponseStatus(t, livenessProbeURL, http.StatusServiceUnavailable)
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+	"strings"
+)
+
+// DefaultProfilingPathPrefix is the default admin path prefix for the profiling endpoints
+const DefaultProfilingPathPrefix = "/debug/pprof"
+
+// ProfilingOpts is used to configure the profiling HTTP endpoints - see `Builder.EnableProfiling()`
+type ProfilingOpts struct {
+	// PathPrefix is the admin path prefix that the profiling endpoints are registered under.
+	//
+	// The default path prefix is `DefaultProfilingPathPrefix`.
+	PathPrefix string
+	// Token is used to gate access to sensitive profiles. Requests for sensitive profiles must specify the token via the
+	// HTTP Authorization header using the bearer scheme, i.e., "Authorization: Bearer <token>".
+	//
+	// If the token is not set, then requests for sensitive profiles are forbidden.
+	Token Secret
+}
+
+func (opts ProfilingOpts) withDefaults() ProfilingOpts {
+	opts.PathPrefix = strings.TrimSuffix(strings.TrimSpace(opts.PathPrefix), "/")
+	if opts.PathPrefix == "" {
+		opts.PathPrefix = DefaultProfilingPathPrefix
+	}
+	return opts
+}
+
+func (opts ProfilingOpts) validate() error {
+	if !strings.HasPrefix(opts.PathPrefix, "/") {
+		return fmt.Errorf("profiling path prefix must start with '/': %q", opts.PathPrefix)
+	}
+	return nil
+}
+
+// profilingHTTPHandlers returns the net/http/pprof endpoints, which are registered relative to the path prefix:
+//	- / - index of the runtime profiles
+//	- /<profile> - runtime profiles, e.g., heap, allocs, goroutine, block, mutex, threadcreate (sensitive)
+//	- /symbol - looks up the program counters listed in the request
+//	- /cmdline - the running program's command line (sensitive)
+//	- /profile - CPU profile, the duration is specified via the `seconds` query param (sensitive)
+//	- /trace - execution trace, the duration is specified via the `seconds` query param (sensitive)
+//	- /goroutines - goroutine dump, i.e., the stack traces for all goroutines in plain text (sensitive)
+//
+// Sensitive profiles are gated behind the token.
+//
+// NOTE: importing net/http/pprof also registers its handlers on `http.DefaultServeMux`, which the app never serves.
+func profilingHTTPHandlers(opts ProfilingOpts) []HTTPHandler {
+	gated := func(handler http.HandlerFunc) http.HandlerFunc {
+		return tokenGated(opts.Token, handler)
+	}
+
+	path := func(name string) string {
+		return fmt.Sprintf("%s/%s", opts.PathPrefix, name)
+	}
+	handlers := []HTTPHandler{
+		NewAdminHTTPHandler(path(""), profilesIndex(opts.PathPrefix)),
+		NewAdminHTTPHandler(path("symbol"), pprof.Symbol),
+		NewAdminHTTPHandler(path("cmdline"), gated(pprof.Cmdline)),
+		NewAdminHTTPHandler(path("profile"), gated(pprof.Profile)),
+		NewAdminHTTPHandler(path("trace"), gated(pprof.Trace)),
+		NewAdminHTTPHandler(path("goroutines"), gated(goroutineDump)),
+	}
+	for _, profile := range runtimepprof.Profiles() {
+		handlers = append(handlers, NewAdminHTTPHandler(path(profile.Name()), gated(pprof.Handler(profile.Name()).ServeHTTP)))
+	}
+	return handlers
+}
+
+// profilesIndex serves the pprof index page. The index is registered as a subtree pattern, thus requests for unknown
+// profiles are rejected - `pprof.Index` would otherwise serve profiles that are not gated under the default path prefix.
+func profilesIndex(pathPrefix string) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != pathPrefix+"/" {
+			http.NotFound(writer, request)
+			return
+		}
+		pprof.Index(writer, request)
+	}
+}
+
+// goroutineDump serves the goroutine profile in plain text, i.e., with debug=2
+func goroutineDump(writer http.ResponseWriter, request *http.Request) {
+	request = request.Clone(request.Context())
+	query := request.URL.Query()
+	query.Set("debug", "2")
+	request.URL.RawQuery = query.Encode()
+	pprof.Handler("goroutine").ServeHTTP(writer, request)
+}
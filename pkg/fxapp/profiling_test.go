@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEnableProfiling(t *testing.T) {
+	t.Parallel()
+	token := ulids.MustNew().String()
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		EnableProfiling(fxapp.ProfilingOpts{
+			PathPrefix: "/admin/pprof/",
+			Token:      fxapp.NewSecret([]byte(token)),
+		}).
+		Invoke(func() {}))
+
+	getWithAuthorization := func(t *testing.T, path, authorization string) (int, string) {
+		request, _ := http.NewRequest(http.MethodGet, app.URL(path), nil)
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+	get := func(t *testing.T, path, token string) (int, string) {
+		if token == "" {
+			return getWithAuthorization(t, path, "")
+		}
+		return getWithAuthorization(t, path, "Bearer "+token)
+	}
+
+	t.Run("profiles", func(t *testing.T) {
+		for _, path := range []string{"/admin/pprof/", "/admin/pprof/symbol"} {
+			if status, _ := get(t, path, ""); status != http.StatusOK {
+				t.Errorf("*** response status did not match: %v : %v", path, status)
+			}
+		}
+		for _, path := range []string{"/admin/pprof/heap", "/admin/pprof/allocs", "/admin/pprof/goroutine?debug=1"} {
+			if status, _ := get(t, path, token); status != http.StatusOK {
+				t.Errorf("*** response status did not match: %v : %v", path, status)
+			}
+		}
+		if status, _ := get(t, "/admin/pprof/unknown", token); status != http.StatusNotFound {
+			t.Errorf("*** response status did not match: %v", status)
+		}
+		// delta profiles are supported
+		if status, _ := get(t, "/admin/pprof/allocs?seconds=1", token); status != http.StatusOK {
+			t.Errorf("*** response status did not match: %v", status)
+		}
+	})
+
+	t.Run("sensitive profiles require the token", func(t *testing.T) {
+		for _, path := range []string{
+			"/admin/pprof/cmdline",
+			"/admin/pprof/goroutines",
+			"/admin/pprof/trace?seconds=0.1",
+			"/admin/pprof/profile?seconds=1",
+			"/admin/pprof/goroutine?debug=2",
+			"/admin/pprof/heap",
+			"/admin/pprof/allocs",
+			"/admin/pprof/block",
+			"/admin/pprof/mutex",
+		} {
+			if status, _ := get(t, path, ""); status != http.StatusForbidden {
+				t.Errorf("*** response status did not match: %v : %v", path, status)
+			}
+			if status, _ := get(t, path, ulids.MustNew().String()); status != http.StatusForbidden {
+				t.Errorf("*** response status did not match: %v : %v", path, status)
+			}
+		}
+		status, body := get(t, "/admin/pprof/goroutines", token)
+		if status != http.StatusOK || !strings.Contains(body, "goroutine") {
+			t.Errorf("*** goroutine dump failed: %v : %v", status, body)
+		}
+		if status, _ := get(t, "/admin/pprof/cmdline", token); status != http.StatusOK {
+			t.Errorf("*** response status did not match: %v", status)
+		}
+		// the token must be specified using the bearer scheme
+		for _, authorization := range []string{token, "Basic " + token, "Bearer" + token} {
+			if status, _ := getWithAuthorization(t, "/admin/pprof/heap", authorization); status != http.StatusForbidden {
+				t.Errorf("*** response status did not match: %q : %v", authorization, status)
+			}
+		}
+	})
+
+	t.Run("profiling is disabled by default", func(t *testing.T) {
+		app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+			Invoke(func() {}))
+		resp, err := http.Get(app.URL(fxapp.DefaultProfilingPathPrefix + "/heap"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("*** response status did not match: %v", resp.StatusCode)
+		}
+	})
+
+}
+
+func TestEnableProfilingWithInvalidPathPrefix(t *testing.T) {
+	t.Parallel()
+	_, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		EnableProfiling(fxapp.ProfilingOpts{PathPrefix: "admin/pprof"}).
+		Invoke(func() {}).
+		Build()
+	if err == nil {
+		t.Error("*** app build should have failed because the path prefix is invalid")
+	}
+}
@@ -0,0 +1,350 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"github.com/oysterpack/andiamo/pkg/eventlog"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"go.uber.org/fx"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Secret holds a sensitive value, e.g., a password or an API token.
+//
+// The secret value is never rendered when the secret is formatted via the fmt package (including `%#v`), marshalled
+// as text or JSON, or logged via zerolog - the redacted value "****" is rendered instead. The value is held behind a
+// pointer, which means the value is also not rendered when a struct that contains a Secret in an unexported field is
+// formatted.
+//
+// Secret implements encoding.TextUnmarshaler, and thus can be used as a config field type.
+type Secret struct {
+	value *secretValue
+}
+
+type secretValue struct {
+	bytes []byte
+}
+
+// NewSecret returns a new Secret for the specified value
+func NewSecret(value []byte) Secret {
+	b := make([]byte, len(value))
+	copy(b, value)
+	return Secret{&secretValue{b}}
+}
+
+// Value returns the secret value
+func (s Secret) Value() string {
+	return string(s.Bytes())
+}
+
+// Bytes returns a copy of the secret value
+func (s Secret) Bytes() []byte {
+	if s.value == nil {
+		return nil
+	}
+	b := make([]byte, len(s.value.bytes))
+	copy(b, s.value.bytes)
+	return b
+}
+
+// IsZero returns true if the secret has no value
+func (s Secret) IsZero() bool {
+	return s.value == nil || len(s.value.bytes) == 0
+}
+
+// Equal compares the secret values in constant time
+func (s Secret) Equal(other Secret) bool {
+	return subtle.ConstantTimeCompare(s.Bytes(), other.Bytes()) == 1
+}
+
+// String implements fmt.Stringer - the secret value is redacted
+func (s Secret) String() string {
+	return redacted
+}
+
+// GoString implements fmt.GoStringer - the secret value is redacted
+func (s Secret) GoString() string {
+	return "fxapp.Secret{" + redacted + "}"
+}
+
+// Format implements fmt.Formatter, which ensures the secret value is redacted for all verbs
+func (s Secret) Format(f fmt.State, verb rune) {
+	if verb == 'v' && f.Flag('#') {
+		fmt.Fprint(f, s.GoString())
+		return
+	}
+	fmt.Fprint(f, redacted)
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface - the secret value is redacted
+func (s Secret) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("value", redacted)
+}
+
+// MarshalText implements encoding.TextMarshaler - the secret value is redacted
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(redacted), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (s *Secret) UnmarshalText(text []byte) error {
+	*s = NewSecret(text)
+	return nil
+}
+
+// SecretProvider is used to look up secrets by name
+type SecretProvider interface {
+	// Secret returns ErrSecretNotFound as the error cause if the secret does not exist, i.e., use `errors.Cause()`
+	// (https://godoc.org/github.com/pkg/errors#Cause) to check for it.
+	Secret(name string) (Secret, error)
+}
+
+// ErrSecretNotFound is returned by a SecretProvider when the secret does not exist
+var ErrSecretNotFound = errors.New("secret not found")
+
+// NewFileSecretProvider returns a SecretProvider that reads secrets from files in the specified directory, where the
+// secret name is the file name. This supports secrets that are mounted as a volume, e.g., Kubernetes Secrets.
+//
+// Trailing newlines are trimmed from the secret file content.
+func NewFileSecretProvider(dir string) SecretProvider {
+	return fileSecretProvider(dir)
+}
+
+type fileSecretProvider string
+
+func (dir fileSecretProvider) Secret(name string) (Secret, error) {
+	if name == "" || name != filepath.Base(name) {
+		return Secret{}, fmt.Errorf("invalid secret name: %q", name)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(string(dir), name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Secret{}, errors.Wrap(ErrSecretNotFound, name)
+		}
+		return Secret{}, err
+	}
+	return NewSecret([]byte(strings.TrimRight(string(data), "\r\n"))), nil
+}
+
+// NewEnvSecretProvider returns a SecretProvider that reads secrets from env vars. The env var name is the secret name
+// in upper case, with the specified prefix, e.g., if the prefix is "APP12X", then the "db_password" secret is looked
+// up using the "APP12X_DB_PASSWORD" env var.
+func NewEnvSecretProvider(prefix string) SecretProvider {
+	return envSecretProvider(prefix)
+}
+
+type envSecretProvider string
+
+func (prefix envSecretProvider) Secret(name string) (Secret, error) {
+	key := strings.ToUpper(name)
+	if prefix != "" {
+		key = strings.ToUpper(string(prefix)) + "_" + key
+	}
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return Secret{}, errors.Wrap(ErrSecretNotFound, name)
+	}
+	return NewSecret([]byte(value)), nil
+}
+
+// DefaultSecretWatchInterval is the default interval used to check secrets for rotations
+const DefaultSecretWatchInterval = 10 * time.Second
+
+// SecretRotation is published to subscribers when a secret value has changed.
+type SecretRotation struct {
+	Name   string
+	Secret Secret
+}
+
+// SecretRotationSubscription wraps the channel used to notify subscribers
+type SecretRotationSubscription struct {
+	ch chan SecretRotation
+}
+
+// Chan returns the chan in read-only mode
+func (s SecretRotationSubscription) Chan() <-chan SecretRotation {
+	return s.ch
+}
+
+// SubscribeForSecretRotations is used to subscribe for rotations of secrets whose names match the specified filter.
+// If the filter is nil, then all secret rotations are published.
+//
+// Only secrets that have been looked up via the app provided SecretProvider are watched for rotations.
+type SubscribeForSecretRotations func(filter func(name string) bool) SecretRotationSubscription
+
+// SecretRotatedEvent is logged when a secret rotation is detected. Only the secret name is logged.
+//
+// 	type Data struct {
+//		Name string
+//	}
+const SecretRotatedEvent = "01M51PZ6Z2BQKT2Z1202E971PR"
+
+// secretService wraps the registered SecretProvider and watches the secrets that have been looked up for rotations.
+type secretService struct {
+	sync.Mutex
+	provider      SecretProvider
+	secrets       map[string]Secret
+	subscriptions map[chan SecretRotation]func(name string) bool
+
+	watchInterval time.Duration
+	stop          chan struct{}
+	stopOnce      sync.Once
+
+	logRotated eventlog.Logger
+}
+
+func newSecretService(provider SecretProvider, watchInterval time.Duration, logger *zerolog.Logger) *secretService {
+	return &secretService{
+		provider:      provider,
+		secrets:       make(map[string]Secret),
+		subscriptions: make(map[chan SecretRotation]func(name string) bool),
+		watchInterval: watchInterval,
+		stop:          make(chan struct{}),
+		logRotated:    eventlog.NewLogger(SecretRotatedEvent, logger, zerolog.InfoLevel),
+	}
+}
+
+// Secret implements SecretProvider
+func (s *secretService) Secret(name string) (Secret, error) {
+	secret, err := s.provider.Secret(name)
+	if err != nil {
+		return secret, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.secrets[name] = secret
+	return secret, nil
+}
+
+func (s *secretService) subscribe(filter func(name string) bool) SecretRotationSubscription {
+	s.Lock()
+	defer s.Unlock()
+	ch := make(chan SecretRotation)
+	select {
+	case <-s.stop:
+		close(ch)
+		return SecretRotationSubscription{ch}
+	default:
+	}
+	if filter == nil {
+		filter = func(string) bool { return true }
+	}
+	s.subscriptions[ch] = filter
+	return SecretRotationSubscription{ch}
+}
+
+// checkRotations looks up the watched secrets and publishes the secrets whose values have changed.
+//
+// The secrets are looked up without holding the lock, i.e., slow providers do not block secret lookups and
+// subscriptions. The lock is only held to swap in the rotated secret values.
+func (s *secretService) checkRotations() {
+	s.Lock()
+	watched := make(map[string]Secret, len(s.secrets))
+	for name, secret := range s.secrets {
+		watched[name] = secret
+	}
+	s.Unlock()
+
+	for name, secret := range watched {
+		latest, err := s.provider.Secret(name)
+		if err != nil || latest.Equal(secret) {
+			// if the secret lookup fails, e.g., while the secret volume is being updated, then retain the current secret
+			continue
+		}
+		if subscriptions, rotated := s.rotate(name, latest); rotated {
+			s.logRotated(secretRotated(name), "secret rotated")
+			rotation := SecretRotation{Name: name, Secret: latest}
+			for _, ch := range subscriptions {
+				go func(ch chan<- SecretRotation) {
+					select {
+					case <-s.stop:
+					case ch <- rotation:
+					}
+				}(ch)
+			}
+		}
+	}
+}
+
+// rotate swaps in the latest secret value, and returns the subscriptions for the secret. If the secret value is
+// already current, e.g., because the secret was looked up while the secret was being checked, then false is returned.
+func (s *secretService) rotate(name string, latest Secret) ([]chan SecretRotation, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if s.secrets[name].Equal(latest) {
+		return nil, false
+	}
+	s.secrets[name] = latest
+	var subscriptions []chan SecretRotation
+	for ch, filter := range s.subscriptions {
+		if filter(name) {
+			subscriptions = append(subscriptions, ch)
+		}
+	}
+	return subscriptions, true
+}
+
+func (s *secretService) start(context.Context) error {
+	if s.watchInterval <= 0 {
+		return nil
+	}
+	go func() {
+		ticker := time.NewTicker(s.watchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.checkRotations()
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *secretService) shutdown(context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	return nil
+}
+
+func provideSecretService(s *secretService) (SecretProvider, SubscribeForSecretRotations) {
+	return s, s.subscribe
+}
+
+func startSecretService(s *secretService, lc fx.Lifecycle) {
+	lc.Append(fx.Hook{
+		OnStart: s.start,
+		OnStop:  s.shutdown,
+	})
+}
+
+type secretRotated string
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (name secretRotated) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("name", string(name))
+}
@@ -0,0 +1,300 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/oysterpack/andiamo/pkg/eventlog"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecret_Redaction(t *testing.T) {
+	const value = "s3cr3t"
+	secret := fxapp.NewSecret([]byte(value))
+	if secret.Value() != value {
+		t.Errorf("*** secret value did not match: %q", secret.Value())
+	}
+
+	type Credentials struct {
+		User     string
+		Password fxapp.Secret
+		token    fxapp.Secret
+		tokens   []fxapp.Secret
+	}
+	creds := Credentials{"admin", secret, secret, []fxapp.Secret{secret}}
+
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x", "%X", "%d", "%T"} {
+		for _, v := range []interface{}{secret, &secret, creds, &creds} {
+			if s := fmt.Sprintf(format, v); strings.Contains(s, value) || strings.Contains(s, fmt.Sprintf("%x", value)) {
+				t.Errorf("*** secret was rendered: %s -> %s", format, s)
+			}
+		}
+	}
+	if s := secret.String(); strings.Contains(s, value) {
+		t.Errorf("*** secret was rendered: %s", s)
+	}
+
+	jsonBytes, err := json.Marshal(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(jsonBytes), value) {
+		t.Errorf("*** secret was rendered: %s", jsonBytes)
+	}
+
+	buf := new(strings.Builder)
+	logger := eventlog.NewZeroLogger(buf)
+	logger.Info().Object("secret", secret).Interface("creds", creds).Msg("")
+	if strings.Contains(buf.String(), value) {
+		t.Errorf("*** secret was logged: %s", buf)
+	}
+	t.Log(buf)
+
+	if !secret.Equal(fxapp.NewSecret([]byte(value))) {
+		t.Error("*** secrets should be equal")
+	}
+	if (fxapp.Secret{}).Equal(secret) || !(fxapp.Secret{}).IsZero() {
+		t.Error("*** zero secret did not match")
+	}
+}
+
+func TestSecretProviders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fxapp-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeConfigFile(t, dir, "db_password", "s3cr3t\n")
+
+	setenv("DB_PASSWORD", "s3cr3t")
+	defer unsetenv("DB_PASSWORD")
+
+	providers := map[string]fxapp.SecretProvider{
+		"file": fxapp.NewFileSecretProvider(dir),
+		"env":  fxapp.NewEnvSecretProvider(fxapp.EnvconfigPrefix),
+	}
+	for name, provider := range providers {
+		provider := provider
+		t.Run(name, func(t *testing.T) {
+			secret, err := provider.Secret("db_password")
+			switch {
+			case err != nil:
+				t.Errorf("*** failed to lookup secret: %v", err)
+			case secret.Value() != "s3cr3t":
+				t.Errorf("*** secret value did not match: %q", secret.Value())
+			}
+
+			_, err = provider.Secret("api_token")
+			if errors.Cause(err) != fxapp.ErrSecretNotFound {
+				t.Errorf("*** ErrSecretNotFound should have been returned: %v", err)
+			}
+		})
+	}
+
+	if _, err := fxapp.NewFileSecretProvider(dir).Secret("../db_password"); err == nil {
+		t.Error("*** secret names that are paths should be rejected")
+	}
+}
+
+func TestBuilder_SecretProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fxapp-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeConfigFile(t, dir, "db_password", "s3cr3t")
+	writeConfigFile(t, dir, "api_token", "t0k3n")
+
+	buf := fxapptest.NewSyncLog()
+	var subscribe fxapp.SubscribeForSecretRotations
+	app, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		LogWriter(buf).
+		SecretProvider(fxapp.NewFileSecretProvider(dir)).
+		SetSecretWatchInterval(10 * time.Millisecond).
+		Invoke(func(secrets fxapp.SecretProvider, logger *zerolog.Logger) error {
+			for _, name := range []string{"db_password", "api_token"} {
+				secret, err := secrets.Secret(name)
+				if err != nil {
+					return err
+				}
+				logger.Info().Interface(name, secret).Msgf("%v %#v", secret, secret)
+			}
+			return nil
+		}).
+		Populate(&subscribe).
+		DisableHTTPServer().
+		Build()
+	if err != nil {
+		t.Fatalf("*** app failed to build: %v", err)
+	}
+
+	subscription := subscribe(func(name string) bool { return name == "db_password" })
+	go app.Run()
+	defer func() {
+		app.Shutdown()
+		<-app.Done()
+	}()
+	<-app.Started()
+
+	writeConfigFile(t, dir, "api_token", "n3w-t0k3n")
+	writeConfigFile(t, dir, "db_password", "n3w-s3cr3t")
+	select {
+	case rotation := <-subscription.Chan():
+		if rotation.Name != "db_password" || rotation.Secret.Value() != "n3w-s3cr3t" {
+			t.Errorf("*** secret rotation did not match: %v", rotation)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("*** timed out waiting for secret rotation")
+	}
+
+	if strings.Contains(buf.String(), "s3cr3t") || strings.Contains(buf.String(), "t0k3n") {
+		t.Errorf("*** secret was logged:\n%v", buf)
+	}
+	if !strings.Contains(buf.String(), fxapp.SecretRotatedEvent) {
+		t.Errorf("*** SecretRotatedEvent was not logged:\n%v", buf)
+	}
+}
+
+func TestBuilder_Config_SecretField(t *testing.T) {
+	type Config struct {
+		Password fxapp.Secret `envconfig:"DB_PASSWORD"`
+	}
+
+	setenv("DB_PASSWORD", "s3cr3t")
+	defer unsetenv("DB_PASSWORD")
+
+	buf := fxapptest.NewSyncLog()
+	var config Config
+	_, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		LogWriter(buf).
+		Config(&Config{}).
+		Invoke(func() {}).
+		Populate(&config).
+		DisableHTTPServer().
+		Build()
+	if err != nil {
+		t.Fatalf("*** app failed to build: %v", err)
+	}
+	if config.Password.Value() != "s3cr3t" {
+		t.Errorf("*** secret config field did not match: %v", config.Password.Value())
+	}
+	if strings.Contains(buf.String(), "s3cr3t") {
+		t.Errorf("*** secret was logged:\n%v", buf)
+	}
+}
+
+// Secrets must not leak via the health check registration errors or the app info that is logged when the app is
+// initialized, i.e., the `InitializedEvent`.
+func TestSecret_NotLeaked(t *testing.T) {
+	type Config struct {
+		Password fxapp.Secret `envconfig:"DB_PASSWORD"`
+	}
+	type DBOpts struct {
+		Password fxapp.Secret
+		password fxapp.Secret
+	}
+
+	const plaintext = "s3cr3t-4f9e1c"
+	setenv("DB_PASSWORD", plaintext)
+	defer unsetenv("DB_PASSWORD")
+	secret := fxapp.NewSecret([]byte(plaintext))
+	newBuilder := func(buf *fxapptest.SyncLog) fxapp.Builder {
+		return fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+			LogWriter(buf).
+			Config(&Config{}).
+			EnableProfiling(fxapp.ProfilingOpts{Token: secret}).
+			Provide(func() DBOpts { return DBOpts{secret, secret} })
+	}
+	checkNotLeaked := func(t *testing.T, values ...interface{}) {
+		for _, value := range values {
+			for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+				if text := fmt.Sprintf(format, value); strings.Contains(text, plaintext) {
+					t.Errorf("*** secret was leaked via %q: %s", format, text)
+				}
+			}
+		}
+	}
+
+	t.Run("health check registration errors", func(t *testing.T) {
+		check := health.Check{
+			ID:          ulids.MustNew().String(),
+			Description: "DB",
+			RedImpact:   "app is unavailable",
+		}
+		for _, register := range []func(health.Register, Config, DBOpts) error{
+			// invalid checker opts
+			func(register health.Register, config Config, opts DBOpts) error {
+				return register(check, health.CheckerOpts{Timeout: health.MaxTimeout + time.Second}, func() (health.Status, error) {
+					return health.Green, fmt.Errorf("%v:%v", config.Password, opts)
+				})
+			},
+			// invalid check
+			func(register health.Register, config Config, opts DBOpts) error {
+				invalidCheck := check
+				invalidCheck.Description = ""
+				return register(invalidCheck, health.CheckerOpts{}, func() (health.Status, error) {
+					return health.Green, fmt.Errorf("%v:%v", config.Password, opts)
+				})
+			},
+		} {
+			buf := fxapptest.NewSyncLog()
+			_, err := newBuilder(buf).Invoke(register).DisableHTTPServer().Build()
+			if err == nil {
+				t.Fatal("*** app should have failed to build because the health check registration failed")
+			}
+			checkNotLeaked(t, err)
+			if strings.Contains(buf.String(), plaintext) {
+				t.Errorf("*** secret was logged:\n%v", buf)
+			}
+		}
+	})
+
+	t.Run("app info", func(t *testing.T) {
+		buf := fxapptest.NewSyncLog()
+		var config Config
+		var opts DBOpts
+		app, err := newBuilder(buf).
+			Invoke(func(DBOpts) {}).
+			Populate(&config, &opts).
+			DisableHTTPServer().
+			Build()
+		if err != nil {
+			t.Fatalf("*** app failed to build: %v", err)
+		}
+		if config.Password.Value() != plaintext || opts.Password.Value() != plaintext {
+			t.Error("*** secrets should have been injected")
+		}
+		if len(fxapptest.EventsNamed(buf, fxapp.InitializedEvent)) != 1 {
+			t.Errorf("*** InitializedEvent was not logged:\n%v", buf)
+		}
+		if strings.Contains(buf.String(), plaintext) {
+			t.Errorf("*** secret was logged:\n%v", buf)
+		}
+		checkNotLeaked(t, app, config, &config, opts, &opts, []interface{}{opts})
+	})
+}
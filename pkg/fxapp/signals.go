@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"github.com/oysterpack/andiamo/pkg/eventlog"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"github.com/rs/zerolog"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+)
+
+// signal related events
+const (
+	// DiagnosticsEvent is logged when the app receives a SIGUSR1 signal.
+	//
+	// 	type Data struct {
+	//		Goroutines     string // goroutine stack traces
+	//		GoroutineCount uint `json:"goroutine_count"`
+	//		Health         []struct {
+	//			ID     string
+	//			Status uint8
+	//			Start  uint
+	//			Dur    uint
+	//			Err    string `json:"e"`
+	//		}
+	//		ReadinessCount uint `json:"readiness_count"` // readiness wait group count
+	//	}
+	DiagnosticsEvent = "01M51Q7H1PSMNMBDW3AF3H9GKC"
+	// SignalEvent is logged when a signal is received, before the signal handlers are run. If the signal is received
+	// while the app is not running, i.e., while the app is starting or stopping, then the signal is dropped.
+	//
+	// 	type Data struct {
+	//		Signal  string
+	//		Dropped bool
+	//	}
+	SignalEvent = "01M51Q7H1P96CZWN57B4Y5KK6C"
+)
+
+// signalHandlers maps signals to the handlers that are registered for the signal
+type signalHandlers map[os.Signal][]func(App)
+
+func (h signalHandlers) add(sig os.Signal, handlers ...func(App)) {
+	h[sig] = append(h[sig], handlers...)
+}
+
+func (h signalHandlers) signals() []os.Signal {
+	signals := make([]os.Signal, 0, len(h))
+	for sig := range h {
+		signals = append(signals, sig)
+	}
+	return signals
+}
+
+// signalHandling runs the signal handlers. Signals are handled sequentially in the order they are received, and the
+// signal's handlers are run in the order they were registered.
+//
+// The signals are registered for the whole time the app is run, i.e., from when the app starts running until the app is
+// done. Otherwise, a signal that is received while the app is starting or stopping would be handled using the signal's
+// default behavior, which for most signals, e.g., SIGHUP and SIGUSR1, terminates the process. Signal handlers only run
+// while the app is running, i.e., after the app has started and before the app is stopping - signals that are received
+// outside that window are logged and dropped.
+type signalHandling struct {
+	handlers signalHandlers
+	logger   *zerolog.Logger
+
+	sync.Mutex
+	app  App // set when the app has started, and cleared when the app is stopping
+	done chan struct{}
+}
+
+func newSignalHandling(handlers signalHandlers, logger *zerolog.Logger) *signalHandling {
+	return &signalHandling{
+		handlers: handlers,
+		logger:   logger,
+		done:     make(chan struct{}),
+	}
+}
+
+// listen is invoked when the app starts running. It registers the signals until the app is done - see `close()`
+func (s *signalHandling) listen() {
+	if len(s.handlers) == 0 {
+		return
+	}
+	logSignal := eventlog.NewLogger(SignalEvent, s.logger, zerolog.InfoLevel)
+	logSignalDropped := eventlog.NewLogger(SignalEvent, s.logger, zerolog.WarnLevel)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, s.handlers.signals()...)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-s.done:
+				return
+			case sig := <-signals:
+				s.Lock()
+				if s.app == nil {
+					s.Unlock()
+					logSignalDropped(signalReceived{Signal: sig, dropped: true}, "signal received while the app is not running - signal dropped")
+					continue
+				}
+				logSignal(signalReceived{Signal: sig}, "signal received")
+				for _, handler := range s.handlers[sig] {
+					handler(s.app)
+				}
+				s.Unlock()
+			}
+		}
+	}()
+}
+
+// start is invoked after the app has started
+func (s *signalHandling) start(app App) {
+	s.Lock()
+	defer s.Unlock()
+	s.app = app
+}
+
+// stop is invoked when the app is stopping. It waits for any running signal handlers to complete.
+func (s *signalHandling) stop() {
+	s.Lock()
+	defer s.Unlock()
+	s.app = nil
+}
+
+// close is invoked when the app is done. The signals are unregistered.
+func (s *signalHandling) close() {
+	s.Lock()
+	defer s.Unlock()
+	s.app = nil
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+}
+
+type signalReceived struct {
+	os.Signal
+	dropped bool
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (event signalReceived) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("signal", event.Signal.String())
+	if event.dropped {
+		e.Bool("dropped", true)
+	}
+}
+
+// reloadConfigsOnSignal is the built-in SIGHUP handler
+func reloadConfigsOnSignal(configs *configService) func(App) {
+	return func(App) {
+		configs.reload(configReloadTriggerSignal)
+	}
+}
+
+// logDiagnosticsOnSignal is the built-in SIGUSR1 handler
+func logDiagnosticsOnSignal(checkResults health.CheckResults, readiness ReadinessWaitGroup, logger *zerolog.Logger) func(App) {
+	logDiagnostics := eventlog.NewLogger(DiagnosticsEvent, logger, zerolog.InfoLevel)
+	return func(App) {
+		buf := make([]byte, 1024*1024)
+		buf = buf[:runtime.Stack(buf, true)]
+		logDiagnostics(diagnostics{
+			goroutines:     string(buf),
+			goroutineCount: runtime.NumGoroutine(),
+			health:         <-checkResults(nil),
+			readinessCount: readiness.Count(),
+		}, "diagnostics")
+	}
+}
+
+type diagnostics struct {
+	goroutines     string
+	goroutineCount int
+	health         []health.Result
+	readinessCount uint
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (event diagnostics) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("goroutines", event.goroutines)
+	e.Int("goroutine_count", event.goroutineCount)
+	results := zerolog.Arr()
+	for _, result := range event.health {
+		results.Object(&healthCheckResult{result})
+	}
+	e.Array("health", results)
+	e.Uint("readiness_count", event.readinessCount)
+}
@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"go.uber.org/fx"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func signalProcess(t *testing.T, sig os.Signal) {
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := process.Signal(sig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuilder_HandleSignal(t *testing.T) {
+	handled := make(chan fxapp.App, 2)
+	app, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		HandleSignal(syscall.SIGUSR2, func(app fxapp.App) { handled <- app }).
+		// SIGUSR2 is mapped to shutdown the app
+		HandleSignal(syscall.SIGUSR2, func(app fxapp.App) { app.Shutdown() }).
+		Invoke(func() {}).
+		DisableHTTPServer().
+		Build()
+	if err != nil {
+		t.Fatalf("*** app failed to build: %v", err)
+	}
+
+	go app.Run()
+	<-app.Started()
+	signalProcess(t, syscall.SIGUSR2)
+
+	select {
+	case handledApp := <-handled:
+		if handledApp.ID() != app.ID() {
+			t.Error("*** signal handler should have been passed the app")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("*** timed out waiting for signal to be handled")
+	}
+
+	select {
+	case <-app.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("*** the app should have been shutdown by the signal handler")
+	}
+}
+
+func TestApp_DiagnosticsSignal(t *testing.T) {
+	buf := fxapptest.NewSyncLog()
+	app, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		LogWriter(buf).
+		Invoke(func() {}).
+		DisableHTTPServer().
+		Build()
+	if err != nil {
+		t.Fatalf("*** app failed to build: %v", err)
+	}
+
+	go app.Run()
+	<-app.Ready()
+	defer func() {
+		app.Shutdown()
+		<-app.Done()
+	}()
+	signalProcess(t, syscall.SIGUSR1)
+
+	var events []string
+	for i := 0; i < 100 && len(events) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		events = logEventNames(t, buf)[fxapp.DiagnosticsEvent]
+	}
+	if len(events) == 0 {
+		t.Fatalf("*** DiagnosticsEvent was not logged:\n%v", buf)
+	}
+
+	type LogEvent struct {
+		Data struct {
+			Goroutines     string
+			GoroutineCount int `json:"goroutine_count"`
+			Health         []interface{}
+			ReadinessCount *uint `json:"readiness_count"`
+		} `json:"d"`
+	}
+	var logEvent LogEvent
+	if err := json.Unmarshal([]byte(events[0]), &logEvent); err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case logEvent.Data.Goroutines == "" || logEvent.Data.GoroutineCount == 0:
+		t.Errorf("*** goroutines were not logged: %v", events[0])
+	case logEvent.Data.ReadinessCount == nil || *logEvent.Data.ReadinessCount != 0:
+		t.Errorf("*** readiness count did not match: %v", events[0])
+	}
+	if len(logEventNames(t, buf)[fxapp.SignalEvent]) == 0 {
+		t.Errorf("*** SignalEvent was not logged:\n%v", buf)
+	}
+}
+
+// Signals that are received while the app is starting are dropped, i.e., the signal's default behavior, which for SIGHUP
+// terminates the process, is not applied.
+func TestApp_SignalDuringStartup(t *testing.T) {
+	buf := fxapptest.NewSyncLog()
+	handled := make(chan struct{}, 1)
+	starting, start := make(chan struct{}), make(chan struct{})
+	app, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		HandleSignal(syscall.SIGHUP, func(fxapp.App) { handled <- struct{}{} }).
+		Invoke(func(lc fx.Lifecycle) {
+			lc.Append(fx.Hook{
+				OnStart: func(context.Context) error {
+					close(starting)
+					<-start
+					return nil
+				},
+			})
+		}).
+		LogWriter(buf).
+		DisableHTTPServer().
+		Build()
+	if err != nil {
+		t.Fatalf("*** app failed to build: %v", err)
+	}
+
+	go app.Run()
+	<-starting
+	signalProcess(t, syscall.SIGHUP)
+	entry, err := fxapptest.AwaitEvent(buf, fxapp.SignalEvent, 5*time.Second)
+	close(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data struct{ Dropped bool }
+	if err := entry.DecodeData(&data); err != nil {
+		t.Fatal(err)
+	}
+	if !data.Dropped {
+		t.Errorf("*** signal received while the app is starting should have been dropped: %v", entry)
+	}
+
+	<-app.Ready()
+	defer func() {
+		app.Shutdown()
+		<-app.Done()
+	}()
+	select {
+	case <-handled:
+		t.Error("*** signal handler should not have been run for the dropped signal")
+	default:
+	}
+	// signals are handled once the app has started
+	signalProcess(t, syscall.SIGHUP)
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("*** timed out waiting for signal to be handled")
+	}
+}
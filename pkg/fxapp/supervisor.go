@@ -0,0 +1,280 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"errors"
+	"github.com/oklog/ulid"
+	"github.com/oysterpack/andiamo/pkg/eventlog"
+	"github.com/rs/zerolog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// supervisor defaults
+const (
+	DefaultSupervisorInitialBackoff = time.Second
+	DefaultSupervisorMaxBackoff     = time.Minute
+	DefaultSupervisorBackoffFactor  = 2.0
+	DefaultSupervisorMaxRestarts    = 5
+)
+
+// supervisor related events
+const (
+	// RestartingEvent is logged when the supervisor restarts the app after a failure. The event is logged using the new
+	// app instance ID, before the backoff is applied.
+	//
+	// 	type Data struct {
+	//		PrevInstanceID string `json:"prev_i"` // the app instance that failed
+	//		Restart        uint   // restart count, starting at 1
+	//		Backoff        uint   // how long the supervisor waits before the app is rebuilt
+	//		Err            string `json:"e"`
+	//	}
+	RestartingEvent = "01M51QCVPY720SEPSNTEW9WXZ5"
+	// RestartBudgetExhaustedEvent is logged when the app fails and the max number of restarts have been used up. The
+	// event is logged using the failed app instance ID.
+	//
+	// 	type Data struct {
+	//		Restarts uint
+	//		Err      string `json:"e"`
+	//	}
+	RestartBudgetExhaustedEvent = "01M51QCVPYA5RMWP7JEHY3CFSW"
+)
+
+// ErrSupervisorBuilderNotSupported is returned by NewSupervisor if the Builder was not created via NewBuilder.
+var ErrSupervisorBuilderNotSupported = errors.New("supervisor requires a Builder that was created via NewBuilder")
+
+// SupervisorOpts configures the supervisor restart policy. Zero values are replaced with the defaults.
+type SupervisorOpts struct {
+	// InitialBackoff is how long the supervisor waits before the first restart - default = DefaultSupervisorInitialBackoff
+	InitialBackoff time.Duration
+	// MaxBackoff caps the backoff - default = DefaultSupervisorMaxBackoff
+	MaxBackoff time.Duration
+	// BackoffFactor is used to grow the backoff exponentially after each restart - default = DefaultSupervisorBackoffFactor
+	BackoffFactor float64
+	// MaxRestarts is the restart budget - default = DefaultSupervisorMaxRestarts
+	MaxRestarts uint
+}
+
+// backoff returns the backoff for the specified restart, where restarts start at 1
+func (opts SupervisorOpts) backoff(restart uint) time.Duration {
+	backoff := float64(opts.InitialBackoff)
+	for i := uint(1); i < restart && backoff < float64(opts.MaxBackoff); i++ {
+		backoff *= opts.BackoffFactor
+	}
+	if backoff > float64(opts.MaxBackoff) {
+		return opts.MaxBackoff
+	}
+	return time.Duration(backoff)
+}
+
+func (opts SupervisorOpts) withDefaults() SupervisorOpts {
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = DefaultSupervisorInitialBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultSupervisorMaxBackoff
+	}
+	if opts.MaxBackoff < opts.InitialBackoff {
+		opts.MaxBackoff = opts.InitialBackoff
+	}
+	if opts.BackoffFactor < 1 {
+		opts.BackoffFactor = DefaultSupervisorBackoffFactor
+	}
+	if opts.MaxRestarts == 0 {
+		opts.MaxRestarts = DefaultSupervisorMaxRestarts
+	}
+	return opts
+}
+
+// Supervisor runs an app in-process, and restarts the app when it fails. An App can only be run once, thus the supervisor
+// rebuilds the app from its Builder with a new InstanceID on each restart.
+//
+// The app is restarted when it fails to start or stop. If the app fails to build, e.g., because the config is invalid,
+// then the app is not restarted because rebuilding the app would fail the same way. If the app shuts down cleanly, then
+// the supervisor is done.
+//
+// If a SIGINT or SIGTERM signal is received while the supervisor is waiting to restart the app, then the supervisor is
+// shutdown.
+type Supervisor interface {
+	// Run builds and runs the app, and blocks until the app shuts down cleanly, the supervisor is shutdown, or the restart
+	// budget is exhausted. If the restart budget is exhausted, then the last app error is returned. If the app fails to
+	// build, then the build error is returned.
+	Run() error
+	// Shutdown stops supervising and shuts down the current app instance
+	Shutdown()
+	// App returns the current app instance, which will be nil until the first app instance is built
+	App() App
+	// Restarts returns the number of times the app has been restarted
+	Restarts() uint
+}
+
+// NewSupervisor returns a new Supervisor for the app that is constructed by the specified Builder.
+func NewSupervisor(appBuilder Builder, opts SupervisorOpts) (Supervisor, error) {
+	b, ok := appBuilder.(*builder)
+	if !ok {
+		return nil, ErrSupervisorBuilderNotSupported
+	}
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+	return &supervisor{
+		builder: b,
+		opts:    opts.withDefaults(),
+		stop:    make(chan struct{}),
+	}, nil
+}
+
+type supervisor struct {
+	builder *builder
+	opts    SupervisorOpts
+
+	sync.Mutex
+	app      App
+	restarts uint
+	stop     chan struct{}
+}
+
+func (s *supervisor) Run() error {
+	// The supervisor owns the termination signals for its whole run. Each app instance also registers for the termination
+	// signals, but the registrations are never released by fx, i.e., when the app fails, the signals are delivered to
+	// channels that are no longer watched. Without the supervisor's own registration, a termination signal that is
+	// received while the supervisor is backing off would be ignored.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	instance := s.builder.newInstance()
+	for {
+		app, err := instance.Build()
+		if err != nil {
+			// build errors, e.g., invalid config, are not recoverable - rebuilding the app would fail the same way
+			return err
+		}
+		err = s.run(app)
+		if err == nil || s.stopped() {
+			return err
+		}
+
+		s.Lock()
+		if s.restarts >= s.opts.MaxRestarts {
+			s.Unlock()
+			logEvent := eventlog.NewLogger(RestartBudgetExhaustedEvent, instance.newZerolog(), zerolog.ErrorLevel)
+			logEvent(restartBudgetExhausted{s.opts.MaxRestarts, err}, "app restart budget exhausted")
+			return err
+		}
+		s.restarts++
+		restart := s.restarts
+		s.Unlock()
+
+		prevInstanceID := instance.instanceID
+		instance = s.builder.newInstance()
+		backoff := s.opts.backoff(restart)
+		logEvent := eventlog.NewLogger(RestartingEvent, instance.newZerolog(), zerolog.WarnLevel)
+		logEvent(restarting{prevInstanceID, restart, backoff, err}, "app restarting")
+		select {
+		case <-s.stop:
+			return err
+		case <-signals:
+			s.Shutdown()
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// run runs the app instance
+func (s *supervisor) run(app App) error {
+	s.Lock()
+	if s.stopped() {
+		s.Unlock()
+		return nil
+	}
+	s.app = app
+	s.Unlock()
+	return app.Run()
+}
+
+func (s *supervisor) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *supervisor) Shutdown() {
+	s.Lock()
+	defer s.Unlock()
+	if s.stopped() {
+		return
+	}
+	close(s.stop)
+	if s.app == nil {
+		return
+	}
+	// the app can only be shutdown after it has started
+	go func(app App) {
+		select {
+		case <-app.Started():
+			app.Shutdown()
+		case <-app.Done():
+		}
+	}(s.app)
+}
+
+func (s *supervisor) App() App {
+	s.Lock()
+	defer s.Unlock()
+	return s.app
+}
+
+func (s *supervisor) Restarts() uint {
+	s.Lock()
+	defer s.Unlock()
+	return s.restarts
+}
+
+type restarting struct {
+	prevInstanceID InstanceID
+	restart        uint
+	backoff        time.Duration
+	err            error
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (event restarting) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("prev_i", ulid.ULID(event.prevInstanceID).String())
+	e.Uint("restart", event.restart)
+	e.Dur("backoff", event.backoff)
+	e.Err(event.err)
+}
+
+type restartBudgetExhausted struct {
+	restarts uint
+	err      error
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (event restartBudgetExhausted) MarshalZerologObject(e *zerolog.Event) {
+	e.Uint("restarts", event.restarts)
+	e.Err(event.err)
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/oklog/ulid"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"go.uber.org/fx"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// returns a func that fails to start the app until it has been invoked the specified number of times
+func failToStart(failures int32) func(lc fx.Lifecycle) {
+	var count int32
+	return func(lc fx.Lifecycle) {
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				if atomic.AddInt32(&count, 1) <= failures {
+					return errors.New("dependency is unavailable")
+				}
+				return nil
+			},
+		})
+	}
+}
+
+func TestSupervisor_Restart(t *testing.T) {
+	buf := fxapptest.NewSyncLog()
+	builder := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Invoke(failToStart(2)).
+		DisableHTTPServer().
+		LogWriter(buf)
+	supervisor, err := fxapp.NewSupervisor(builder, fxapp.SupervisorOpts{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxRestarts:    3,
+	})
+	if err != nil {
+		t.Fatalf("*** supervisor failed to build: %v", err)
+	}
+
+	runErr := make(chan error)
+	go func() { runErr <- supervisor.Run() }()
+	// wait for the restarted app to be ready - failed app instances never become ready
+	var app fxapp.App
+	ready := false
+	for i := 0; i < 100 && !ready; i++ {
+		if app = supervisor.App(); app == nil {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		select {
+		case <-app.Ready():
+			ready = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if !ready || supervisor.Restarts() != 2 {
+		t.Fatalf("*** the app should have been restarted twice: %v", supervisor.Restarts())
+	}
+	supervisor.Shutdown()
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Errorf("*** the supervisor should have returned cleanly: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("*** timed out waiting for the supervisor to shutdown")
+	}
+
+	type LogEvent struct {
+		InstanceID string `json:"i"`
+		Data       struct {
+			PrevInstanceID string `json:"prev_i"`
+			Restart        uint
+			Backoff        uint
+			Err            string `json:"e"`
+		} `json:"d"`
+	}
+
+	events := logEventNames(t, buf)[fxapp.RestartingEvent]
+	if len(events) != 2 {
+		t.Fatalf("*** RestartingEvent should have been logged twice:\n%v", buf)
+	}
+	instanceIDs := make(map[string]bool)
+	for i, event := range events {
+		var logEvent LogEvent
+		if err := json.Unmarshal([]byte(event), &logEvent); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case logEvent.Data.Restart != uint(i+1):
+			t.Errorf("*** restart count did not match: %v", event)
+		case logEvent.Data.PrevInstanceID == "" || logEvent.Data.PrevInstanceID == logEvent.InstanceID:
+			t.Errorf("*** the previous instance ID should have been logged: %v", event)
+		case logEvent.Data.Err == "":
+			t.Errorf("*** the app error should have been logged: %v", event)
+		}
+		instanceIDs[logEvent.InstanceID] = true
+	}
+	if len(instanceIDs) != 2 || !instanceIDs[ulid.ULID(app.InstanceID()).String()] {
+		t.Errorf("*** each restart should have a new instance ID: %v : %v", instanceIDs, app.InstanceID())
+	}
+}
+
+func TestSupervisor_RestartBudgetExhausted(t *testing.T) {
+	buf := fxapptest.NewSyncLog()
+	builder := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Invoke(failToStart(10)).
+		DisableHTTPServer().
+		LogWriter(buf)
+	supervisor, err := fxapp.NewSupervisor(builder, fxapp.SupervisorOpts{
+		InitialBackoff: time.Millisecond,
+		MaxRestarts:    2,
+	})
+	if err != nil {
+		t.Fatalf("*** supervisor failed to build: %v", err)
+	}
+
+	if err := supervisor.Run(); err == nil {
+		t.Error("*** the supervisor should have returned the app error")
+	}
+	if supervisor.Restarts() != 2 {
+		t.Errorf("*** the app should have been restarted twice: %v", supervisor.Restarts())
+	}
+	events := logEventNames(t, buf)
+	if len(events[fxapp.RestartBudgetExhaustedEvent]) != 1 {
+		t.Errorf("*** RestartBudgetExhaustedEvent should have been logged:\n%v", buf)
+	}
+}
+
+func TestSupervisor_NoRestartOnBuildFailure(t *testing.T) {
+	var builds int32
+	builder := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Invoke(func() error {
+			atomic.AddInt32(&builds, 1)
+			return errors.New("invalid config")
+		}).
+		DisableHTTPServer().
+		LogWriter(fxapptest.NewSyncLog())
+	supervisor, err := fxapp.NewSupervisor(builder, fxapp.SupervisorOpts{InitialBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatalf("*** supervisor failed to build: %v", err)
+	}
+
+	if err := supervisor.Run(); err == nil {
+		t.Error("*** the supervisor should have returned the build error")
+	}
+	if supervisor.Restarts() != 0 || atomic.LoadInt32(&builds) != 1 {
+		t.Errorf("*** the app should not have been restarted after it failed to build: %v", supervisor.Restarts())
+	}
+}
+
+func TestSupervisor_SignalDuringBackoff(t *testing.T) {
+	buf := fxapptest.NewSyncLog()
+	builder := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Invoke(failToStart(1)).
+		DisableHTTPServer().
+		LogWriter(buf)
+	supervisor, err := fxapp.NewSupervisor(builder, fxapp.SupervisorOpts{InitialBackoff: time.Minute})
+	if err != nil {
+		t.Fatalf("*** supervisor failed to build: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- supervisor.Run() }()
+	for i := 0; i < 100 && len(logEventNames(t, buf)[fxapp.RestartingEvent]) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(logEventNames(t, buf)[fxapp.RestartingEvent]) == 0 {
+		t.Fatalf("*** RestartingEvent should have been logged:\n%v", buf)
+	}
+	signalProcess(t, syscall.SIGTERM)
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("*** the supervisor should have returned the app error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("*** the supervisor should have been shutdown by the signal while backing off")
+	}
+	if supervisor.Restarts() != 1 {
+		t.Errorf("*** the app should not have been restarted after the signal: %v", supervisor.Restarts())
+	}
+}
@@ -0,0 +1,280 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/oysterpack/andiamo/pkg/eventlog"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"go.uber.org/fx"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TLS defaults
+const (
+	DefaultTLSWatchInterval         = time.Minute
+	DefaultTLSExpiryYellowThreshold = 14 * 24 * time.Hour
+)
+
+// TLSOpts is used to configure TLS for the app HTTP server - see `Builder.EnableTLS()`
+type TLSOpts struct {
+	// CertFile and KeyFile are the PEM encoded certificate and private key files, e.g., mounted from a Kubernetes TLS
+	// secret.
+	CertFile, KeyFile string
+	// ClientCAFile is the optional PEM encoded CA bundle. If specified, then mutual TLS is enabled, i.e., clients are
+	// required to present a certificate that is verified against the CA bundle.
+	//
+	// Mutual TLS requires an admin HTTP server (see `AdminHTTPServer`) because Kubernetes probes and Prometheus scrapes
+	// do not present client certificates, i.e., admin endpoints must not be served by the mutual TLS server.
+	ClientCAFile string
+
+	// WatchInterval is the interval used to check the files for changes. When the files change, e.g., when cert-manager
+	// rotates the certificate, then the TLS config is reloaded. The default interval is `DefaultTLSWatchInterval`.
+	WatchInterval time.Duration
+
+	// The TLS cert expiry health check is Yellow when the certificate expires within ExpiryYellowThreshold, and Red only
+	// once the certificate has expired. The default is `DefaultTLSExpiryYellowThreshold`.
+	//
+	// There is intentionally no Red threshold before the cert expires: the default liveness policy fails liveness when
+	// any health check is Red, and restarting the app cannot renew the cert, i.e., pods would be restarted while the
+	// cert is still valid. The Yellow health check alert is used to escalate a cert that is not being renewed. For the
+	// same reason, the TLS cert expiry health check is not run on app start up, i.e., the app starts even if the cert
+	// expires soon.
+	ExpiryYellowThreshold time.Duration
+}
+
+func (opts TLSOpts) withDefaults() TLSOpts {
+	if opts.WatchInterval <= 0 {
+		opts.WatchInterval = DefaultTLSWatchInterval
+	}
+	if opts.ExpiryYellowThreshold <= 0 {
+		opts.ExpiryYellowThreshold = DefaultTLSExpiryYellowThreshold
+	}
+	return opts
+}
+
+func (opts TLSOpts) validate() error {
+	if strings.TrimSpace(opts.CertFile) == "" || strings.TrimSpace(opts.KeyFile) == "" {
+		return errors.New("TLS cert file and key file are required")
+	}
+	return nil
+}
+
+// TLSCertExpiryHealthCheckID is the built-in health check that checks the TLS certificate expiry
+const TLSCertExpiryHealthCheckID = "01M51REQZRWHMMCSWCVHAXA9DN"
+
+// TLSCertExpiryMetricID is used as the prometheus metric name for the gauge that reports when the TLS certificate
+// expires, in unix time seconds
+const TLSCertExpiryMetricID = "U01M51REQZRRJXFHDXH2BQMC69Z"
+
+// TLS related events
+const (
+	// TLSReloadedEvent is logged when the TLS config is loaded, or reloaded because the files changed.
+	//
+	// 	type Data struct {
+	//		Subject  string
+	//		NotAfter time.Time
+	//		MTLS     bool
+	//	}
+	TLSReloadedEvent = "01M51REQZRRH6Q30MN13TJYG9X"
+	// TLSReloadFailedEvent is logged when the TLS config fails to reload. The previous TLS config remains in use.
+	//
+	// 	type Data struct {
+	//		Err string `json:"e"`
+	//	}
+	TLSReloadFailedEvent = "01M51REQZR6FF774XE83Y2B9P8"
+)
+
+// tlsService loads the TLS config, and reloads it when the files change.
+type tlsService struct {
+	opts TLSOpts
+
+	current  atomic.Value // *tlsState
+	checksum string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	logReloaded, logReloadFailed eventlog.Logger
+}
+
+type tlsState struct {
+	config *tls.Config
+	leaf   *x509.Certificate
+}
+
+// newTLSService loads the TLS config - an error is returned if the TLS config fails to load
+func newTLSService(opts TLSOpts, logger *zerolog.Logger) (*tlsService, error) {
+	s := &tlsService{
+		opts:            opts,
+		stop:            make(chan struct{}),
+		logReloaded:     eventlog.NewLogger(TLSReloadedEvent, logger, zerolog.InfoLevel),
+		logReloadFailed: eventlog.NewLogger(TLSReloadFailedEvent, logger, zerolog.ErrorLevel),
+	}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// mutual returns true if mutual TLS is enabled
+func (s *tlsService) mutual() bool {
+	return s.opts.ClientCAFile != ""
+}
+
+// tlsConfig returns the server TLS config, which always uses the latest loaded TLS config
+func (s *tlsService) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &s.state().config.Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.state().config, nil
+		},
+	}
+}
+
+func (s *tlsService) state() *tlsState {
+	return s.current.Load().(*tlsState)
+}
+
+func (s *tlsService) reload() error {
+	state, err := loadTLSState(s.opts)
+	if err != nil {
+		s.logReloadFailed(eventlog.NewError(err), "TLS reload failed")
+		return err
+	}
+	s.current.Store(state)
+	s.checksum = s.filesChecksum()
+	s.logReloaded(state, "TLS reloaded")
+	return nil
+}
+
+func loadTLSState(opts TLSOpts) (*tlsState, error) {
+	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS cert: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse TLS cert: %v", err)
+	}
+	cert.Leaf = leaf
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if opts.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client CA bundle: %v", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client CA bundle contains no certificates: %v", opts.ClientCAFile)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return &tlsState{config, leaf}, nil
+}
+
+// filesChecksum is based on file content - see `configService.watchConfigFile()`
+func (s *tlsService) filesChecksum() string {
+	return configFileChecksum(s.opts.CertFile) + configFileChecksum(s.opts.KeyFile) + configFileChecksum(s.opts.ClientCAFile)
+}
+
+func (s *tlsService) watch() {
+	ticker := time.NewTicker(s.opts.WatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if s.filesChecksum() != s.checksum {
+				s.reload()
+			}
+		}
+	}
+}
+
+func (s *tlsService) start(context.Context) error {
+	go s.watch()
+	return nil
+}
+
+func (s *tlsService) shutdown(context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	return nil
+}
+
+func (s *tlsService) checkExpiry() (health.Status, error) {
+	notAfter := s.state().leaf.NotAfter
+	switch expiresIn := time.Until(notAfter); {
+	case expiresIn <= 0:
+		return health.Red, fmt.Errorf("TLS cert has expired: %v", notAfter)
+	case expiresIn <= s.opts.ExpiryYellowThreshold:
+		return health.Yellow, fmt.Errorf("TLS cert expires in %s: %v", expiresIn.Round(time.Minute), notAfter)
+	default:
+		return health.Green, nil
+	}
+}
+
+// startTLSService watches the TLS files for changes, and registers the TLS cert expiry gauge and health check
+func startTLSService(s *tlsService, register health.Register, registerer prometheus.Registerer, lc fx.Lifecycle) error {
+	err := registerer.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: TLSCertExpiryMetricID,
+		Help: "TLS cert expiry in unix time seconds",
+	}, func() float64 {
+		return float64(s.state().leaf.NotAfter.Unix())
+	}))
+	if err != nil {
+		return err
+	}
+	err = register(health.Check{
+		ID:           TLSCertExpiryHealthCheckID,
+		Description:  "TLS cert expiry",
+		RedImpact:    "TLS cert has expired, which will cause client connections to fail",
+		YellowImpact: "TLS cert will expire soon, which means it is not being renewed",
+	}, health.CheckerOpts{}, s.checkExpiry)
+	if err != nil {
+		return err
+	}
+	lc.Append(fx.Hook{
+		OnStart: s.start,
+		OnStop:  s.shutdown,
+	})
+	return nil
+}
+
+// MarshalZerologObject implements zerolog.LogObjectMarshaler interface
+func (state *tlsState) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("subject", state.leaf.Subject.String())
+	e.Time("not_after", state.leaf.NotAfter)
+	e.Bool("mtls", state.config.ClientCAs != nil)
+}
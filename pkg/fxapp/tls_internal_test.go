@@ -0,0 +1,41 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp
+
+import (
+	"crypto/x509"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"testing"
+	"time"
+)
+
+func TestTLSService_CheckExpiry(t *testing.T) {
+	t.Parallel()
+	s := &tlsService{opts: TLSOpts{}.withDefaults()}
+	for expiresIn, expected := range map[time.Duration]health.Status{
+		90 * 24 * time.Hour: health.Green,
+		7 * 24 * time.Hour:  health.Yellow,
+		// restarting the app cannot renew the cert, thus the cert is not reported Red until it has expired
+		time.Hour:  health.Yellow,
+		-time.Hour: health.Red,
+	} {
+		s.current.Store(&tlsState{leaf: &x509.Certificate{NotAfter: time.Now().Add(expiresIn)}})
+		if status, _ := s.checkExpiry(); status != expected {
+			t.Errorf("*** TLS cert expiry health check status did not match for cert that expires in %v: %v != %v", expiresIn, status, expected)
+		}
+	}
+}
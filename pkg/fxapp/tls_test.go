@@ -0,0 +1,346 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapp_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/oysterpack/andiamo/pkg/fx/health"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnableTLS(t *testing.T) {
+	t.Parallel()
+	dir := tempDir(t)
+	ca := newTestCA(t)
+	certFile, keyFile := ca.writeCert(t, dir, "server", 90*24*time.Hour, x509.ExtKeyUsageServerAuth)
+
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		EnableTLS(fxapp.TLSOpts{
+			CertFile:      certFile,
+			KeyFile:       keyFile,
+			WatchInterval: 10 * time.Millisecond,
+		}).
+		Invoke(func() {}))
+	baseURL := strings.Replace(app.BaseURL, "http://", "https://", 1)
+	client := ca.client(nil)
+
+	get := func(t *testing.T, path string) *http.Response {
+		resp, err := client.Get(baseURL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("HTTP server uses TLS", func(t *testing.T) {
+		if resp := get(t, "/"+fxapp.LivenessProbeEvent); resp.StatusCode != http.StatusOK || resp.TLS == nil {
+			t.Errorf("*** HTTPS request failed: %v", resp.StatusCode)
+		}
+		resp, err := http.Get(app.URL(fxapp.LivenessProbeEvent))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("*** plain HTTP requests should be rejected: %v", resp.StatusCode)
+		}
+		for _, entry := range fxapptest.EventsNamed(app.Log, fxapp.HTTPServerStarting) {
+			var data struct{ TLS bool }
+			if err := entry.DecodeData(&data); err != nil {
+				t.Fatal(err)
+			}
+			if !data.TLS {
+				t.Errorf("*** HTTP server TLS should have been logged: %v", entry)
+			}
+		}
+	})
+
+	t.Run("TLS cert expiry gauge and health check are registered", func(t *testing.T) {
+		resp, err := client.Get(baseURL + "/" + fxapp.MetricsEndpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		metrics, _ := ioutil.ReadAll(resp.Body)
+		if !strings.Contains(string(metrics), fxapp.TLSCertExpiryMetricID) {
+			t.Errorf("*** TLS cert expiry gauge was not registered: %s", metrics)
+		}
+		entry, err := fxapptest.AwaitEvent(app.Log, fxapp.HealthCheckResultEvent, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data struct {
+			ID     string
+			Status health.Status
+		}
+		if err := entry.DecodeData(&data); err != nil {
+			t.Fatal(err)
+		}
+		if data.ID != fxapp.TLSCertExpiryHealthCheckID || data.Status != health.Green {
+			t.Errorf("*** TLS cert expiry health check did not match: %v", entry)
+		}
+	})
+
+	t.Run("TLS cert is reloaded when the files change", func(t *testing.T) {
+		ca.writeCert(t, dir, "server", 48*time.Hour, x509.ExtKeyUsageServerAuth)
+		for i := 0; i < 100 && len(fxapptest.EventsNamed(app.Log, fxapp.TLSReloadedEvent)) < 2; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if events := fxapptest.EventsNamed(app.Log, fxapp.TLSReloadedEvent); len(events) != 2 {
+			t.Fatalf("*** TLS cert should have been reloaded: %v", events)
+		}
+		// new connections use the reloaded cert
+		client := ca.client(nil)
+		resp, err := client.Get(baseURL + "/" + fxapp.LivenessProbeEvent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if notAfter := resp.TLS.PeerCertificates[0].NotAfter; time.Until(notAfter) > 72*time.Hour {
+			t.Errorf("*** the reloaded cert was not used: %v", notAfter)
+		}
+	})
+}
+
+func TestEnableTLS_CertExpiresSoon(t *testing.T) {
+	t.Parallel()
+	dir := tempDir(t)
+	ca := newTestCA(t)
+	// the cert expires within the default Yellow threshold, i.e., the cert is pending renewal
+	certFile, keyFile := ca.writeCert(t, dir, "server", 7*24*time.Hour, x509.ExtKeyUsageServerAuth)
+
+	// the app should start, i.e., restarting the app cannot renew the cert
+	app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		EnableTLS(fxapp.TLSOpts{
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		}).
+		Invoke(func() {}))
+
+	entry, err := fxapptest.AwaitEvent(app.Log, fxapp.HealthCheckResultEvent, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data struct {
+		ID     string
+		Status health.Status
+	}
+	if err := entry.DecodeData(&data); err != nil {
+		t.Fatal(err)
+	}
+	if data.ID != fxapp.TLSCertExpiryHealthCheckID || data.Status != health.Yellow {
+		t.Errorf("*** TLS cert expiry health check should be Yellow: %v", entry)
+	}
+
+	client := ca.client(nil)
+	resp, err := client.Get(strings.Replace(app.BaseURL, "http://", "https://", 1) + "/" + fxapp.ReadyEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("*** app should be ready: %v", resp.StatusCode)
+	}
+}
+
+func TestEnableTLS_MutualTLS(t *testing.T) {
+	t.Parallel()
+	dir := tempDir(t)
+	ca := newTestCA(t)
+	certFile, keyFile := ca.writeCert(t, dir, "server", 90*24*time.Hour, x509.ExtKeyUsageServerAuth)
+	clientCertFile, clientKeyFile := ca.writeCert(t, dir, "client", 90*24*time.Hour, x509.ExtKeyUsageClientAuth)
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, ca.certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := fxapp.TLSOpts{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: caFile,
+	}
+	app := fxapptest.RunWithAdminServer(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		EnableTLS(opts).
+		Provide(func() fxapp.HTTPHandler {
+			return fxapp.NewHTTPHandler("/hello", func(writer http.ResponseWriter, _ *http.Request) {})
+		}).
+		Invoke(func() {}))
+	url := strings.Replace(app.URL("/hello"), "http://", "https://", 1)
+
+	// Then clients without a cert are rejected
+	if _, err := ca.client(nil).Get(url); err == nil {
+		t.Error("*** request without a client cert should have failed")
+	}
+	// And clients with a cert that was signed by the client CA are accepted
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ca.client(&clientCert).Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("*** response status did not match: %v", resp.StatusCode)
+	}
+
+	// And admin endpoints are served without mutual TLS, e.g., for Kubernetes probes and Prometheus scrapes
+	for _, path := range []string{fxapp.LivenessProbeEvent, fxapp.MetricsEndpoint} {
+		checkHTTPGetResponseStatusOK(t, app.URL(path))
+	}
+
+	t.Run("mutual TLS requires an admin HTTP server", func(t *testing.T) {
+		_, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+			EnableTLS(opts).
+			Invoke(func() {}).
+			LogWriter(fxapptest.NewSyncLog()).
+			Build()
+		if err == nil {
+			t.Error("*** app should have failed to build because mutual TLS requires an admin HTTP server")
+		}
+	})
+}
+
+func TestEnableTLS_InvalidCert(t *testing.T) {
+	t.Parallel()
+	dir := tempDir(t)
+	certFile := filepath.Join(dir, "server.pem")
+	if err := ioutil.WriteFile(certFile, []byte("not a cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		EnableTLS(fxapp.TLSOpts{CertFile: certFile, KeyFile: certFile}).
+		Invoke(func() {}).
+		LogWriter(fxapptest.NewSyncLog()).
+		Build()
+	if err == nil {
+		t.Error("*** app should have failed to build because the TLS cert is invalid")
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "fxapp-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+type testCA struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// writeCert writes a new cert that is signed by the CA, and returns the cert and key file paths
+func (ca *testCA) writeCert(t *testing.T, dir, name string, ttl time.Duration, usage x509.ExtKeyUsage) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(ttl),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	// the files are renamed into place, which means the watcher never reads partially written files
+	if err := ioutil.WriteFile(keyFile+".tmp", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile+".tmp", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(keyFile+".tmp", keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(certFile+".tmp", certFile); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// client returns an HTTP client that trusts the CA, and presents the client cert if specified
+func (ca *testCA) client(cert *tls.Certificate) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	config := &tls.Config{RootCAs: roots}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	return &http.Client{
+		Transport: &http.Transport{TLSClientConfig: config},
+		Timeout:   5 * time.Second,
+	}
+}
@@ -17,7 +17,9 @@
 package fxapp
 
 import (
+	"fmt"
 	"github.com/rs/zerolog"
+	"strings"
 )
 
 // LogLevel defines the supported app log levels
@@ -44,3 +46,46 @@ func (level LogLevel) ZerologLevel() zerolog.Level {
 		return zerolog.DebugLevel
 	}
 }
+
+func (level LogLevel) String() string {
+	switch level {
+	case InfoLogLevel:
+		return "info"
+	case WarnLogLevel:
+		return "warn"
+	case ErrorLogLevel:
+		return "error"
+	default:
+		return "debug"
+	}
+}
+
+// ParseLogLevel parses the log level name (case insensitive): debug, info, warn, error
+func ParseLogLevel(level string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return DebugLogLevel, nil
+	case "info":
+		return InfoLogLevel, nil
+	case "warn":
+		return WarnLogLevel, nil
+	case "error":
+		return ErrorLogLevel, nil
+	default:
+		return DebugLogLevel, fmt.Errorf("invalid log level: %q - valid values are: debug, info, warn, error", level)
+	}
+}
+
+// zerologLogLevel maps a zerolog.Level to a LogLevel. Levels that are above the error level map to ErrorLogLevel.
+func zerologLogLevel(level zerolog.Level) LogLevel {
+	switch {
+	case level <= zerolog.DebugLevel:
+		return DebugLogLevel
+	case level == zerolog.InfoLevel:
+		return InfoLogLevel
+	case level == zerolog.WarnLevel:
+		return WarnLogLevel
+	default:
+		return ErrorLogLevel
+	}
+}
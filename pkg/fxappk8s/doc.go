@@ -0,0 +1,51 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+Package fxappk8s generates Kubernetes manifests for fxapp apps.
+
+The manifests are driven by the app metadata, which is loaded by building the app's fxapp.Builder:
+	- app ID and release ID - injected via the APP12X_ID and APP12X_RELEASE_ID env vars
+	- HTTP server addresses - the probes and metrics endpoints are served by the admin HTTP server, if one is provided
+	- TLS - if the probes and metrics endpoints are served by the app HTTP server, which uses TLS, then HTTPS is used
+	- metrics endpoint path - used for the prometheus scrape annotations
+	- start timeout - used to configure the startup probe
+	- drain delay and stop timeout - used to configure the pod termination grace period
+
+The following manifests are generated:
+	- Deployment - with startup, readiness, and liveness probes, and prometheus scrape annotations
+	- Service - exposes the app HTTP server
+	- PodDisruptionBudget
+
+The probe and metrics paths are ULIDs, i.e., generating the manifests avoids ULIDs from being mistyped in hand written
+manifests.
+
+Apps register their builder via `Register()`, and the manifests are generated by `Main()`. `Main()` is meant to be
+called from the app's own k8s cmd, which registers the app, e.g., example.com/foo/cmd/foo-k8s:
+
+	func init() {
+		fxappk8s.Register("foo", foo.NewBuilder)
+	}
+
+	func main() {
+		fxappk8s.Main()
+	}
+
+The cmd only knows about the apps that it registers - any other app fails with "app is not registered".
+
+NOTE: the app metadata is loaded by building the app, which runs the app's Invoke funcs - see `LoadAppMetadata()`.
+*/
+package fxappk8s
@@ -0,0 +1,36 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxappk8s_test
+
+import (
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxappk8s"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+)
+
+// The app's k8s cmd registers the app, and then generates the manifests, e.g.,
+//
+//	foo-k8s -app foo -image example.com/foo:1.0.0
+func Example() {
+	// normally, the app is registered from an init func
+	fxappk8s.Register("foo", func() fxapp.Builder {
+		return fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+			Invoke(func() {})
+	})
+
+	fxappk8s.Main()
+}
@@ -0,0 +1,382 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxappk8s
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/oklog/ulid"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"go.uber.org/fx"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// manifest defaults
+const (
+	DefaultReplicas     = 2
+	DefaultMinAvailable = 1
+
+	// startupProbePeriod is how often the startup probe is run. The startup probe failure threshold is derived from the
+	// app start timeout.
+	startupProbePeriod = time.Second
+)
+
+// AppMetadata is the app metadata that drives the manifests
+type AppMetadata struct {
+	ID        fxapp.ID
+	ReleaseID fxapp.ReleaseID
+
+	// HTTPAddr is the app HTTP server address
+	HTTPAddr string
+	// AdminHTTPAddr is blank if the app does not provide an admin HTTP server - see `fxapp.AdminHTTPServer`
+	AdminHTTPAddr string
+	// TLS is true if the app HTTP server uses TLS - see `fxapp.Builder.EnableTLS()`
+	TLS bool
+	// MetricsPath is the prometheus metrics endpoint path - see `fxapp.PrometheusHTTPHandlerOpts`
+	MetricsPath string
+
+	StartTimeout time.Duration
+	StopTimeout  time.Duration
+	DrainDelay   time.Duration
+}
+
+type appParams struct {
+	fx.In
+
+	Server      *http.Server `optional:"true"`
+	AdminServer *http.Server `name:"admin" optional:"true"`
+
+	PrometheusHTTPHandlerOpts fxapp.PrometheusHTTPHandlerOpts `optional:"true"`
+}
+
+// LoadAppMetadata builds the app in order to load the app metadata. The app is not run. The app log is discarded.
+//
+// NOTE: building the app runs all of the app's Invoke funcs, and the constructors that they depend on. Thus, any side
+// effects, e.g., connecting to a database, are triggered when the manifests are generated. The app's configs are also
+// loaded, which means any required config env vars must be set.
+func LoadAppMetadata(builder fxapp.Builder) (AppMetadata, error) {
+	var params appParams
+	app, err := builder.
+		Invoke(func(p appParams) { params = p }).
+		LogWriter(ioutil.Discard).
+		Build()
+	if err != nil {
+		return AppMetadata{}, err
+	}
+	metadata := AppMetadata{
+		ID:           app.ID(),
+		ReleaseID:    app.ReleaseID(),
+		HTTPAddr:     ":8008",
+		StartTimeout: app.StartTimeout(),
+		StopTimeout:  app.StopTimeout(),
+		DrainDelay:   app.DrainDelay(),
+		TLS:          app.TLSEnabled(),
+		MetricsPath:  "/" + fxapp.MetricsEndpoint,
+	}
+	if params.Server != nil {
+		metadata.HTTPAddr = params.Server.Addr
+	}
+	if params.AdminServer != nil {
+		metadata.AdminHTTPAddr = params.AdminServer.Addr
+	}
+	// the app uses the default metrics endpoint if the endpoint is blank
+	if endpoint := strings.TrimSpace(params.PrometheusHTTPHandlerOpts.Endpoint); endpoint != "" {
+		metadata.MetricsPath = endpoint
+	}
+	return metadata, nil
+}
+
+// ManifestOpts are the deployment specific options, i.e., options that are not defined by the app
+type ManifestOpts struct {
+	// Name is used as the resource names, the container name, and the "app" label - required
+	Name string
+	// Namespace is optional
+	Namespace string
+	// Image is the container image - required
+	Image string
+	// Replicas defaults to `DefaultReplicas`
+	Replicas uint
+	// MinAvailable is the PodDisruptionBudget min available pods - defaults to `DefaultMinAvailable`
+	MinAvailable uint
+}
+
+func (opts ManifestOpts) withDefaults() ManifestOpts {
+	if opts.Replicas == 0 {
+		opts.Replicas = DefaultReplicas
+	}
+	if opts.MinAvailable == 0 {
+		opts.MinAvailable = DefaultMinAvailable
+	}
+	return opts
+}
+
+func (opts ManifestOpts) validate() error {
+	if strings.TrimSpace(opts.Name) == "" {
+		return errors.New("name is required")
+	}
+	if strings.TrimSpace(opts.Image) == "" {
+		return errors.New("image is required")
+	}
+	if opts.MinAvailable > opts.Replicas {
+		return fmt.Errorf("min available must not be greater than replicas: %d > %d", opts.MinAvailable, opts.Replicas)
+	}
+	return nil
+}
+
+// Generate generates the Deployment, Service, and PodDisruptionBudget manifests as a multi-document YAML stream
+func Generate(metadata AppMetadata, opts ManifestOpts) ([]byte, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	httpPort, err := port(metadata.HTTPAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP server address: %q : %v", metadata.HTTPAddr, err)
+	}
+	ports := []containerPort{{Name: "http", ContainerPort: httpPort}}
+	// admin endpoints are served by the admin HTTP server, if one is provided
+	adminPort := ports[0]
+	// TLS only applies to the app HTTP server
+	adminScheme := "HTTP"
+	if metadata.TLS && metadata.AdminHTTPAddr == "" {
+		adminScheme = "HTTPS"
+	}
+	if metadata.AdminHTTPAddr != "" {
+		adminHTTPPort, err := port(metadata.AdminHTTPAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid admin HTTP server address: %q : %v", metadata.AdminHTTPAddr, err)
+		}
+		adminPort = containerPort{Name: fxapp.AdminHTTPServer, ContainerPort: adminHTTPPort}
+		ports = append(ports, adminPort)
+	}
+
+	metricsPath := metadata.MetricsPath
+	if metricsPath == "" {
+		metricsPath = "/" + fxapp.MetricsEndpoint
+	}
+
+	labels := map[string]string{"app": opts.Name}
+	podLabels := map[string]string{
+		"app":               opts.Name,
+		"app12x/id":         ulid.ULID(metadata.ID).String(),
+		"app12x/release-id": ulid.ULID(metadata.ReleaseID).String(),
+	}
+	newProbe := func(path string) *probe {
+		return &probe{HTTPGet: httpGetAction{Path: "/" + path, Port: adminPort.Name, Scheme: adminScheme}}
+	}
+	startupProbe := newProbe(fxapp.StartedEvent)
+	startupProbe.PeriodSeconds = seconds(startupProbePeriod)
+	startupProbe.FailureThreshold = seconds(metadata.StartTimeout) / startupProbe.PeriodSeconds
+	if startupProbe.FailureThreshold == 0 {
+		startupProbe.FailureThreshold = 1
+	}
+
+	deployment := deployment{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Metadata:   objectMeta{Name: opts.Name, Namespace: opts.Namespace, Labels: labels},
+		Spec: deploymentSpec{
+			Replicas: opts.Replicas,
+			Selector: labelSelector{MatchLabels: labels},
+			Template: podTemplateSpec{
+				Metadata: objectMeta{
+					Labels: podLabels,
+					Annotations: map[string]string{
+						"prometheus.io/scrape": "true",
+						"prometheus.io/port":   strconv.Itoa(adminPort.ContainerPort),
+						"prometheus.io/path":   metricsPath,
+						"prometheus.io/scheme": strings.ToLower(adminScheme),
+					},
+				},
+				Spec: podSpec{
+					// the app drains before it is stopped
+					TerminationGracePeriodSeconds: seconds(metadata.DrainDelay + metadata.StopTimeout),
+					Containers: []container{
+						{
+							Name:  opts.Name,
+							Image: opts.Image,
+							Ports: ports,
+							Env: []envVar{
+								{Name: fxapp.EnvconfigPrefix + "_ID", Value: ulid.ULID(metadata.ID).String()},
+								{Name: fxapp.EnvconfigPrefix + "_RELEASE_ID", Value: ulid.ULID(metadata.ReleaseID).String()},
+							},
+							StartupProbe:   startupProbe,
+							ReadinessProbe: newProbe(fxapp.ReadyEvent),
+							LivenessProbe:  newProbe(fxapp.LivenessProbeEvent),
+						},
+					},
+				},
+			},
+		},
+	}
+	service := service{
+		APIVersion: "v1",
+		Kind:       "Service",
+		Metadata:   objectMeta{Name: opts.Name, Namespace: opts.Namespace, Labels: labels},
+		Spec: serviceSpec{
+			Selector: labels,
+			Ports:    []servicePort{{Name: "http", Port: httpPort, TargetPort: "http"}},
+		},
+	}
+	pdb := podDisruptionBudget{
+		APIVersion: "policy/v1",
+		Kind:       "PodDisruptionBudget",
+		Metadata:   objectMeta{Name: opts.Name, Namespace: opts.Namespace, Labels: labels},
+		Spec: podDisruptionBudgetSpec{
+			MinAvailable: opts.MinAvailable,
+			Selector:     labelSelector{MatchLabels: labels},
+		},
+	}
+
+	var manifests bytes.Buffer
+	for i, manifest := range []interface{}{deployment, service, pdb} {
+		if i > 0 {
+			manifests.WriteString("---\n")
+		}
+		data, err := yaml.Marshal(manifest)
+		if err != nil {
+			return nil, err
+		}
+		manifests.Write(data)
+	}
+	return manifests.Bytes(), nil
+}
+
+// port returns the port number for the address, e.g., ":8008", ":http"
+func port(addr string) (int, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, err
+	}
+	if port == "" {
+		port = "http"
+	}
+	return net.LookupPort("tcp", port)
+}
+
+// seconds rounds the duration up to the nearest second
+func seconds(d time.Duration) uint {
+	return uint(math.Ceil(d.Seconds()))
+}
+
+// Kubernetes API types - only the fields that are generated are defined
+
+type objectMeta struct {
+	Name        string            `yaml:"name,omitempty"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type labelSelector struct {
+	MatchLabels map[string]string `yaml:"matchLabels"`
+}
+
+type deployment struct {
+	APIVersion string         `yaml:"apiVersion"`
+	Kind       string         `yaml:"kind"`
+	Metadata   objectMeta     `yaml:"metadata"`
+	Spec       deploymentSpec `yaml:"spec"`
+}
+
+type deploymentSpec struct {
+	Replicas uint            `yaml:"replicas"`
+	Selector labelSelector   `yaml:"selector"`
+	Template podTemplateSpec `yaml:"template"`
+}
+
+type podTemplateSpec struct {
+	Metadata objectMeta `yaml:"metadata"`
+	Spec     podSpec    `yaml:"spec"`
+}
+
+type podSpec struct {
+	TerminationGracePeriodSeconds uint        `yaml:"terminationGracePeriodSeconds"`
+	Containers                    []container `yaml:"containers"`
+}
+
+type container struct {
+	Name           string          `yaml:"name"`
+	Image          string          `yaml:"image"`
+	Ports          []containerPort `yaml:"ports"`
+	Env            []envVar        `yaml:"env"`
+	StartupProbe   *probe          `yaml:"startupProbe"`
+	ReadinessProbe *probe          `yaml:"readinessProbe"`
+	LivenessProbe  *probe          `yaml:"livenessProbe"`
+}
+
+type containerPort struct {
+	Name          string `yaml:"name"`
+	ContainerPort int    `yaml:"containerPort"`
+}
+
+type envVar struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+type probe struct {
+	HTTPGet          httpGetAction `yaml:"httpGet"`
+	PeriodSeconds    uint          `yaml:"periodSeconds,omitempty"`
+	FailureThreshold uint          `yaml:"failureThreshold,omitempty"`
+}
+
+type httpGetAction struct {
+	Path string `yaml:"path"`
+	// Port is the container port name
+	Port string `yaml:"port"`
+	// Scheme is HTTP or HTTPS
+	Scheme string `yaml:"scheme"`
+}
+
+type service struct {
+	APIVersion string      `yaml:"apiVersion"`
+	Kind       string      `yaml:"kind"`
+	Metadata   objectMeta  `yaml:"metadata"`
+	Spec       serviceSpec `yaml:"spec"`
+}
+
+type serviceSpec struct {
+	Selector map[string]string `yaml:"selector"`
+	Ports    []servicePort     `yaml:"ports"`
+}
+
+type servicePort struct {
+	Name       string `yaml:"name"`
+	Port       int    `yaml:"port"`
+	TargetPort string `yaml:"targetPort"`
+}
+
+type podDisruptionBudget struct {
+	APIVersion string                  `yaml:"apiVersion"`
+	Kind       string                  `yaml:"kind"`
+	Metadata   objectMeta              `yaml:"metadata"`
+	Spec       podDisruptionBudgetSpec `yaml:"spec"`
+}
+
+type podDisruptionBudgetSpec struct {
+	MinAvailable uint          `yaml:"minAvailable"`
+	Selector     labelSelector `yaml:"selector"`
+}
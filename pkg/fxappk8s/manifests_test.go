@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxappk8s_test
+
+import (
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxappk8s"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"go.uber.org/fx"
+	"gopkg.in/yaml.v2"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateForApp(t *testing.T) {
+	id, releaseID := ulids.MustNew(), ulids.MustNew()
+	fxappk8s.Register("foo", func() fxapp.Builder {
+		return fxapp.NewBuilder(fxapp.ID(id), fxapp.ReleaseID(releaseID)).
+			SetStartTimeout(30*time.Second).
+			SetStopTimeout(20*time.Second).
+			SetDrainDelay(5*time.Second).
+			Provide(
+				func() *http.Server { return &http.Server{Addr: ":8080"} },
+				fx.Annotated{
+					Name:   fxapp.AdminHTTPServer,
+					Target: func() *http.Server { return &http.Server{Addr: ":8081"} },
+				},
+			).
+			Invoke(func() {})
+	})
+
+	manifests, err := fxappk8s.GenerateForApp("foo", fxappk8s.ManifestOpts{Image: "example.com/foo:1.0.0", Namespace: "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("\n%s", manifests)
+	docs := strings.Split(string(manifests), "---\n")
+	if len(docs) != 3 {
+		t.Fatalf("*** Deployment, Service, and PodDisruptionBudget manifests should have been generated: %d", len(docs))
+	}
+
+	t.Run("Deployment", func(t *testing.T) {
+		type probe struct {
+			HTTPGet struct {
+				Path   string
+				Port   string
+				Scheme string
+			} `yaml:"httpGet"`
+			FailureThreshold uint `yaml:"failureThreshold"`
+		}
+		var deployment struct {
+			Kind     string
+			Metadata struct{ Name, Namespace string }
+			Spec     struct {
+				Replicas uint
+				Template struct {
+					Metadata struct {
+						Labels      map[string]string
+						Annotations map[string]string
+					}
+					Spec struct {
+						TerminationGracePeriodSeconds uint `yaml:"terminationGracePeriodSeconds"`
+						Containers                    []struct {
+							Image string
+							Ports []struct {
+								Name          string
+								ContainerPort int `yaml:"containerPort"`
+							}
+							Env            []struct{ Name, Value string }
+							StartupProbe   probe `yaml:"startupProbe"`
+							ReadinessProbe probe `yaml:"readinessProbe"`
+							LivenessProbe  probe `yaml:"livenessProbe"`
+						}
+					}
+				}
+			}
+		}
+		if err := yaml.Unmarshal([]byte(docs[0]), &deployment); err != nil {
+			t.Fatal(err)
+		}
+		if deployment.Kind != "Deployment" || deployment.Metadata.Name != "foo" || deployment.Metadata.Namespace != "bar" || deployment.Spec.Replicas != fxappk8s.DefaultReplicas {
+			t.Errorf("*** Deployment did not match: %v", deployment)
+		}
+		pod := deployment.Spec.Template
+		if pod.Spec.TerminationGracePeriodSeconds != 25 {
+			t.Errorf("*** termination grace period should be the drain delay plus the stop timeout: %v", pod.Spec.TerminationGracePeriodSeconds)
+		}
+		if pod.Metadata.Labels["app12x/id"] != id.String() || pod.Metadata.Annotations["prometheus.io/port"] != "8081" || pod.Metadata.Annotations["prometheus.io/path"] != "/"+fxapp.MetricsEndpoint {
+			t.Errorf("*** pod template metadata did not match: %v", pod.Metadata)
+		}
+
+		container := pod.Spec.Containers[0]
+		if container.Image != "example.com/foo:1.0.0" || len(container.Ports) != 2 || container.Ports[0].ContainerPort != 8080 || container.Ports[1].ContainerPort != 8081 {
+			t.Errorf("*** container did not match: %v", container)
+		}
+		env := make(map[string]string)
+		for _, envVar := range container.Env {
+			env[envVar.Name] = envVar.Value
+		}
+		if env["APP12X_ID"] != id.String() || env["APP12X_RELEASE_ID"] != releaseID.String() {
+			t.Errorf("*** app ID env vars did not match: %v", env)
+		}
+		for path, probe := range map[string]probe{
+			fxapp.StartedEvent:       container.StartupProbe,
+			fxapp.ReadyEvent:         container.ReadinessProbe,
+			fxapp.LivenessProbeEvent: container.LivenessProbe,
+		} {
+			if probe.HTTPGet.Path != "/"+path || probe.HTTPGet.Port != fxapp.AdminHTTPServer || probe.HTTPGet.Scheme != "HTTP" {
+				t.Errorf("*** probe should be served by the admin HTTP server: %v", probe)
+			}
+		}
+		if container.StartupProbe.FailureThreshold != 30 {
+			t.Errorf("*** startup probe failure threshold should be derived from the start timeout: %v", container.StartupProbe.FailureThreshold)
+		}
+	})
+
+	t.Run("Service and PodDisruptionBudget", func(t *testing.T) {
+		var service struct {
+			Kind string
+			Spec struct {
+				Ports []struct{ Port int }
+			}
+		}
+		if err := yaml.Unmarshal([]byte(docs[1]), &service); err != nil {
+			t.Fatal(err)
+		}
+		if service.Kind != "Service" || len(service.Spec.Ports) != 1 || service.Spec.Ports[0].Port != 8080 {
+			t.Errorf("*** Service did not match: %v", service)
+		}
+
+		var pdb struct {
+			Kind string
+			Spec struct {
+				MinAvailable uint `yaml:"minAvailable"`
+			}
+		}
+		if err := yaml.Unmarshal([]byte(docs[2]), &pdb); err != nil {
+			t.Fatal(err)
+		}
+		if pdb.Kind != "PodDisruptionBudget" || pdb.Spec.MinAvailable != fxappk8s.DefaultMinAvailable {
+			t.Errorf("*** PodDisruptionBudget did not match: %v", pdb)
+		}
+	})
+
+	t.Run("TLS and custom metrics endpoint", func(t *testing.T) {
+		metadata, err := fxappk8s.LoadAppMetadata(fxapp.NewBuilder(fxapp.ID(id), fxapp.ReleaseID(releaseID)).
+			Provide(func() fxapp.PrometheusHTTPHandlerOpts {
+				return fxapp.PrometheusHTTPHandlerOpts{Endpoint: "/metrics"}
+			}).
+			Invoke(func() {}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metadata.MetricsPath != "/metrics" {
+			t.Errorf("*** metrics path should have been loaded from the app: %v", metadata.MetricsPath)
+		}
+		// When the app HTTP server uses TLS and there is no admin HTTP server
+		metadata.TLS = true
+		manifests, err := fxappk8s.Generate(metadata, fxappk8s.ManifestOpts{Name: "foo", Image: "example.com/foo:1.0.0"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		type httpProbe struct {
+			HTTPGet struct{ Scheme string } `yaml:"httpGet"`
+		}
+		var deployment struct {
+			Spec struct {
+				Template struct {
+					Metadata struct {
+						Annotations map[string]string
+					}
+					Spec struct {
+						Containers []struct {
+							StartupProbe   httpProbe `yaml:"startupProbe"`
+							ReadinessProbe httpProbe `yaml:"readinessProbe"`
+							LivenessProbe  httpProbe `yaml:"livenessProbe"`
+						}
+					}
+				}
+			}
+		}
+		if err := yaml.Unmarshal([]byte(strings.Split(string(manifests), "---\n")[0]), &deployment); err != nil {
+			t.Fatal(err)
+		}
+		pod := deployment.Spec.Template
+		if pod.Metadata.Annotations["prometheus.io/path"] != "/metrics" || pod.Metadata.Annotations["prometheus.io/scheme"] != "https" {
+			t.Errorf("*** prometheus annotations did not match: %v", pod.Metadata.Annotations)
+		}
+		container := pod.Spec.Containers[0]
+		for _, probe := range []httpProbe{container.StartupProbe, container.ReadinessProbe, container.LivenessProbe} {
+			if probe.HTTPGet.Scheme != "HTTPS" {
+				t.Errorf("*** probe should use HTTPS: %v", probe)
+			}
+		}
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		if _, err := fxappk8s.GenerateForApp("bar", fxappk8s.ManifestOpts{Image: "example.com/foo:1.0.0"}); err == nil {
+			t.Error("*** app is not registered")
+		}
+		if _, err := fxappk8s.GenerateForApp("foo", fxappk8s.ManifestOpts{}); err == nil {
+			t.Error("*** image is required")
+		}
+		if _, err := fxappk8s.GenerateForApp("foo", fxappk8s.ManifestOpts{Image: "example.com/foo:1.0.0", Replicas: 1, MinAvailable: 2}); err == nil {
+			t.Error("*** min available must not be greater than replicas")
+		}
+	})
+}
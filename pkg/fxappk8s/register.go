@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxappk8s
+
+import (
+	"flag"
+	"fmt"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	buildersMutex sync.Mutex
+	builders      = make(map[string]func() fxapp.Builder)
+)
+
+// Register registers the app builder under the specified name, which is used to select the app when generating
+// manifests. It is meant to be called from an init func. Register panics if the name is blank, the builder func is
+// nil, or the name is already registered.
+func Register(name string, builder func() fxapp.Builder) {
+	buildersMutex.Lock()
+	defer buildersMutex.Unlock()
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+		panic("app name must not be blank")
+	case builder == nil:
+		panic(fmt.Sprintf("app builder func is nil: %s", name))
+	}
+	if _, exists := builders[name]; exists {
+		panic(fmt.Sprintf("app is already registered: %s", name))
+	}
+	builders[name] = builder
+}
+
+// Registered returns the registered app names, sorted
+func Registered() []string {
+	buildersMutex.Lock()
+	defer buildersMutex.Unlock()
+	names := make([]string, 0, len(builders))
+	for name := range builders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GenerateForApp loads the registered app's metadata, and generates its manifests. If the ManifestOpts name is blank,
+// then the app name is used.
+func GenerateForApp(app string, opts ManifestOpts) ([]byte, error) {
+	buildersMutex.Lock()
+	builder, ok := builders[app]
+	buildersMutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("app is not registered: %q : registered apps: %v", app, Registered())
+	}
+	metadata, err := LoadAppMetadata(builder())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load app metadata: %s : %v", app, err)
+	}
+	if strings.TrimSpace(opts.Name) == "" {
+		opts.Name = app
+	}
+	return Generate(metadata, opts)
+}
+
+// Main is the command line entry point, which generates the manifests for a registered app and writes them to stdout.
+//
+// Command Line Flags
+//  -app is the registered app name
+//  -name is the Kubernetes resource name, which defaults to the app name
+//  -namespace is the Kubernetes namespace
+//  -image is the container image
+//  -replicas is the number of Deployment replicas
+//  -min-available is the PodDisruptionBudget min available pods
+func Main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("fxapp-k8s", flag.ContinueOnError)
+	app := flags.String("app", "", "registered app name")
+	var opts ManifestOpts
+	flags.StringVar(&opts.Name, "name", "", "Kubernetes resource name - defaults to the app name")
+	flags.StringVar(&opts.Namespace, "namespace", "", "Kubernetes namespace")
+	flags.StringVar(&opts.Image, "image", "", "container image")
+	flags.UintVar(&opts.Replicas, "replicas", DefaultReplicas, "Deployment replicas")
+	flags.UintVar(&opts.MinAvailable, "min-available", DefaultMinAvailable, "PodDisruptionBudget min available pods")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	manifests, err := GenerateForApp(*app, opts)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(manifests)
+	return err
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapptest
+
+import (
+	"fmt"
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"go.uber.org/fx"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// app test harness timeouts
+const (
+	// ReadyTimeout is how long Run waits for the app to be ready
+	ReadyTimeout = 10 * time.Second
+	// DoneTimeout is how long the test cleanup waits for the app to shutdown
+	DoneTimeout = 10 * time.Second
+)
+
+// App is an app that is running in-memory for a test
+type App struct {
+	fxapp.App
+
+	// Log captures the app's log
+	Log *SyncLog
+	// BaseURL is the app's HTTP server base URL, e.g., http://127.0.0.1:34567
+	BaseURL string
+	// AdminBaseURL is the admin HTTP server base URL. If the app is not run with an admin HTTP server, then admin
+	// endpoints are served by the app's HTTP server, i.e., AdminBaseURL is the same as BaseURL.
+	AdminBaseURL string
+
+	// adminEndpoints are the endpoint paths that are served by the admin HTTP server
+	adminEndpoints []string
+}
+
+// URL returns the HTTP server URL for the specified path, e.g.,
+//
+//	app.URL(fxapp.MetricsEndpoint)
+//
+// If the path is for an admin endpoint, then the admin HTTP server URL is returned - see `AdminURL()`.
+func (a *App) URL(path string) string {
+	if a.isAdminEndpoint(path) {
+		return a.AdminURL(path)
+	}
+	return fmt.Sprintf("%s/%s", a.BaseURL, strings.TrimPrefix(path, "/"))
+}
+
+// AdminURL returns the admin HTTP server URL for the specified path
+func (a *App) AdminURL(path string) string {
+	return fmt.Sprintf("%s/%s", a.AdminBaseURL, strings.TrimPrefix(path, "/"))
+}
+
+func (a *App) isAdminEndpoint(path string) bool {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	path = "/" + strings.TrimPrefix(path, "/")
+	for _, pattern := range a.adminEndpoints {
+		if matchPath(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath returns true if the path matches the endpoint path pattern, where path params, e.g., "{id}", match any path
+// segment, and patterns that end with '/' match the subtree rooted at the pattern
+func matchPath(pattern, path string) bool {
+	patternSegments := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	subtree := strings.HasSuffix(pattern, "/")
+	if subtree {
+		patternSegments = patternSegments[:len(patternSegments)-1]
+		if len(pathSegments) < len(patternSegments) {
+			return false
+		}
+	} else if len(pathSegments) != len(patternSegments) {
+		return false
+	}
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if pathSegments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if segment != pathSegments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Run builds and runs the app, and waits for the app to be ready. The test fails if the app fails to build, or is not
+// ready within the ReadyTimeout. When the test completes, the app is shutdown.
+//
+// The harness configures the builder:
+//	- the HTTP server is bound to a random free port, which enables app tests to run in parallel. Thus, the builder should
+//	  not provide an *http.Server.
+//	- the app log is captured in a SyncLog
+func Run(t *testing.T, builder fxapp.Builder) *App {
+	t.Helper()
+	return run(t, builder, false)
+}
+
+// RunWithAdminServer runs the app the same as `Run()`, but also provides the admin HTTP server (see
+// `fxapp.AdminHTTPServer`), which is bound to its own random free port. Thus, the builder should not provide the admin
+// *http.Server.
+func RunWithAdminServer(t *testing.T, builder fxapp.Builder) *App {
+	t.Helper()
+	return run(t, builder, true)
+}
+
+func run(t *testing.T, builder fxapp.Builder, adminServer bool) *App {
+	t.Helper()
+	addr := freeAddr(t)
+	adminAddr := addr
+	builder = builder.Provide(func() *http.Server { return newHTTPServer(addr) })
+	if adminServer {
+		adminAddr = freeAddr(t)
+		builder = builder.Provide(fx.Annotated{
+			Name:   fxapp.AdminHTTPServer,
+			Target: func() *http.Server { return newHTTPServer(adminAddr) },
+		})
+	}
+	log := NewSyncLog()
+	app, err := builder.LogWriter(log).Build()
+	if err != nil {
+		t.Fatalf("*** app failed to build: %v", err)
+	}
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- app.Run() }()
+	select {
+	case <-app.Ready():
+	case err := <-runErr:
+		t.Fatalf("*** app failed to run: %v\n%v", err, log)
+	case <-time.After(ReadyTimeout):
+		app.Shutdown()
+		t.Fatalf("*** timed out waiting for app to be ready\n%v", log)
+	}
+
+	t.Cleanup(func() {
+		app.Shutdown()
+		select {
+		case <-app.Done():
+		case <-time.After(DoneTimeout):
+			t.Errorf("*** timed out waiting for app to shutdown\n%v", log)
+		}
+	})
+
+	return &App{
+		App:            app,
+		Log:            log,
+		BaseURL:        fmt.Sprintf("http://%s", addr),
+		AdminBaseURL:   fmt.Sprintf("http://%s", adminAddr),
+		adminEndpoints: adminEndpoints(t, log),
+	}
+}
+
+// adminEndpoints returns the endpoint paths that are served by the admin HTTP server, which are logged via the
+// `fxapp.HTTPServerStarting` event
+func adminEndpoints(t *testing.T, log *SyncLog) []string {
+	t.Helper()
+	var paths []string
+	for _, entry := range EventsNamed(log, fxapp.HTTPServerStarting) {
+		var data struct {
+			Server    string
+			Endpoints []string
+		}
+		if err := entry.DecodeData(&data); err != nil {
+			t.Fatalf("*** failed to decode HTTPServerStarting event: %v", err)
+		}
+		if data.Server != fxapp.AdminHTTPServer {
+			continue
+		}
+		for _, endpoint := range data.Endpoints {
+			// endpoints are prefixed with the allowed methods, e.g., "GET,POST /foo"
+			fields := strings.Fields(endpoint)
+			paths = append(paths, fields[len(fields)-1])
+		}
+	}
+	return paths
+}
+
+func newHTTPServer(addr string) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: time.Second,
+		MaxHeaderBytes:    1024,
+	}
+}
+
+// freeAddr returns a loopback address that is bound to a free port
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("*** failed to find a free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapptest_test
+
+import (
+	"github.com/oysterpack/andiamo/pkg/fxapp"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	// the apps are bound to random free ports, which enables the tests to run in parallel
+	for i := 0; i < 3; i++ {
+		t.Run("app", func(t *testing.T) {
+			t.Parallel()
+			app := fxapptest.Run(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+				Invoke(func() {}))
+
+			for _, path := range []string{fxapp.ReadyEvent, fxapp.MetricsEndpoint} {
+				resp, err := http.Get(app.URL(path))
+				if err != nil {
+					t.Fatalf("*** HTTP request failed: %v", err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("*** %s response status did not match: %v", path, resp.StatusCode)
+				}
+			}
+
+			if !strings.Contains(app.Log.String(), fxapp.ReadyEvent) {
+				t.Errorf("*** app log should have been captured: %v", app.Log)
+			}
+		})
+	}
+}
+
+func TestRunWithAdminServer(t *testing.T) {
+	t.Parallel()
+	app := fxapptest.RunWithAdminServer(t, fxapp.NewBuilder(fxapp.ID(ulids.MustNew()), fxapp.ReleaseID(ulids.MustNew())).
+		Provide(func() fxapp.HTTPHandler {
+			return fxapp.NewHTTPHandler("/hello", func(writer http.ResponseWriter, _ *http.Request) {})
+		}).
+		Invoke(func() {}))
+	if app.AdminBaseURL == app.BaseURL {
+		t.Fatalf("*** the admin HTTP server should have been bound to its own port: %v", app.AdminBaseURL)
+	}
+
+	get := func(t *testing.T, url string) int {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("*** HTTP request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// admin endpoints are routed to the admin HTTP server
+	for _, path := range []string{fxapp.ReadyEvent, fxapp.MetricsEndpoint, "/" + fxapp.LivenessProbeEvent + "?debug=1"} {
+		if url := app.URL(path); !strings.HasPrefix(url, app.AdminBaseURL) || get(t, url) != http.StatusOK {
+			t.Errorf("*** admin endpoint should have been served by the admin HTTP server: %v", url)
+		}
+	}
+	if status := get(t, app.BaseURL+"/"+fxapp.ReadyEvent); status != http.StatusNotFound {
+		t.Errorf("*** admin endpoint should not have been served by the app HTTP server: %v", status)
+	}
+	// app endpoints are routed to the app HTTP server
+	if url := app.URL("/hello"); !strings.HasPrefix(url, app.BaseURL) || get(t, url) != http.StatusOK {
+		t.Errorf("*** app endpoint should have been served by the app HTTP server: %v", url)
+	}
+}
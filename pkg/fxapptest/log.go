@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// LogEntry is a parsed log event that was logged via an eventlog logger.
+type LogEntry struct {
+	// app labels
+	AppID      string `json:"a"`
+	ReleaseID  string `json:"r"`
+	InstanceID string `json:"i"`
+
+	Name      string          `json:"n"`
+	Component string          `json:"c"`
+	XID       string          `json:"x"`
+	Level     string          `json:"l"`
+	Timestamp int64           `json:"t"` // Unix time
+	Message   string          `json:"m"`
+	Err       string          `json:"e"`
+	Data      json.RawMessage `json:"d"`
+	Tags      []string        `json:"g"`
+
+	// Line is the raw JSON log line
+	Line string `json:"-"`
+}
+
+// Time returns the log event timestamp
+func (e *LogEntry) Time() time.Time {
+	return time.Unix(e.Timestamp, 0)
+}
+
+// DecodeData decodes the log event data into the specified value, e.g., a pointer to the event's Data struct
+func (e *LogEntry) DecodeData(v interface{}) error {
+	if len(e.Data) == 0 {
+		return fmt.Errorf("log event has no data: %s", e.Line)
+	}
+	return json.Unmarshal(e.Data, v)
+}
+
+func (e *LogEntry) String() string {
+	return e.Line
+}
+
+// ParseLogEntries parses the log lines. Parsing is non-destructive, i.e., the log content is not consumed.
+func ParseLogEntries(log *SyncLog) ([]LogEntry, error) {
+	var entries []LogEntry
+	for _, line := range strings.Split(log.String(), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry := LogEntry{Line: line}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return entries, fmt.Errorf("failed to parse log event: %v : %s", err, line)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// EventsNamed returns the log events with the specified name, in the order they were logged.
+// Lines that fail to parse are skipped.
+func EventsNamed(log *SyncLog, name string) []LogEntry {
+	var events []LogEntry
+	for _, line := range strings.Split(log.String(), "\n") {
+		entry := LogEntry{Line: line}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			continue
+		}
+		if entry.Name == name {
+			events = append(events, entry)
+		}
+	}
+	return events
+}
+
+// AwaitEvent polls the log until an event with the specified name is logged, and returns the first matching event.
+// An error is returned if the event is not logged within the timeout.
+func AwaitEvent(log *SyncLog, name string, timeout time.Duration) (LogEntry, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if events := EventsNamed(log, name); len(events) > 0 {
+			return events[0], nil
+		}
+		if time.Now().After(deadline) {
+			return LogEntry{}, fmt.Errorf("timed out waiting for event to be logged: %s", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// AssertNoErrors fails the test if any error, fatal, or panic level events were logged, or if the log fails to parse.
+func AssertNoErrors(t *testing.T, log *SyncLog) {
+	t.Helper()
+	entries, err := ParseLogEntries(log)
+	if err != nil {
+		t.Errorf("*** %v", err)
+	}
+	for _, entry := range entries {
+		switch entry.Level {
+		case "error", "fatal", "panic":
+			t.Errorf("*** error was logged: %s", entry.Line)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2019 OysterPack, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fxapptest_test
+
+import (
+	"github.com/oysterpack/andiamo/pkg/eventlog"
+	"github.com/oysterpack/andiamo/pkg/fxapptest"
+	"github.com/oysterpack/andiamo/pkg/ulids"
+	"github.com/rs/zerolog"
+	"testing"
+	"time"
+)
+
+type fooEvent struct {
+	id string
+}
+
+func (e fooEvent) MarshalZerologObject(event *zerolog.Event) {
+	event.Str("id", e.id)
+}
+
+func TestAwaitEvent(t *testing.T) {
+	buf := fxapptest.NewSyncLog()
+	logger := eventlog.NewZeroLogger(buf).With().Str("a", "app").Logger()
+	logger = *eventlog.ForComponent(&logger, "foo")
+	event := ulids.MustNew().String()
+	logFoo := eventlog.NewLogger(event, &logger, zerolog.InfoLevel)
+
+	if _, err := fxapptest.AwaitEvent(buf, event, 10*time.Millisecond); err == nil {
+		t.Error("*** await event should have timed out")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		logFoo(fooEvent{"1"}, "foo", "a", "b")
+		logFoo(fooEvent{"2"}, "foo")
+	}()
+	entry, err := fxapptest.AwaitEvent(buf, event, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data struct {
+		ID string
+	}
+	if err := entry.DecodeData(&data); err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case data.ID != "1":
+		t.Errorf("*** the first event should have been returned: %v", entry)
+	case entry.AppID != "app" || entry.Component != "foo" || entry.Level != "info" || entry.Message != "foo" || entry.XID == "":
+		t.Errorf("*** log entry fields did not match: %#v", entry)
+	case len(entry.Tags) != 2:
+		t.Errorf("*** log entry tags did not match: %v", entry.Tags)
+	case entry.Time().IsZero():
+		t.Errorf("*** log entry timestamp is not set: %v", entry)
+	}
+
+	for i := 0; i < 100 && len(fxapptest.EventsNamed(buf, event)) != 2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if count := len(fxapptest.EventsNamed(buf, event)); count != 2 {
+		t.Errorf("*** 2 events should have been logged: %v", count)
+	}
+	fxapptest.AssertNoErrors(t, buf)
+}